@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/service"
+	"github.com/spf13/cobra"
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/net/context"
+)
+
+var (
+	accountContact []string
+	accountYes     bool
+)
+
+// accountDetails is the account printed by account show.
+type accountDetails struct {
+	Email          string   `json:"email"`
+	Directory      string   `json:"directory"`
+	URI            string   `json:"uri"`
+	ID             int      `json:"id"`
+	Contact        []string `json:"contact"`
+	Agreement      string   `json:"agreement,omitempty"`
+	TermsOfService string   `json:"termsOfService,omitempty"`
+}
+
+// accountCmd represents the account command
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage the ACME account stored in etcd",
+}
+
+// rotateKeyCmd represents the account rotate-key command
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Replace the key of the account",
+	Long: `Generate a new key for the account, roll the registration over to it
+with the ACME key-change operation and replace the key stored in etcd. The
+account lock is held during the rotation.`,
+	Run: rotateKey,
+}
+
+// showAccountCmd represents the account show command
+var showAccountCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the registration of the account with the ACME server",
+	Run:   showAccount,
+}
+
+// registerAccountCmd represents the account register command
+var registerAccountCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Register the account and accept the terms of service",
+	Long: `Register the account with the ACME server unless it is already registered,
+and accept the current terms of service with --accept-tos or when prompted,
+without obtaining a certificate. The account lock is held during the
+registration.`,
+	Run: registerAccount,
+}
+
+// updateAccountCmd represents the account update command
+var updateAccountCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update the contact of the account",
+	Long: `Replace the contact URLs of the registration with --contact, such as
+mailto:admin@example.com, and store the updated registration in etcd. The
+account lock is held during the update.`,
+	Run: updateAccount,
+}
+
+// deactivateAccountCmd represents the account deactivate command
+var deactivateAccountCmd = &cobra.Command{
+	Use:   "deactivate",
+	Short: "Deactivate the account and remove it from etcd",
+	Long: `Deactivate the registration with the ACME server, which then refuses any
+request of the account for good, and remove the registration from etcd. The
+key of the account is removed as well unless it is registered with other
+directories. The certificates stored in etcd are left alone. Asks for a
+confirmation unless --yes is given.`,
+	Run: deactivateAccount,
+}
+
+// listAccountsCmd represents the account list command
+var listAccountsCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the accounts stored in etcd and the directories they are registered with",
+	Run:   listAccounts,
+}
+
+func init() {
+	RootCmd.AddCommand(accountCmd)
+	accountCmd.AddCommand(rotateKeyCmd)
+	accountCmd.AddCommand(listAccountsCmd)
+	accountCmd.AddCommand(showAccountCmd)
+	accountCmd.AddCommand(registerAccountCmd)
+	accountCmd.AddCommand(updateAccountCmd)
+	accountCmd.AddCommand(deactivateAccountCmd)
+
+	updateAccountCmd.Flags().StringSliceVar(&accountContact, "contact", []string{}, "The contact URLs of the account, such as mailto:admin@example.com, can be specified multiple times.")
+	deactivateAccountCmd.Flags().BoolVar(&accountYes, "yes", false, "Do not ask for a confirmation")
+}
+
+func listAccounts(cmd *cobra.Command, args []string) {
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts, err := legoetcd.ListAccounts(store)
+	if err != nil {
+		log.Fatalf("error listing the accounts: %s", err)
+	}
+	if accounts == nil {
+		accounts = []*legoetcd.AccountInfo{}
+	}
+	writeOutput(accounts, func(w io.Writer) {
+		fmt.Fprintln(w, "EMAIL\tDIRECTORIES")
+		for _, account := range accounts {
+			directories := account.Directories
+			if account.Legacy {
+				directories = append(directories, "(legacy registration)")
+			}
+			fmt.Fprintf(w, "%s\t%s\n", account.Email, strings.Join(directories, ", "))
+		}
+	})
+}
+
+func rotateKey(cmd *cobra.Command, args []string) {
+	withAccountLock("account rotate-key", rotateAccountKey)
+}
+
+// withAccountLock runs fn holding the account lock, exiting on error.
+func withAccountLock(command string, fn func(store legoetcd.Store) error) {
+	if noEtcdV2 {
+		log.Fatalf("%s requires the etcd v2 keyspace to grab the account lock", command)
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// grab the account lock
+	etcdClient, err := client.New(client.Config{Endpoints: etcdEndpoints})
+	if err != nil {
+		log.Fatalf("error creating a new etcd client: %s", err)
+	}
+	kapi := client.NewKeysAPI(etcdClient)
+	locker := &service.Service{}
+	lockPath := service.AccountLockPath(email)
+	if err := locker.AcquireLock(context.Background(), kapi, lockPath, &service.LockOptions{BreakStale: true}); err != nil {
+		log.Fatalf("error grabbing the account lock %q: %s", lockPath, err)
+	}
+	if err := fn(store); err != nil {
+		locker.Unlock(kapi, lockPath)
+		fatalf(err, "%s", err)
+	}
+	if err := locker.Unlock(kapi, lockPath); err != nil {
+		log.Printf("error releasing the account lock %q: %s", lockPath, err)
+	}
+}
+
+func rotateAccountKey(store legoetcd.Store) error {
+	// create a new ACME client, loading the current key of the account
+	acmeClient, err := loadAccount(store)
+	if err != nil {
+		return err
+	}
+
+	// rotate the key
+	if err := acmeClient.Account.RotateKey(acmeClient); err != nil {
+		return err
+	}
+
+	// save the new key, the old one is no longer accepted by the ACME server.
+	if err := acmeClient.Account.Save(store); err != nil {
+		if key, kerr := acmeClient.Account.PEMKey(); kerr == nil {
+			log.Printf("the new key of the account could not be saved, store it manually:\n%s", key)
+		}
+		return err
+	}
+	log.Printf("rotated the key of the account %s", email)
+	return nil
+}
+
+// loadAccount returns an ACME client of the registered account of --email.
+func loadAccount(store legoetcd.Store) (*legoetcd.Client, error) {
+	acmeClient, err := legoetcd.New(store, acmeServer, email, acme.RSA2048, legoetcd.ChallengeConfig{})
+	if err != nil {
+		return nil, err
+	}
+	if err := acmeClient.Account.LoadRegistration(store); err != nil {
+		if legoetcd.IsKeyNotFound(err) {
+			return nil, legoetcd.ErrAccountNotRegistered
+		}
+		return nil, err
+	}
+	return acmeClient, nil
+}
+
+func showAccount(cmd *cobra.Command, args []string) {
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	acmeClient, err := loadAccount(store)
+	if err != nil {
+		fatalf(err, "error loading the account %s: %s", email, err)
+	}
+	reg := acmeClient.Account.GetRegistration()
+	details := &accountDetails{
+		Email:          email,
+		Directory:      acmeServer,
+		URI:            reg.URI,
+		ID:             reg.Body.ID,
+		Contact:        reg.Body.Contact,
+		Agreement:      reg.Body.Agreement,
+		TermsOfService: reg.TosURL,
+	}
+	if details.Contact == nil {
+		details.Contact = []string{}
+	}
+	writeOutput(details, func(w io.Writer) {
+		fmt.Fprintf(w, "Email:\t%s\n", details.Email)
+		fmt.Fprintf(w, "Directory:\t%s\n", details.Directory)
+		fmt.Fprintf(w, "URI:\t%s\n", details.URI)
+		fmt.Fprintf(w, "ID:\t%d\n", details.ID)
+		fmt.Fprintf(w, "Contact:\t%s\n", strings.Join(details.Contact, ", "))
+		fmt.Fprintf(w, "Agreement:\t%s\n", details.Agreement)
+		fmt.Fprintf(w, "Terms of service:\t%s\n", details.TermsOfService)
+	})
+}
+
+func registerAccount(cmd *cobra.Command, args []string) {
+	withAccountLock("account register", func(store legoetcd.Store) error {
+		acmeClient, err := legoetcd.New(store, acmeServer, email, acme.RSA2048, legoetcd.ChallengeConfig{})
+		if err != nil {
+			return err
+		}
+		if !acceptTOS && isInteractive() {
+			acmeClient.TOSPrompt = promptTOS
+		}
+		if err := acmeClient.RegisterAccount(store, acceptTOS); err != nil {
+			if err == legoetcd.ErrMustAcceptTOS {
+				return errors.New("Please re-run with --accept-tos to indicate you accept Let's encrypt terms of service.")
+			}
+			return err
+		}
+		log.Printf("the account %s is registered at %s", email, acmeClient.Account.GetRegistration().URI)
+		return nil
+	})
+}
+
+func updateAccount(cmd *cobra.Command, args []string) {
+	if len(accountContact) == 0 {
+		log.Fatal("Please specify the contact of the account with --contact")
+	}
+	withAccountLock("account update", func(store legoetcd.Store) error {
+		acmeClient, err := loadAccount(store)
+		if err != nil {
+			return err
+		}
+		if err := acmeClient.Account.UpdateContact(acmeClient, accountContact); err != nil {
+			return err
+		}
+		if err := acmeClient.Account.Save(store); err != nil {
+			return err
+		}
+		log.Printf("updated the contact of the account %s to %s", email, strings.Join(accountContact, ", "))
+		return nil
+	})
+}
+
+func deactivateAccount(cmd *cobra.Command, args []string) {
+	if !accountYes {
+		if !isInteractive() {
+			log.Fatal("Please confirm the deactivation of the account with --yes")
+		}
+		ok, err := promptYesNo(fmt.Sprintf("Deactivate the account %s at %s for good?", email, acmeServer))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			log.Fatal("the account was not deactivated")
+		}
+	}
+	withAccountLock("account deactivate", func(store legoetcd.Store) error {
+		acmeClient, err := loadAccount(store)
+		if err != nil {
+			return err
+		}
+		if err := acmeClient.Account.Deactivate(acmeClient); err != nil {
+			return err
+		}
+		if err := acmeClient.Account.Remove(store); err != nil {
+			return fmt.Errorf("the account was deactivated but could not be removed from etcd: %s", err)
+		}
+		log.Printf("deactivated the account %s", email)
+		return nil
+	})
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupOut        string
+	backupIn         string
+	backupEncryptKey string
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Dump the accounts and the certificates stored in etcd to a tarball",
+	Long: `Dump all the /lego keys, except the locks, to a gzipped tarball which can
+be restored with the restore command, independently of the etcd snapshots.
+The tarball contains the private keys, use --encrypt-key to encrypt it with
+a key generated by "openssl rand -hex 32".`,
+	Run: backup,
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the accounts and the certificates from a backup",
+	Long: `Write all the keys of a tarball created by the backup command to etcd,
+replacing the existing values.`,
+	Run: restore,
+}
+
+func init() {
+	RootCmd.AddCommand(backupCmd)
+	RootCmd.AddCommand(restoreCmd)
+
+	backupCmd.Flags().StringVar(&backupOut, "out", "backup.tar.gz", "The file to write the backup to")
+	backupCmd.Flags().StringVar(&backupEncryptKey, "encrypt-key", "", "File containing the hex encoded 256-bit key to encrypt the backup with")
+	restoreCmd.Flags().StringVar(&backupIn, "in", "backup.tar.gz", "The file to read the backup from")
+	restoreCmd.Flags().StringVar(&backupEncryptKey, "encrypt-key", "", "File containing the hex encoded 256-bit key the backup was encrypted with")
+}
+
+func backup(cmd *cobra.Command, args []string) {
+	// read the key first, not to dump everything for nothing
+	key := readBackupKey()
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	written, err := legoetcd.Backup(store, &buf)
+	if err != nil {
+		log.Fatalf("error backing up the keys: %s", err)
+	}
+	data := buf.Bytes()
+	if key != nil {
+		if data, err = legoetcd.SealBackup(key, data); err != nil {
+			log.Fatalf("error encrypting the backup: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(backupOut, data, 0600); err != nil {
+		log.Fatalf("error writing %s: %s", backupOut, err)
+	}
+	log.Printf("backed up %d keys to %s", written, backupOut)
+}
+
+func restore(cmd *cobra.Command, args []string) {
+	key := readBackupKey()
+	data, err := ioutil.ReadFile(backupIn)
+	if err != nil {
+		log.Fatalf("error reading %s: %s", backupIn, err)
+	}
+	if key != nil {
+		if data, err = legoetcd.OpenBackup(key, data); err != nil {
+			log.Fatalf("error decrypting %s: %s", backupIn, err)
+		}
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	restored, err := legoetcd.Restore(store, bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("error restoring %s after %d keys: %s", backupIn, restored, err)
+	}
+	log.Printf("restored %d keys from %s", restored, backupIn)
+}
+
+// readBackupKey returns the key of --encrypt-key, or nil if it is not set.
+func readBackupKey() []byte {
+	if backupEncryptKey == "" {
+		return nil
+	}
+	keyHex, err := ioutil.ReadFile(backupEncryptKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Fatalf("the backup key %s does not exist", backupEncryptKey)
+		}
+		log.Fatalf("error reading the backup key: %s", err)
+	}
+	key, err := legoetcd.ParseBackupKey(string(keyHex))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the setup before obtaining a certificate",
+	Long: `Check that etcd is reachable and writable and that the ACME directory is
+reachable. With --domains/-d, also check the challenge the certificate would be
+obtained with: the credentials of the --dns provider by creating and removing a
+throwaway TXT record, or that the domains reach the HTTP challenges served on
+--http-addr or written to --webroot and the TLS challenges served on
+--tls-addr. It exits with 1 if any check failed.`,
+	Run: check,
+}
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+}
+
+// checkResult is the result of a check printed by --output.
+type checkResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func check(cmd *cobra.Command, args []string) {
+	failed := false
+	results := []checkResult{}
+	report := func(name string, err error) {
+		result := checkResult{Name: name, OK: err == nil}
+		if err != nil {
+			failed = true
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	report("etcd", legoetcd.CheckStore(store))
+	report("acme directory", legoetcd.CheckDirectory(acmeServer))
+
+	cc := legoetcd.ChallengeConfig{
+		DNS:            dns,
+		WebRoot:        webRoot,
+		HTTPAddr:       httpAddr,
+		TLSAddr:        tlsAddr,
+		DNSAlias:       dnsAlias,
+		DNSFollowCNAME: dnsFollowCNAME,
+	}
+	for _, domain := range domains {
+		switch {
+		case dns != "" && legoetcd.IsIP(domain):
+			report(fmt.Sprintf("challenge for %s", domain), legoetcd.ErrDNSChallengeForIP)
+		case dns != "":
+			report(fmt.Sprintf("dns provider %s for %s", dns, domain), legoetcd.CheckDNSProvider(cc, domain))
+		case strings.HasPrefix(domain, "*."):
+			report(fmt.Sprintf("challenge for %s", domain), fmt.Errorf("a wildcard requires --dns"))
+		default:
+			report(fmt.Sprintf("http challenge for %s", domain), legoetcd.CheckHTTPChallenge(cc, domain))
+			if webRoot == "" {
+				report(fmt.Sprintf("tls challenge for %s", domain), legoetcd.CheckTLSChallenge(cc, domain))
+			}
+		}
+	}
+	writeOutput(results, func(w io.Writer) {
+		for _, result := range results {
+			if !result.OK {
+				fmt.Fprintf(w, "%s:\tFAILED: %s\n", result.Name, result.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%s:\tok\n", result.Name)
+		}
+	})
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// bashCompleteDomains completes --domains/-d with the domains of the
+// certificates stored in the etcd given on the command line.
+const bashCompleteDomains = `__lego-etcd_domains()
+{
+    local etcd_flags=() i
+    for ((i = 1; i < ${#words[@]}; i++)); do
+        case "${words[i]}" in
+            -e|--etcd-endpoints)
+                etcd_flags+=("--etcd-endpoints=${words[i+1]}")
+                ;;
+            --etcd-endpoints=*|--etcd-v3|--no-etcd-v2)
+                etcd_flags+=("${words[i]}")
+                ;;
+        esac
+    done
+    local domains
+    if domains=$(lego-etcd __domains "${etcd_flags[@]}" 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "${domains}" -- "$cur" ) )
+    fi
+}
+`
+
+// fishCompleteDomains is the fish counterpart of bashCompleteDomains.
+const fishCompleteDomains = `function __lego_etcd_domains
+    set -l etcd_flags
+    set -l tokens (commandline -opc)
+    set -l i 1
+    while test $i -le (count $tokens)
+        switch $tokens[$i]
+            case -e --etcd-endpoints
+                set i (math $i + 1)
+                set etcd_flags $etcd_flags --etcd-endpoints=$tokens[$i]
+            case '--etcd-endpoints=*' --etcd-v3 --no-etcd-v2
+                set etcd_flags $etcd_flags $tokens[$i]
+        end
+        set i (math $i + 1)
+    end
+    lego-etcd __domains $etcd_flags 2>/dev/null
+end
+
+`
+
+// completionCmd represents the completion command
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate the shell completion script",
+	Long: `Print the completion script of the shell, completing the commands, the flags
+and the --domains/-d of the certificates stored in the etcd given on the
+command line. For instance:
+
+  lego-etcd completion bash > /etc/bash_completion.d/lego-etcd
+  lego-etcd completion zsh > "${fpath[1]}/_lego-etcd"
+  lego-etcd completion fish > ~/.config/fish/completions/lego-etcd.fish`,
+	Run: completion,
+}
+
+// domainsCmd lists the domains of the stored certificates for the completion
+// scripts.
+var domainsCmd = &cobra.Command{
+	Use:    "__domains",
+	Short:  "List the domains of the stored certificates",
+	Hidden: true,
+	Run:    completeDomains,
+}
+
+func init() {
+	RootCmd.AddCommand(completionCmd)
+	RootCmd.AddCommand(domainsCmd)
+
+	RootCmd.BashCompletionFunction = bashCompleteDomains
+	cobra.MarkFlagCustom(RootCmd.PersistentFlags(), "domains", "__lego-etcd_domains")
+}
+
+func completion(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		log.Fatal("Please specify the shell: bash, zsh or fish")
+	}
+	var err error
+	switch args[0] {
+	case "bash":
+		err = RootCmd.GenBashCompletion(os.Stdout)
+	case "zsh":
+		err = genZshCompletion(os.Stdout)
+	case "fish":
+		err = genFishCompletion(os.Stdout)
+	default:
+		log.Fatalf("unsupported shell %q, expected bash, zsh or fish", args[0])
+	}
+	if err != nil {
+		log.Fatalf("error generating the completion: %s", err)
+	}
+}
+
+// genZshCompletion loads the bash completion through the bash compatibility
+// of zsh.
+func genZshCompletion(w io.Writer) error {
+	if _, err := fmt.Fprint(w, "#compdef lego-etcd\n\nautoload -U +X bashcompinit && bashcompinit\n\n"); err != nil {
+		return err
+	}
+	return RootCmd.GenBashCompletion(w)
+}
+
+// genFishCompletion completes the commands and their flags.
+func genFishCompletion(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(fishCompleteDomains)
+	RootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		writeFishFlag(&buf, "", flag)
+	})
+	for _, c := range RootCmd.Commands() {
+		if !c.IsAvailableCommand() {
+			continue
+		}
+		fmt.Fprintf(&buf, "complete -c lego-etcd -f -n '__fish_use_subcommand' -a %s -d %s\n", c.Name(), fishQuote(c.Short))
+		c.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+			if RootCmd.PersistentFlags().Lookup(flag.Name) == nil {
+				writeFishFlag(&buf, c.Name(), flag)
+			}
+		})
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeFishFlag completes the flag, of the subcommand if it is not empty.
+func writeFishFlag(buf *bytes.Buffer, subcommand string, flag *pflag.Flag) {
+	if flag.Hidden || flag.Deprecated != "" {
+		return
+	}
+	buf.WriteString("complete -c lego-etcd")
+	if subcommand != "" {
+		fmt.Fprintf(buf, " -n '__fish_seen_subcommand_from %s'", subcommand)
+	}
+	if flag.Shorthand != "" {
+		fmt.Fprintf(buf, " -s %s", flag.Shorthand)
+	}
+	fmt.Fprintf(buf, " -l %s", flag.Name)
+	switch {
+	case flag.Name == "domains":
+		buf.WriteString(" -x -a '(__lego_etcd_domains)'")
+	case flag.NoOptDefVal == "":
+		buf.WriteString(" -r")
+	}
+	fmt.Fprintf(buf, " -d %s\n", fishQuote(flag.Usage))
+}
+
+// fishQuote quotes s as a single fish argument.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func completeDomains(cmd *cobra.Command, args []string) {
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	infos, err := legoetcd.ScanCerts(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, info := range infos {
+		for _, san := range info.SANs {
+			if !seen[san] {
+				seen[san] = true
+				names = append(names, san)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
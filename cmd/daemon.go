@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/service"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonAdminAddr       string
+	daemonListen          string
+	daemonScanInterval    time.Duration
+	daemonLeaderElection  bool
+	daemonConcurrency     int
+	daemonRenewalSpread   time.Duration
+	daemonSystemdNotify   bool
+	daemonForceRenewOnHUP bool
+	daemonEtcdV3Locks     bool
+	daemonLockTTL         time.Duration
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Obtain and renew the certificates until stopped",
+	Long: `Run the managed service: obtain the certificates of --domains/-d or --csr/-c,
+or those listed in --domains-file in the format of the obtain command, and
+renew them before they expire, coordinating through etcd with the other
+instances. SIGHUP checks the certificates right away, SIGTERM and SIGINT stop
+the daemon once the renewal in progress completes.
+
+--listen serves the health of the daemon on /healthz, answering 503 while etcd
+is unavailable, and the expiration of the stored certificates as Prometheus
+metrics on /metrics. --admin-addr serves the admin API, which offers no
+authentication and must only be reachable by the operators.`,
+	Run: daemon,
+}
+
+func init() {
+	RootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().StringVar(&domainsFile, "domains-file", "", "The YAML file listing the certificates to manage, in the format of the obtain command")
+	daemonCmd.Flags().BoolVar(&noBundle, "no-bundle", false, "Do not create a certificate bundle by adding the issuers certificate to the new certificate")
+	daemonCmd.Flags().BoolVar(&reuseKey, "reuse-key", false, "Renew the certificates with their private key instead of a new key of the same type")
+	daemonCmd.Flags().DurationVar(&reuseExisting, "reuse-existing", 0, "Reuse a stored certificate covering all the domains of a missing certificate and valid for at least this long, such as 720h, instead of ordering a new one")
+	daemonCmd.Flags().DurationVar(&publishDelay, "publish-delay", 0, "Time to wait between renewing a certificate and saving it to etcd, to let the CT logs and OCSP responders catch up")
+	daemonCmd.Flags().IntVar(&historyRetention, "history-retention", legoetcd.DefaultHistoryRetention, "Number of previous certificates to keep in etcd to allow rolling back, zero disables the history")
+	daemonCmd.Flags().StringVar(&daemonListen, "listen", "", "Serve the health on /healthz and the Prometheus metrics on /metrics on this address, such as :9718")
+	daemonCmd.Flags().DurationVar(&daemonScanInterval, "scan-interval", time.Minute, "How often the certificates are scanned for the metrics")
+	daemonCmd.Flags().StringVar(&daemonAdminAddr, "admin-addr", "", "Serve the admin API on this address, such as localhost:9719. Never expose it publicly.")
+	daemonCmd.Flags().BoolVar(&daemonLeaderElection, "leader-election", false, "Elect one of the daemons sharing the etcd cluster to renew the certificates, the others only watch them")
+	daemonCmd.Flags().IntVar(&daemonConcurrency, "concurrency", 1, "How many certificates are obtained or renewed at once")
+	daemonCmd.Flags().DurationVar(&daemonRenewalSpread, "renewal-spread", 0, "Spread the renewals of the certificates over this period before they are due, such as 168h")
+	daemonCmd.Flags().BoolVar(&daemonSystemdNotify, "systemd-notify", false, "Notify systemd once the certificates are loaded and ping its watchdog, for a unit with Type=notify")
+	daemonCmd.Flags().BoolVar(&daemonForceRenewOnHUP, "force-renew-on-hup", false, "Renew all the certificates on SIGHUP instead of only those due for a renewal")
+	daemonCmd.Flags().BoolVar(&daemonEtcdV3Locks, "etcd-v3-locks", false, "Grab the locks in the etcd v3 keyspace, released as soon as a dead daemon's lease expires, requires --etcd-v3")
+	daemonCmd.Flags().DurationVar(&daemonLockTTL, "lock-ttl", 0, "Time after which the lock of a dead daemon expires, defaults to one hour")
+}
+
+func daemon(cmd *cobra.Command, args []string) {
+	// the service locks and watches in the v2 keyspace
+	if noEtcdV2 {
+		log.Fatal("The daemon requires the etcd v2 keyspace, please remove --no-etcd-v2")
+	}
+	if daemonEtcdV3Locks && !etcdV3 {
+		log.Fatal("Please specify --etcd-v3 when grabbing the locks in the etcd v3 keyspace with --etcd-v3-locks")
+	}
+	var specs []obtainSpec
+	if domainsFile != "" {
+		specs = readDomainsFile(domainsFile)
+	}
+	if len(specs) == 0 || len(domains) > 0 || csr != "" {
+		checkDomains()
+	}
+	kt, ok := parseKeyType(keyType)
+	if !ok {
+		log.Fatalf("unknown key type %q", keyType)
+	}
+
+	s := service.New(client.Config{Endpoints: etcdEndpoints}, acmeServer, email, domains, csr, acceptTOS, pem, dns, webRoot)
+	s.CertChan = nil
+	s.HandleSignals = true
+	s.KeyType = kt
+	s.NoBundle = noBundle
+	s.MustStaple = mustStaple
+	s.PreferredChain = preferredChain
+	s.ReuseKey = reuseKey
+	s.ReuseExistingFor = reuseExisting
+	s.PKCS12Password = pkcs12Password()
+	s.HostPolicy = hostPolicy()
+	if len(caaIdentities) > 0 {
+		s.CAAIdentities = caaIdentities
+	}
+	retry := legoetcd.DefaultRetryPolicy
+	retry.MaxAttempts, retry.Backoff = retryAttempts, retryBackoff
+	s.Retry = &retry
+	if authorizeWebhook != "" {
+		s.Authorizer = legoetcd.WebhookAuthorizer(authorizeWebhook)
+	}
+	s.RenewHook = renewHook
+	s.Notifiers = notifiers()
+	s.RateLimit = rateLimit
+	s.Challenge = flagsChallenge()
+	s.PublishDelay = publishDelay
+	s.HistoryRetention = historyRetention
+	if historyRetention == 0 {
+		s.HistoryRetention = -1
+	}
+	s.EventRetention = eventRetention
+	if etcdV3 {
+		s.EtcdV3Config = &clientv3.Config{Endpoints: etcdEndpoints, DialTimeout: etcdTimeout}
+	}
+	s.LeaderElection = daemonLeaderElection
+	s.Concurrency = daemonConcurrency
+	s.RenewalSpread = daemonRenewalSpread
+	s.SystemdNotify = daemonSystemdNotify
+	s.ForceRenewOnHUP = daemonForceRenewOnHUP
+	s.EtcdV3Locks = daemonEtcdV3Locks
+	s.LockTTL = daemonLockTTL
+	s.AdminAddr = daemonAdminAddr
+
+	// the store is needed by the signing key and the metrics
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if signingKey == "account" {
+		log.Fatal("The daemon cannot sign the certificates with the key of the account, please specify the signing key")
+	}
+	s.SigningKey = certSigningKey(nil, store)
+
+	for _, spec := range specs {
+		cs := service.CertSpec{Domains: spec.Domains}
+		if spec.KeyType != "" {
+			if cs.KeyType, ok = parseKeyType(spec.KeyType); !ok {
+				log.Fatalf("unknown key type %q of the certificate for %v", spec.KeyType, spec.Domains)
+			}
+		}
+		cc := spec.challenge(s.Challenge)
+		cs.Challenge = &cc
+		s.AddCert(cs)
+	}
+
+	if daemonListen != "" {
+		e := &certExporter{}
+		e.scan(store)
+		go func() {
+			for range time.Tick(daemonScanInterval) {
+				e.scan(store)
+			}
+		}()
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", s.HealthHandler())
+		mux.Handle("/metrics", e)
+		go func() {
+			log.Printf("serving the health and the metrics on %s", daemonListen)
+			log.Fatal(http.ListenAndServe(daemonListen, mux))
+		}()
+	}
+
+	if err := s.Run(); err != nil {
+		fatalf(err, "error running the daemon: %s", err)
+	}
+}
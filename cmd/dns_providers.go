@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+// dnsProvidersCmd represents the dns-providers command
+var dnsProvidersCmd = &cobra.Command{
+	Use:   "dns-providers [name]",
+	Short: "List the DNS providers accepted by --dns",
+	Long: `List the DNS providers accepted by --dns and the environment variables
+configuring them, or describe the provider given by name. Only the providers
+not depending on a third-party service are listed with --offline and in the
+offline builds. It does not need etcd.`,
+	Run: listDNSProviders,
+}
+
+func init() {
+	RootCmd.AddCommand(dnsProvidersCmd)
+}
+
+func listDNSProviders(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		log.Fatal("Please specify at most one DNS provider")
+	}
+	legoetcd.Offline = legoetcd.Offline || offline
+
+	providers := legoetcd.DNSProviders()
+	if len(args) == 1 {
+		for _, p := range providers {
+			if p.Name == args[0] {
+				writeOutput(p, func(w io.Writer) {
+					fmt.Fprintf(w, "Name:\t%s\n", p.Name)
+					fmt.Fprintf(w, "Description:\t%s\n", p.Description)
+					fmt.Fprintf(w, "Required:\t%s\n", strings.Join(p.Env, ", "))
+					fmt.Fprintf(w, "Optional:\t%s\n", strings.Join(p.OptionalEnv, ", "))
+					fmt.Fprintf(w, "Notes:\t%s\n", p.Notes)
+				})
+				return
+			}
+		}
+		log.Fatalf("unknown DNS provider %q, see lego-etcd dns-providers", args[0])
+	}
+	if providers == nil {
+		providers = []legoetcd.DNSProviderInfo{}
+	}
+	writeOutput(providers, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tDESCRIPTION\tREQUIRED\tOPTIONAL")
+		for _, p := range providers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, p.Description, strings.Join(p.Env, ", "), strings.Join(p.OptionalEnv, ", "))
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+var eventsFollow bool
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "List or follow the events of the certificates",
+	Long: `List the events recorded in the event log of the certificate of
+--domains/-d, the most recent first. With --follow, the events of all the
+certificates, or only those of --domains/-d, are printed as they are recorded
+until the command is interrupted.`,
+	Run: events,
+}
+
+func init() {
+	RootCmd.AddCommand(eventsCmd)
+
+	eventsCmd.Flags().BoolVarP(&eventsFollow, "follow", "f", false, "Print the events as they are recorded")
+}
+
+func events(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 && !eventsFollow {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !eventsFollow {
+		entries, err := legoetcd.Events(store, domains)
+		if err != nil {
+			log.Fatalf("error loading the events: %s", err)
+		}
+		for _, e := range entries {
+			printEvent(e)
+		}
+		return
+	}
+
+	var etcdClient client.Client
+	if !noEtcdV2 {
+		if etcdClient, err = client.New(client.Config{Endpoints: etcdEndpoints}); err != nil {
+			log.Fatalf("error creating a new etcd client: %s", err)
+		}
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+		<-c
+		cancelFunc()
+	}()
+	for e := range legoetcd.WatchEvents(ctx, store, legoetcd.NewKeysAPI(etcdClient)) {
+		if len(domains) > 0 && (len(e.Domains) == 0 || legoetcd.StorageName(e.Domains[0]) != legoetcd.StorageName(domains[0])) {
+			continue
+		}
+		printEvent(e)
+	}
+}
+
+func printEvent(e *legoetcd.Event) {
+	line := fmt.Sprintf("%s\t%s\t%s\t%s", e.Time.Format("2006-01-02T15:04:05Z07:00"), e.Type, e.Host, strings.Join(e.Domains, ","))
+	if e.Actor != nil {
+		line += fmt.Sprintf("\t%s by %s (pid %d, %s)", e.Operation, e.Actor.User, e.Actor.PID, e.Actor.Source)
+	}
+	if e.NotAfter != nil {
+		line += "\texpires " + e.NotAfter.Format("2006-01-02")
+	}
+	if e.Error != "" {
+		line += "\t" + e.Error
+	}
+	fmt.Println(line)
+}
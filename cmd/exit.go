@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// The exit codes of the commands, documented in the help of RootCmd, for the
+// cron jobs and the orchestration to branch on the outcome. The other
+// failures exit with status 1.
+const (
+	exitOK              = 0
+	exitFailure         = 1
+	exitRenewed         = 2
+	exitValidation      = 3
+	exitRateLimited     = 4
+	exitEtcdUnavailable = 5
+	exitRefused         = 6
+)
+
+// exitCodesHelp documents the exit codes.
+const exitCodesHelp = `Exit codes:
+  0  success, or nothing to do
+  1  any other failure
+  2  a certificate was obtained or renewed
+  3  the CA could not validate the domains
+  4  the issuance is rate limited by the CA or --rate-limit
+  5  etcd is unavailable
+  6  the issuance was refused before ordering by --allow-domains,
+     --deny-domains, --authorize-webhook or the CAA records`
+
+// validationErrorTypes are the suffixes of the ACME error types of the
+// failed validations.
+var validationErrorTypes = []string{":unauthorized", ":connection", ":dns", ":tls", ":caa", ":incorrectResponse", ":unknownHost", ":rejectedIdentifier"}
+
+// exitCode returns the exit code of the error. The errors wrapped with
+// fmt.Errorf are recognized by their message.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	switch err.(type) {
+	case *legoetcd.RateLimitError:
+		return exitRateLimited
+	case *client.ClusterError:
+		return exitEtcdUnavailable
+	case *legoetcd.HostNotAllowedError, *legoetcd.CAAForbiddenError, *legoetcd.AuthorizationDeniedError:
+		return exitRefused
+	}
+	if _, ok := legoetcd.RetryAfter(err); ok {
+		return exitRateLimited
+	}
+	if grpc.Code(err) == codes.Unavailable {
+		return exitEtcdUnavailable
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, ":rateLimited") || strings.Contains(msg, "rate limit of"):
+		return exitRateLimited
+	case strings.Contains(msg, "etcd cluster is unavailable"):
+		return exitEtcdUnavailable
+	case strings.Contains(msg, "not allowed by the host policy") || strings.Contains(msg, "do not authorize") || strings.Contains(msg, "was denied"):
+		return exitRefused
+	}
+	for _, suffix := range validationErrorTypes {
+		if strings.Contains(msg, suffix) {
+			return exitValidation
+		}
+	}
+	return exitFailure
+}
+
+// failuresExitCode returns the exit code of the failures of an order, the
+// first specific code of the failures sorted by domain.
+func failuresExitCode(failures map[string]error) int {
+	var names []string
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if code := exitCode(failures[name]); code != exitFailure {
+			return code
+		}
+	}
+	return exitFailure
+}
+
+// fatalf logs the message and exits with the exit code of the error.
+func fatalf(err error, format string, v ...interface{}) {
+	log.Printf(legoetcd.ErrorPrefix+format, v...)
+	os.Exit(exitCode(err))
+}
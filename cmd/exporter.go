@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exporterAddr     string
+	exporterInterval time.Duration
+)
+
+// exporterCmd represents the exporter command
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Export the expiration of the certificates as Prometheus metrics",
+	Long: `Scan the certificates stored in etcd every --scan-interval and export their
+expiration and their metadata as Prometheus metrics on /metrics. The exporter
+only reads from etcd, it needs no account and may be deployed apart from the
+processes issuing the certificates.`,
+	Run: exporter,
+}
+
+func init() {
+	RootCmd.AddCommand(exporterCmd)
+
+	exporterCmd.Flags().StringVar(&exporterAddr, "listen", ":9717", "The address to serve the metrics on")
+	exporterCmd.Flags().DurationVar(&exporterInterval, "scan-interval", time.Minute, "How often the certificates are scanned")
+}
+
+// certExporter serves the metrics of the last scan.
+type certExporter struct {
+	mu       sync.Mutex
+	metrics  []byte
+	scans    int
+	failures int
+}
+
+func exporter(cmd *cobra.Command, args []string) {
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	e := &certExporter{}
+	e.scan(store)
+	go func() {
+		for range time.Tick(exporterInterval) {
+			e.scan(store)
+		}
+	}()
+	http.Handle("/metrics", e)
+	log.Printf("serving the metrics on %s/metrics", exporterAddr)
+	log.Fatal(http.ListenAndServe(exporterAddr, nil))
+}
+
+// scan scans the certificates, the metrics of the previous scan are kept on
+// error.
+func (e *certExporter) scan(store legoetcd.Store) {
+	infos, err := legoetcd.ScanCerts(store)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scans++
+	if err != nil {
+		log.Printf("error scanning the certificates: %s", err)
+		e.failures++
+		return
+	}
+	var names []string
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "# HELP legoetcd_certificate_expiry_timestamp_seconds The time the certificate expires at.\n")
+	fmt.Fprint(&buf, "# TYPE legoetcd_certificate_expiry_timestamp_seconds gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "legoetcd_certificate_expiry_timestamp_seconds{name=\"%s\"} %d\n", escapeLabel(name), infos[name].NotAfter.Unix())
+	}
+	fmt.Fprint(&buf, "# HELP legoetcd_certificate_not_before_timestamp_seconds The time the certificate is valid from.\n")
+	fmt.Fprint(&buf, "# TYPE legoetcd_certificate_not_before_timestamp_seconds gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "legoetcd_certificate_not_before_timestamp_seconds{name=\"%s\"} %d\n", escapeLabel(name), infos[name].NotBefore.Unix())
+	}
+	fmt.Fprint(&buf, "# HELP legoetcd_certificate_sans The number of names of the certificate.\n")
+	fmt.Fprint(&buf, "# TYPE legoetcd_certificate_sans gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "legoetcd_certificate_sans{name=\"%s\"} %d\n", escapeLabel(name), len(infos[name].SANs))
+	}
+	fmt.Fprint(&buf, "# HELP legoetcd_certificate_info The metadata of the certificate.\n")
+	fmt.Fprint(&buf, "# TYPE legoetcd_certificate_info gauge\n")
+	for _, name := range names {
+		info := infos[name]
+		fmt.Fprintf(&buf, "legoetcd_certificate_info{name=\"%s\",serial=\"%s\",issuer=\"%s\",key_type=\"%s\",written_by=\"%s\"} 1\n",
+			escapeLabel(name), escapeLabel(info.Serial), escapeLabel(info.Issuer), escapeLabel(info.KeyType), escapeLabel(info.WrittenBy))
+	}
+	fmt.Fprint(&buf, "# HELP legoetcd_exporter_last_scan_timestamp_seconds The time of the last successful scan.\n")
+	fmt.Fprint(&buf, "# TYPE legoetcd_exporter_last_scan_timestamp_seconds gauge\n")
+	fmt.Fprintf(&buf, "legoetcd_exporter_last_scan_timestamp_seconds %d\n", time.Now().Unix())
+	e.metrics = buf.Bytes()
+}
+
+// ServeHTTP implements http.Handler
+func (e *certExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(e.metrics)
+	fmt.Fprint(w, "# HELP legoetcd_exporter_scans_total The number of scans of the certificates.\n")
+	fmt.Fprint(w, "# TYPE legoetcd_exporter_scans_total counter\n")
+	fmt.Fprintf(w, "legoetcd_exporter_scans_total %d\n", e.scans)
+	fmt.Fprint(w, "# HELP legoetcd_exporter_scan_failures_total The number of scans which failed.\n")
+	fmt.Fprint(w, "# TYPE legoetcd_exporter_scan_failures_total counter\n")
+	fmt.Fprintf(w, "legoetcd_exporter_scan_failures_total %d\n", e.failures)
+}
+
+// labelEscaper escapes the label values of the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcExpiredFor = dayDuration(90 * 24 * time.Hour)
+	gcDryRun     bool
+)
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove the expired and the orphaned certificates from etcd",
+	Long: `Scan etcd for the certificates which expired more than --expired-for ago,
+the certificates without metadata, the keys left without a certificate and the
+SAN index entries of missing certificates, and remove them. Use --dry-run to
+only report what would be removed.`,
+	Run: gc,
+}
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().Var(&gcExpiredFor, "expired-for", "Remove the certificates expired for longer than this, such as 90d or 720h")
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Only report what would be removed")
+}
+
+func gc(cmd *cobra.Command, args []string) {
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results, err := legoetcd.GC(store, time.Duration(gcExpiredFor), gcDryRun)
+	for _, result := range results {
+		verb := "removed"
+		if gcDryRun {
+			verb = "would remove"
+		}
+		fmt.Printf("%s %s (%s): %s\n", verb, result.Name, result.Reason, strings.Join(result.Keys, ", "))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// dayDuration is a duration flag accepting a number of days, such as 90d.
+type dayDuration time.Duration
+
+func (d *dayDuration) String() string {
+	days := time.Duration(*d) / (24 * time.Hour)
+	if time.Duration(*d) == days*24*time.Hour {
+		return fmt.Sprintf("%dd", days)
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *dayDuration) Set(value string) error {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return err
+		}
+		*d = dayDuration(time.Duration(days) * 24 * time.Hour)
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = dayDuration(duration)
+	return nil
+}
+
+func (d *dayDuration) Type() string {
+	return "duration"
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var historyRollback string
+
+// historyVersion is a previous version of the certificate printed by
+// --output.
+type historyVersion struct {
+	ID       string     `json:"id"`
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	SANs     []string   `json:"sans,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the previous versions of a certificate or roll back to one",
+	Long: `List the versions of the certificate archived when it was renewed, the
+most recent first. Use --rollback with the ID of a version to store it back as
+the current certificate, for instance if a renewal produced a broken chain.`,
+	Run: history,
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVar(&historyRollback, "rollback", "", "The ID of the version to roll back to")
+}
+
+func history(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cert := &legoetcd.Cert{Domains: domains}
+	if historyRollback != "" {
+		if err := cert.Rollback(store, historyRollback, pem); err != nil {
+			log.Fatalf("error rolling back to %s: %s", historyRollback, err)
+		}
+		log.Printf("rolled the certificate for %v back to %s", domains, historyRollback)
+		return
+	}
+
+	entries, err := cert.History(store)
+	if err != nil {
+		log.Fatalf("error loading the history: %s", err)
+	}
+	versions := []historyVersion{}
+	for _, entry := range entries {
+		version := historyVersion{ID: entry.ID}
+		c := &legoetcd.Cert{}
+		c.Cert.Certificate = entry.Certificate
+		if leaf, err := c.Leaf(); err != nil {
+			version.Error = fmt.Sprintf("invalid certificate: %s", err)
+		} else {
+			version.NotAfter = &leaf.NotAfter
+			version.SANs = leaf.DNSNames
+		}
+		versions = append(versions, version)
+	}
+	writeOutput(versions, func(w io.Writer) {
+		for _, version := range versions {
+			if version.Error != "" {
+				fmt.Fprintf(w, "%s\t%s\n", version.ID, version.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%s\texpires %s\t%s\n", version.ID, version.NotAfter.Format("2006-01-02"), strings.Join(version.SANs, ","))
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFrom      string
+	migrateOverwrite bool
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Import the accounts and the certificates of the lego CLI into etcd",
+	Long: `Import the data directory of the lego CLI into etcd, so the accounts
+do not have to be registered again nor the certificates re-issued. The
+accounts registered with --acme-server are imported along with all the
+certificates.`,
+	Run: migrate,
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", filepath.Join(os.Getenv("HOME"), ".lego"), "The data directory of the lego CLI")
+	migrateCmd.Flags().BoolVar(&migrateOverwrite, "overwrite", false, "Overwrite the accounts and the certificates already stored in etcd")
+}
+
+func migrate(cmd *cobra.Command, args []string) {
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	from := migrateFrom
+	if strings.HasPrefix(from, "~/") {
+		from = filepath.Join(os.Getenv("HOME"), from[2:])
+	}
+	report, err := legoetcd.ImportLegoDir(store, from, acmeServer, migrateOverwrite)
+	if err != nil {
+		log.Fatalf("error importing %s: %s", from, err)
+	}
+
+	failed := false
+	for _, kind := range []struct {
+		name    string
+		results map[string]error
+	}{{"account", report.Accounts}, {"certificate", report.Certificates}} {
+		var names []string
+		for name := range kind.results {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch err := kind.results[name]; err {
+			case nil:
+				log.Printf("imported the %s %s", kind.name, name)
+			case legoetcd.ErrAlreadyImported:
+				log.Printf("skipped the %s %s, it already exists in etcd", kind.name, name)
+			default:
+				log.Printf("error importing the %s %s: %s", kind.name, name, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
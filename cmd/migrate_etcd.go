@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var migrateEtcdTo string
+
+// migrateEtcdCmd represents the migrate-etcd command
+var migrateEtcdCmd = &cobra.Command{
+	Use:   "migrate-etcd",
+	Short: "Copy the accounts and the certificates between the etcd v2 and v3 keyspaces",
+	Long: `Copy all the /lego keys, except the locks, from the etcd v2 keyspace to
+the v3 keyspace with --to v3, or back with --to v2, so a cluster can be
+upgraded without losing its accounts and certificates.`,
+	Run: migrateEtcd,
+}
+
+func init() {
+	RootCmd.AddCommand(migrateEtcdCmd)
+
+	migrateEtcdCmd.Flags().StringVar(&migrateEtcdTo, "to", "v3", "The keyspace to copy the keys to, v3 or v2")
+}
+
+func migrateEtcd(cmd *cobra.Command, args []string) {
+	v2, err := newV2Store()
+	if err != nil {
+		log.Fatal(err)
+	}
+	v3, err := newV3Store()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var copied int
+	switch migrateEtcdTo {
+	case "v3":
+		copied, err = legoetcd.CopyStore(v2, v3)
+	case "v2":
+		copied, err = legoetcd.CopyStore(v3, v2)
+	default:
+		log.Fatalf("unknown keyspace %q, expected v2 or v3", migrateEtcdTo)
+	}
+	if err != nil {
+		log.Fatalf("error copying the keys to %s after %d keys: %s", migrateEtcdTo, copied, err)
+	}
+	log.Printf("copied %d keys to the etcd %s keyspace", copied, migrateEtcdTo)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/nomad"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nomadAddress      string
+	nomadNamespace    string
+	nomadVariable     string
+	nomadPollInterval time.Duration
+)
+
+// nomadCmd represents the nomad command
+var nomadCmd = &cobra.Command{
+	Use:   "nomad",
+	Short: "Write the certificate to a Nomad Variable when it changes",
+	Long: `Watch the certificate in etcd and write it to a Nomad Variable every time
+it changes, with the certificate, chain, fullchain and key items. The jobs
+rendering the variable with a template block, such as
+{{ with nomadVar "certs/example.com" }}{{ .fullchain }}{{ end }}, are
+re-rendered and signaled or restarted according to their change_mode. The ACL
+token is read from NOMAD_TOKEN.`,
+	Run: writeNomadVariable,
+}
+
+func init() {
+	RootCmd.AddCommand(nomadCmd)
+
+	address := os.Getenv("NOMAD_ADDR")
+	if address == "" {
+		address = nomad.DefaultAddress
+	}
+	nomadCmd.Flags().StringVar(&nomadAddress, "nomad-address", address, "The address of the Nomad API, defaults to NOMAD_ADDR")
+	nomadCmd.Flags().StringVar(&nomadNamespace, "nomad-namespace", os.Getenv("NOMAD_NAMESPACE"), "The namespace of the variable, defaults to NOMAD_NAMESPACE")
+	nomadCmd.Flags().StringVar(&nomadVariable, "variable", "", "The path of the variable, defaults to certs/<domain>")
+	nomadCmd.Flags().DurationVar(&nomadPollInterval, "poll-interval", time.Minute, "How often the certificate is reloaded in case a change was missed")
+}
+
+func writeNomadVariable(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+	path := nomadVariable
+	if path == "" {
+		path = "certs/" + domains[0]
+	}
+	nomadClient := nomad.New(nomadAddress, os.Getenv("NOMAD_TOKEN"), nomadNamespace)
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watchCert(store, domains, nomadPollInterval, make(chan struct{}), func(cert *legoetcd.Cert) {
+		data, err := legoetcd.NewTemplateData(cert)
+		if err != nil {
+			log.Printf("error reading the certificate: %s", err)
+			return
+		}
+		items := map[string]string{
+			"certificate": data.Leaf,
+			"chain":       data.Chain,
+			"fullchain":   data.Leaf + data.Chain,
+			"not_after":   data.NotAfter.Format(time.RFC3339),
+		}
+		if data.PrivateKey != "" {
+			items["key"] = data.PrivateKey
+		}
+		written, err := nomadClient.PutVariable(path, items)
+		if err != nil {
+			log.Printf("error writing the variable %s: %s", path, err)
+			return
+		}
+		if written {
+			log.Printf("wrote the certificate for %v to the variable %s", domains, path)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
+	"github.com/spf13/cobra"
+	"github.com/xenolf/lego/acme"
+	"gopkg.in/yaml.v2"
+)
+
+var domainsFile string
+
+// obtainCmd represents the obtain command
+var obtainCmd = &cobra.Command{
+	Use:   "obtain",
+	Short: "Obtain many certificates listed in a file",
+	Long: `Obtain all the certificates listed in a YAML file, for instance to onboard a
+large inventory. The certificates already stored in etcd are skipped, so the
+command may be run again after fixing the failures. Each certificate may
+override the key type and the challenge flags:
+
+  certificates:
+  - domains: [example.com, www.example.com]
+    key-type: ec256
+    dns: route53
+  - domains: [static.example.com]
+    webroot: /var/www/static
+
+A summary of the failures is printed at the end, and the command exits with
+the code of the first failure if any certificate could not be obtained, or
+with 2 if any certificate was obtained.`,
+	Run: obtain,
+}
+
+// obtainSpec is a certificate of the domains file.
+type obtainSpec struct {
+	Domains        []string `yaml:"domains"`
+	KeyType        string   `yaml:"key-type"`
+	DNS            string   `yaml:"dns"`
+	WebRoot        string   `yaml:"webroot"`
+	HTTPAddr       string   `yaml:"http-addr"`
+	TLSAddr        string   `yaml:"tls-addr"`
+	DNSAlias       string   `yaml:"dns-alias"`
+	DNSFollowCNAME *bool    `yaml:"dns-follow-cname"`
+}
+
+func init() {
+	RootCmd.AddCommand(obtainCmd)
+
+	obtainCmd.Flags().StringVar(&domainsFile, "domains-file", "", "The YAML file listing the certificates to obtain")
+	obtainCmd.Flags().BoolVar(&noBundle, "no-bundle", false, "Do not create a certificate bundle by adding the issuers certificate to the new certificate")
+}
+
+func obtain(cmd *cobra.Command, args []string) {
+	if domainsFile == "" {
+		log.Fatal("Please specify the certificates with --domains-file")
+	}
+	specs := readDomainsFile(domainsFile)
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failures := make(map[string]error)
+	var obtained, skipped int
+	for i, spec := range specs {
+		name := strings.Join(spec.Domains, ",")
+		if _, err := legoetcd.LoadCert(store, spec.Domains); err == nil {
+			log.Printf("[%d/%d] %s is already stored, skipping", i+1, len(specs), name)
+			skipped++
+			continue
+		}
+		log.Printf("[%d/%d] obtaining %s", i+1, len(specs), name)
+		if err := obtainOne(store, spec); err != nil {
+			log.Printf("[%d/%d] error obtaining %s: %s", i+1, len(specs), name, err)
+			failures[name] = err
+			continue
+		}
+		obtained++
+	}
+
+	fmt.Printf("%d obtained, %d already stored, %d failed\n", obtained, skipped, len(failures))
+	for name, err := range failures {
+		fmt.Printf("FAILED\t%s\t%s\n", name, err)
+	}
+	if len(failures) > 0 {
+		os.Exit(failuresExitCode(failures))
+	}
+	if obtained > 0 {
+		os.Exit(exitRenewed)
+	}
+}
+
+// obtainOne obtains and saves the certificate of the spec, the flags applying
+// to what the spec does not override.
+func obtainOne(store legoetcd.Store, spec obtainSpec) error {
+	name := keyType
+	if spec.KeyType != "" {
+		name = spec.KeyType
+	}
+	kt, ok := parseKeyType(name)
+	if !ok {
+		return fmt.Errorf("unknown key type %q", name)
+	}
+	// create a new ACME client
+	acmeClient, err := legoetcd.New(store, acmeServer, email, kt, spec.challenge(flagsChallenge()))
+	if err != nil {
+		return fmt.Errorf("error creating a new ACME server: %s", err)
+	}
+	configureClient(acmeClient, store)
+
+	// register the account and accept tos
+	if err := acmeClient.RegisterAccount(store, acceptTOS); err != nil {
+		if err == legoetcd.ErrMustAcceptTOS {
+			log.Fatalf("Please re-run with --accept-tos to indicate you accept Let's encrypt terms of service.")
+		}
+		return fmt.Errorf("error registering the account: %s", err)
+	}
+
+	cert, failures := acmeClient.NewCert(spec.Domains, "", !noBundle)
+	for k, v := range failures {
+		return fmt.Errorf("[%s] %s", k, v)
+	}
+
+	// save the certificate
+	cert.PKCS12Password = pkcs12Password()
+	cert.SigningKey = certSigningKey(acmeClient, store)
+	if err := cert.Save(store, pem); err != nil {
+		return fmt.Errorf("error saving the certificate: %s", err)
+	}
+	notifyEvent(store, notify.EventObtained, spec.Domains, cert, nil)
+	if renewHook != "" {
+		if err := legoetcd.RunHook(renewHook, string(notify.EventObtained), cert); err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+// readDomainsFile reads the certificates listed in the domains file.
+func readDomainsFile(path string) []obtainSpec {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("error reading %s: %s", path, err)
+	}
+	var file struct {
+		Certificates []obtainSpec `yaml:"certificates"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		log.Fatalf("error parsing %s: %s", path, err)
+	}
+	for i, spec := range file.Certificates {
+		if len(spec.Domains) == 0 {
+			log.Fatalf("the certificate #%d of %s has no domains", i+1, path)
+		}
+	}
+	return file.Certificates
+}
+
+// flagsChallenge returns the challenge configuration of the flags.
+func flagsChallenge() legoetcd.ChallengeConfig {
+	return legoetcd.ChallengeConfig{
+		DNS:      dns,
+		WebRoot:  webRoot,
+		HTTPEtcd: httpEtcd,
+		HTTPAddr: httpAddr,
+		TLSAddr:  tlsAddr,
+
+		DNSTimeout:         dnsTimeout,
+		DNSPollInterval:    dnsPollInterval,
+		DNSDisablePreCheck: dnsDisablePreCheck,
+		DNSAlias:           dnsAlias,
+		DNSFollowCNAME:     dnsFollowCNAME,
+	}
+}
+
+// challenge returns the challenge configuration of the spec, the overrides of
+// the spec applied to cc.
+func (spec obtainSpec) challenge(cc legoetcd.ChallengeConfig) legoetcd.ChallengeConfig {
+	if spec.DNS != "" || spec.WebRoot != "" || spec.HTTPAddr != "" || spec.TLSAddr != "" {
+		// the spec selects its own challenge
+		cc.DNS, cc.WebRoot, cc.HTTPAddr, cc.TLSAddr = spec.DNS, spec.WebRoot, spec.HTTPAddr, spec.TLSAddr
+		cc.HTTPEtcd = false
+	}
+	if spec.DNSAlias != "" {
+		cc.DNSAlias = spec.DNSAlias
+	}
+	if spec.DNSFollowCNAME != nil {
+		cc.DNSFollowCNAME = *spec.DNSFollowCNAME
+	}
+	return cc
+}
+
+// parseKeyType returns the key type of its name, such as ec256.
+func parseKeyType(name string) (acme.KeyType, bool) {
+	switch strings.ToUpper(name) {
+	case "RSA2048":
+		return acme.RSA2048, true
+	case "RSA4096":
+		return acme.RSA4096, true
+	case "RSA8192":
+		return acme.RSA8192, true
+	case "EC256":
+		return acme.EC256, true
+	case "EC384":
+		return acme.EC384, true
+	}
+	return "", false
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// outputFormats are the values accepted by --output.
+var outputFormats = []string{"table", "json", "yaml"}
+
+// checkOutput validates --output.
+func checkOutput() {
+	for _, format := range outputFormats {
+		if output == format {
+			return
+		}
+	}
+	log.Fatalf("unknown output format %q, expected table, json or yaml", output)
+}
+
+// writeOutput prints v to the standard output as JSON or YAML depending on
+// --output, or calls table to print it as a table. The YAML keys are the JSON
+// keys of v, so the scripts can switch between both formats.
+func writeOutput(v interface{}, table func(w io.Writer)) {
+	var err error
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	case "yaml":
+		err = writeYAML(os.Stdout, v)
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		table(tw)
+		err = tw.Flush()
+	}
+	if err != nil {
+		log.Fatalf("error writing the output: %s", err)
+	}
+}
+
+// writeYAML writes v as YAML, going through its JSON encoding.
+func writeYAML(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := yaml.Unmarshal(b, &generic); err != nil {
+		return err
+	}
+	if b, err = yaml.Marshal(generic); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s", b)
+	return err
+}
@@ -15,15 +15,25 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"strings"
+	"time"
 
-	"github.com/coreos/etcd/client"
 	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
 	"github.com/spf13/cobra"
 	"github.com/xenolf/lego/acme"
 )
 
+// renewResult is the renewed certificate printed by --output.
+type renewResult struct {
+	Domains []string `json:"domains"`
+	*legoetcd.CertInfo
+}
+
 // renewCmd represents the renew command
 var renewCmd = &cobra.Command{
 	Use:   "renew",
@@ -51,13 +61,21 @@ func init() {
 	// renewCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	renewCmd.Flags().BoolVar(&noBundle, "no-bundle", false, "Do not create a certificate bundle by adding the issuers certificate to the new certificate")
+	renewCmd.Flags().IntVar(&historyRetention, "history-retention", legoetcd.DefaultHistoryRetention, "Number of previous certificates to keep in etcd to allow rolling back, zero disables the history")
+	renewCmd.Flags().StringVar(&privateKey, "private-key", "", "Renew the certificate with this PEM-encoded private key, read from a file or from etcd:///path/to/key")
+	renewCmd.Flags().BoolVar(&reuseKey, "reuse-key", false, "Renew the certificate with its private key instead of a new key of the same type")
+	renewCmd.Flags().StringVar(&verifyEndpoint, "verify-endpoint", "", "Check that this endpoint, such as https://example.com:443, serves the renewed certificate")
+	renewCmd.Flags().DurationVar(&verifyTimeout, "verify-timeout", 5*time.Minute, "How long --verify-endpoint may take to serve the renewed certificate")
+	renewCmd.Flags().DurationVar(&publishDelay, "publish-delay", 0, "Time to wait between renewing the certificate and saving it to etcd, to let the CT logs and OCSP responders catch up")
 }
 
 func renew(cmd *cobra.Command, args []string) {
-	// create an etcd client
-	etcdClient, err := client.New(client.Config{Endpoints: etcdEndpoints})
+	checkDomains()
+
+	// create the etcd store
+	store, err := newStore()
 	if err != nil {
-		log.Fatalf("error creating a new etcd client: %s", err)
+		log.Fatal(err)
 	}
 
 	// figure our the key-type
@@ -78,32 +96,82 @@ func renew(cmd *cobra.Command, args []string) {
 	}
 
 	// create a new ACME client
-	acmeClient, err := legoetcd.New(etcdClient, acmeServer, email, kt, dns, webRoot, httpAddr, tlsAddr)
+	acmeClient, err := legoetcd.New(store, acmeServer, email, kt, legoetcd.ChallengeConfig{
+		DNS:      dns,
+		WebRoot:  webRoot,
+		HTTPEtcd: httpEtcd,
+		HTTPAddr: httpAddr,
+		TLSAddr:  tlsAddr,
+
+		DNSTimeout:         dnsTimeout,
+		DNSPollInterval:    dnsPollInterval,
+		DNSDisablePreCheck: dnsDisablePreCheck,
+		DNSAlias:           dnsAlias,
+		DNSFollowCNAME:     dnsFollowCNAME,
+	})
 	if err != nil {
 		log.Fatalf("error creating a new ACME server: %s", err)
 	}
+	configureClient(acmeClient, store)
 
 	// register the account and accept tos
-	if err := acmeClient.RegisterAccount(etcdClient, acceptTOS); err != nil {
+	if err := acmeClient.RegisterAccount(store, acceptTOS); err != nil {
 		if err == legoetcd.ErrMustAcceptTOS {
 			log.Fatalf("Please re-run with --accept-tos to indicate you accept Let's encrypt terms of service.")
 		}
-		log.Fatalf("error registering the account: %s", err)
+		fatalf(err, "error registering the account: %s", err)
 	}
 
 	// load the certificate
-	cert, err := legoetcd.LoadCert(etcdClient, domains)
+	cert, err := legoetcd.LoadCert(store, domains)
 	if err != nil {
-		log.Fatalf("error load the certificate from etcd: %s", err)
+		fatalf(err, "error load the certificate from etcd: %s", err)
 	}
 
 	// Renew the certificate
 	if err := cert.Renew(acmeClient, !noBundle); err != nil {
-		log.Fatalf("error renewing the certificate: %s", err)
+		sendNotification(store, notify.EventRenewalFailed, nil, err)
+		fatalf(err, "error renewing the certificate: %s", err)
+	}
+
+	// wait before publishing the certificate
+	if publishDelay > 0 {
+		log.Printf("waiting %s before publishing the certificate", publishDelay)
+		time.Sleep(publishDelay)
+	}
+
+	// archive the previous certificate and save the new one
+	if err := cert.Archive(store, historyRetention); err != nil {
+		log.Fatalf("error archiving the previous certificate: %s", err)
+	}
+	cert.PKCS12Password = pkcs12Password()
+	cert.SigningKey = certSigningKey(acmeClient, store)
+	if err := cert.Save(store, pem); err != nil {
+		if err == legoetcd.ErrConflict {
+			log.Fatalf("the certificate was replaced by someone else while renewing it, not saving the renewed one")
+		}
+		sendNotification(store, notify.EventRenewalFailed, nil, err)
+		fatalf(err, "error saving the certificate: %s", err)
+	}
+	sendNotification(store, notify.EventRenewed, cert, nil)
+	runRenewHook(notify.EventRenewed, cert)
+
+	// make sure the consumers picked up the renewed certificate
+	if verifyEndpoint != "" {
+		if err := cert.VerifyDeployment(verifyEndpoint, verifyTimeout); err != nil {
+			sendNotification(store, notify.EventDeploymentFailed, cert, err)
+			log.Fatalf("the renewed certificate is not deployed: %s", err)
+		}
 	}
 
-	// save the certificate
-	if err := cert.Save(etcdClient, pem); err != nil {
-		log.Fatalf("error saving the certificate: %s", err)
+	// print the renewed certificate
+	info, err := cert.Info()
+	if err != nil {
+		log.Fatalf("error reading the renewed certificate: %s", err)
 	}
+	writeOutput(&renewResult{Domains: cert.Domains, CertInfo: info}, func(w io.Writer) {
+		fmt.Fprintln(w, "DOMAINS\tSERIAL\tEXPIRES")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.Join(cert.Domains, ","), info.Serial, info.NotAfter.Format("2006-01-02"))
+	})
+	os.Exit(exitRenewed)
 }
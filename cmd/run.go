@@ -4,9 +4,10 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/coreos/etcd/client"
 	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
 	"github.com/spf13/cobra"
 	"github.com/xenolf/lego/acme"
 )
@@ -38,13 +39,33 @@ func init() {
 	// runCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	runCmd.Flags().BoolVar(&noBundle, "no-bundle", false, "Do not create a certificate bundle by adding the issuers certificate to the new certificate")
+	runCmd.Flags().StringVar(&privateKey, "private-key", "", "Obtain the certificate with this PEM-encoded private key, read from a file or from etcd:///path/to/key, instead of a generated one")
+	runCmd.Flags().DurationVar(&reuseExisting, "reuse-existing", 0, "Reuse a stored certificate covering all the domains and valid for at least this long, such as 720h, instead of ordering a new one")
+	runCmd.Flags().IntVar(&days, "days", 30, "Skip ordering if a stored certificate covers the domains and is valid for more than this many days")
+	runCmd.Flags().BoolVar(&force, "force", false, "Order a new certificate even if a stored certificate covers the domains")
+	runCmd.Flags().StringVar(&spiffeID, "spiffe-id", "", "Encode this SPIFFE ID (spiffe://trust-domain/path) as a URI SAN, requires an internal CA allowing URI SANs")
 }
 
 func run(cmd *cobra.Command, args []string) {
-	// create an etcd client
-	etcdClient, err := client.New(client.Config{Endpoints: etcdEndpoints})
+	checkDomains()
+
+	// create the etcd store
+	store, err := newStore()
 	if err != nil {
-		log.Fatalf("error creating a new etcd client: %s", err)
+		log.Fatal(err)
+	}
+
+	// the certificate may already be stored by a previous run
+	if !force && csr == "" {
+		cert, err := legoetcd.FindValidCert(store, domains, time.Duration(days)*24*time.Hour)
+		switch {
+		case err == nil:
+			notAfter, _ := cert.Expiration()
+			log.Printf("a stored certificate covering %v is valid until %s, skipping (use --force to order a new one)", domains, notAfter.Format(time.RFC3339))
+			os.Exit(exitOK)
+		case !legoetcd.IsKeyNotFound(err):
+			fatalf(err, "error loading the stored certificate: %s", err)
+		}
 	}
 
 	// figure our the key-type
@@ -65,30 +86,56 @@ func run(cmd *cobra.Command, args []string) {
 	}
 
 	// create a new ACME client
-	acmeClient, err := legoetcd.New(etcdClient, acmeServer, email, kt, dns, webRoot, httpAddr, tlsAddr)
+	acmeClient, err := legoetcd.New(store, acmeServer, email, kt, legoetcd.ChallengeConfig{
+		DNS:      dns,
+		WebRoot:  webRoot,
+		HTTPEtcd: httpEtcd,
+		HTTPAddr: httpAddr,
+		TLSAddr:  tlsAddr,
+
+		DNSTimeout:         dnsTimeout,
+		DNSPollInterval:    dnsPollInterval,
+		DNSDisablePreCheck: dnsDisablePreCheck,
+		DNSAlias:           dnsAlias,
+		DNSFollowCNAME:     dnsFollowCNAME,
+	})
 	if err != nil {
 		log.Fatalf("error creating a new ACME server: %s", err)
 	}
+	configureClient(acmeClient, store)
 
 	// register the account and accept tos
-	if err := acmeClient.RegisterAccount(etcdClient, acceptTOS); err != nil {
+	if err := acmeClient.RegisterAccount(store, acceptTOS); err != nil {
 		if err == legoetcd.ErrMustAcceptTOS {
 			log.Fatalf("Please re-run with --accept-tos to indicate you accept Let's encrypt terms of service.")
 		}
-		log.Fatalf("error registering the account: %s", err)
+		fatalf(err, "error registering the account: %s", err)
 	}
 
 	// create a new certificate for domains or csr.
-	cert, failures := acmeClient.NewCert(domains, csr, !noBundle)
+	var (
+		cert     *legoetcd.Cert
+		failures map[string]error
+	)
+	if spiffeID != "" {
+		cert, failures = acmeClient.NewSPIFFECert(domains, spiffeID, !noBundle)
+	} else {
+		cert, failures = acmeClient.NewCert(domains, csr, !noBundle)
+	}
 	if len(failures) > 0 {
 		for k, v := range failures {
 			log.Printf("[%s] Could not obtain certificates\n\t%s", k, v.Error())
 		}
-		os.Exit(1)
+		os.Exit(failuresExitCode(failures))
 	}
 
 	// save the certificate
-	if err := cert.Save(etcdClient, pem); err != nil {
-		log.Fatalf("error saving the certificate: %s", err)
+	cert.PKCS12Password = pkcs12Password()
+	cert.SigningKey = certSigningKey(acmeClient, store)
+	if err := cert.Save(store, pem); err != nil {
+		fatalf(err, "error saving the certificate: %s", err)
 	}
+	sendNotification(store, notify.EventObtained, cert, nil)
+	runRenewHook(notify.EventObtained, cert)
+	os.Exit(exitRenewed)
 }
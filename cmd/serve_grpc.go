@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/delivery"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	grpcListen       string
+	grpcTLSCert      string
+	grpcTLSKey       string
+	grpcPollInterval time.Duration
+)
+
+// serveGRPCCmd represents the serve-grpc command
+var serveGRPCCmd = &cobra.Command{
+	Use:   "serve-grpc",
+	Short: "Stream the certificate to the subscribers over gRPC",
+	Long: `Watch the certificate in etcd and stream it, with its key and its
+expiration, to the clients subscribed to the legoetcd.Delivery/Subscribe
+method, for the services without access to etcd. The clients receive the
+current certificate when they subscribe, then every renewal. The messages are
+JSON-encoded, see the delivery package. The clients must send the token read
+from LEGO_ETCD_GRPC_TOKEN, if set, and the private key is only safe to stream
+with --tls-cert and --tls-key.`,
+	Run: serveGRPC,
+}
+
+func init() {
+	RootCmd.AddCommand(serveGRPCCmd)
+
+	serveGRPCCmd.Flags().StringVar(&grpcListen, "listen", ":8443", "The address to listen on")
+	serveGRPCCmd.Flags().StringVar(&grpcTLSCert, "tls-cert", "", "The certificate of the gRPC server")
+	serveGRPCCmd.Flags().StringVar(&grpcTLSKey, "tls-key", "", "The private key of the gRPC server")
+	serveGRPCCmd.Flags().DurationVar(&grpcPollInterval, "poll-interval", time.Minute, "How often the certificate is reloaded in case a change was missed")
+}
+
+func serveGRPC(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+	opts := delivery.ServerOptions()
+	if grpcTLSCert != "" || grpcTLSKey != "" {
+		creds, err := credentials.NewServerTLSFromFile(grpcTLSCert, grpcTLSKey)
+		if err != nil {
+			log.Fatalf("error loading the TLS certificate: %s", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	} else {
+		log.Print("serving without TLS, the private key is sent in clear")
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := delivery.NewServer(os.Getenv("LEGO_ETCD_GRPC_TOKEN"))
+	gs := grpc.NewServer(opts...)
+	server.Register(gs)
+	l, err := net.Listen("tcp", grpcListen)
+	if err != nil {
+		log.Fatalf("error listening on %s: %s", grpcListen, err)
+	}
+	go func() {
+		log.Fatal(gs.Serve(l))
+	}()
+
+	watchCert(store, domains, grpcPollInterval, make(chan struct{}), func(cert *legoetcd.Cert) {
+		server.Publish(cert)
+		log.Printf("published the certificate for %v at revision %d", domains, cert.Revision)
+	})
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sidecarOut          string
+	sidecarExec         string
+	sidecarPollInterval time.Duration
+)
+
+// sidecarCmd represents the sidecar command
+var sidecarCmd = &cobra.Command{
+	Use:   "sidecar",
+	Short: "Keep the certificate on disk current, without ever talking to the CA",
+	Long: `Watch the certificate in etcd and write it to the --out directory every
+time it changes, the same way as the lego CLI: <domain>.crt, <domain>.key,
+<domain>.issuer.crt and <domain>.pem with --pem. It is meant to run next to
+workloads which read their certificate from disk, while a central instance
+obtains and renews the certificates.`,
+	Run: sidecar,
+}
+
+func init() {
+	RootCmd.AddCommand(sidecarCmd)
+
+	sidecarCmd.Flags().StringVar(&sidecarOut, "out", ".", "The directory to write the certificate to")
+	sidecarCmd.Flags().StringVar(&sidecarExec, "exec", "", "Command run with sh after the certificate was written")
+	sidecarCmd.Flags().DurationVar(&sidecarPollInterval, "poll-interval", time.Minute, "How often the certificate is reloaded in case a change was missed")
+}
+
+// sidecarFile is a file written by the sidecar.
+type sidecarFile struct {
+	name     string
+	contents string
+}
+
+func sidecar(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+	if err := os.MkdirAll(sidecarOut, 0700); err != nil {
+		log.Fatalf("error creating %s: %s", sidecarOut, err)
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watchCert(store, domains, sidecarPollInterval, make(chan struct{}), func(cert *legoetcd.Cert) {
+		data, err := legoetcd.NewTemplateData(cert)
+		if err != nil {
+			log.Printf("error reading the certificate: %s", err)
+			return
+		}
+		base := filepath.Join(sidecarOut, domains[0])
+		files := []sidecarFile{
+			{base + ".crt", data.Certificate},
+			{base + ".issuer.crt", data.Chain},
+			{base + ".key", data.PrivateKey},
+		}
+		if pem {
+			files = append(files, sidecarFile{base + ".pem", string(cert.PEM())})
+		}
+		changed := false
+		for _, f := range files {
+			if f.contents == "" {
+				continue
+			}
+			written, err := legoetcd.WriteFileIfChanged(f.name, []byte(f.contents), 0600)
+			if err != nil {
+				log.Printf("error writing %s: %s", f.name, err)
+				return
+			}
+			changed = changed || written
+		}
+		if !changed {
+			return
+		}
+		log.Printf("wrote the certificate for %v, valid until %s", domains, data.NotAfter)
+		if sidecarExec != "" {
+			c := exec.Command("sh", "-c", sidecarExec)
+			c.Stdout, c.Stderr = os.Stdout, os.Stderr
+			if err := c.Run(); err != nil {
+				log.Printf("error running %q: %s", sidecarExec, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/service"
+	"github.com/spf13/cobra"
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/net/context"
+)
+
+// soakGoroutineSlack is the number of goroutines the process may grow by, for
+// the idle connections of the HTTP and etcd clients, before reporting a leak.
+const soakGoroutineSlack = 20
+
+var (
+	soakDuration           time.Duration
+	soakInterval           time.Duration
+	soakInsecureSkipVerify bool
+)
+
+// soakCmd represents the soak command
+var soakCmd = &cobra.Command{
+	Use:   "soak",
+	Short: "Exercise issuance, renewal and watch cycles for a long time",
+	Long: `Repeatedly issue, renew, watch and reload a certificate against a test
+ACME CA (such as Pebble) and a local etcd, checking for goroutine leaks, lock
+leaks and missed watch events. Never point it to a production CA.`,
+	Hidden: true,
+	Run:    soak,
+}
+
+func init() {
+	RootCmd.AddCommand(soakCmd)
+
+	soakCmd.Flags().DurationVar(&soakDuration, "duration", time.Hour, "How long to run the cycles for")
+	soakCmd.Flags().DurationVar(&soakInterval, "interval", 10*time.Second, "Time to wait between two cycles")
+	soakCmd.Flags().BoolVar(&soakInsecureSkipVerify, "insecure-skip-verify", false, "Do not verify the certificate of the ACME server, as Pebble's is self-signed, same as --acme-insecure-skip-verify")
+}
+
+// soakStats are the results of a soak run.
+type soakStats struct {
+	mu         sync.Mutex
+	cycles     int
+	failures   int
+	saves      int
+	events     int
+	lockLeaks  int
+	goroutines int
+}
+
+func (s *soakStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("cycles=%d failures=%d saves=%d events=%d missed-events=%d lock-leaks=%d goroutines=%d",
+		s.cycles, s.failures, s.saves, s.events, s.saves-s.events, s.lockLeaks, s.goroutines)
+}
+
+func soak(cmd *cobra.Command, args []string) {
+	checkDomains()
+	if noEtcdV2 {
+		log.Fatal("soak requires the etcd v2 keyspace to watch the certificate")
+	}
+	if soakInsecureSkipVerify && !acmeInsecure {
+		skipACMEVerify()
+	}
+
+	// create the etcd store and client
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	etcdClient, err := client.New(client.Config{Endpoints: etcdEndpoints})
+	if err != nil {
+		log.Fatalf("error creating a new etcd client: %s", err)
+	}
+	kapi := client.NewKeysAPI(etcdClient)
+
+	// create a new ACME client
+	acmeClient, err := legoetcd.New(store, acmeServer, email, acme.RSA2048, legoetcd.ChallengeConfig{
+		DNS:      dns,
+		WebRoot:  webRoot,
+		HTTPAddr: httpAddr,
+		TLSAddr:  tlsAddr,
+	})
+	if err != nil {
+		log.Fatalf("error creating a new ACME server: %s", err)
+	}
+	configureClient(acmeClient, store)
+	if err := acmeClient.RegisterAccount(store, true); err != nil {
+		log.Fatalf("error registering the account: %s", err)
+	}
+
+	stats := &soakStats{}
+	cert := &legoetcd.Cert{Domains: domains}
+	stop := make(chan struct{})
+	watching := make(chan struct{})
+	go soakWatch(kapi, cert.CertPath(), stats, watching, stop)
+	<-watching
+
+	baseline := runtime.NumGoroutine()
+	locker := &service.Service{}
+	deadline := time.Now().Add(soakDuration)
+	for time.Now().Before(deadline) {
+		if err := soakCycle(kapi, store, acmeClient, locker, stats); err != nil {
+			log.Printf("cycle failed: %s", err)
+			stats.mu.Lock()
+			stats.failures++
+			stats.mu.Unlock()
+		}
+		stats.mu.Lock()
+		stats.cycles++
+		stats.goroutines = runtime.NumGoroutine() - baseline
+		stats.mu.Unlock()
+		log.Printf("soak: %s", stats)
+		time.Sleep(soakInterval)
+	}
+	close(stop)
+
+	log.Printf("soak finished: %s", stats)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.failures > 0 || stats.saves != stats.events || stats.lockLeaks > 0 || stats.goroutines > soakGoroutineSlack {
+		log.Fatal("soak detected problems")
+	}
+}
+
+// soakCycle issues, saves, renews, saves and reloads the certificate under
+// the certificate lock.
+func soakCycle(kapi legoetcd.KeysAPI, store legoetcd.Store, acmeClient *legoetcd.Client, locker *service.Service, stats *soakStats) error {
+	lockPath := service.CertLockPath(domains[0])
+	if err := locker.Lock(kapi, lockPath); err != nil {
+		return fmt.Errorf("error grabbing the lock: %s", err)
+	}
+	err := func() error {
+		cert, failures := acmeClient.NewCert(domains, csr, true)
+		for k, v := range failures {
+			return fmt.Errorf("[%s] could not obtain the certificate: %s", k, v)
+		}
+		if err := soakSave(store, cert, stats); err != nil {
+			return err
+		}
+		if err := cert.Renew(acmeClient, true); err != nil {
+			return fmt.Errorf("error renewing the certificate: %s", err)
+		}
+		if err := soakSave(store, cert, stats); err != nil {
+			return err
+		}
+		loaded, err := legoetcd.LoadCert(store, domains)
+		if err != nil {
+			return fmt.Errorf("error loading the certificate: %s", err)
+		}
+		if !bytes.Equal(loaded.Cert.Certificate, cert.Cert.Certificate) {
+			return fmt.Errorf("the loaded certificate is not the renewed one")
+		}
+		return nil
+	}()
+	if uerr := locker.Unlock(kapi, lockPath); uerr != nil && err == nil {
+		err = fmt.Errorf("error releasing the lock: %s", uerr)
+	}
+	// the lock must be gone
+	if _, lerr := service.ReadLock(kapi, lockPath); !client.IsKeyNotFound(lerr) {
+		stats.mu.Lock()
+		stats.lockLeaks++
+		stats.mu.Unlock()
+	}
+	return err
+}
+
+func soakSave(store legoetcd.Store, cert *legoetcd.Cert, stats *soakStats) error {
+	if err := cert.Save(store, false); err != nil {
+		return fmt.Errorf("error saving the certificate: %s", err)
+	}
+	stats.mu.Lock()
+	stats.saves++
+	stats.mu.Unlock()
+	return nil
+}
+
+// soakWatch counts the changes to the certificate.
+func soakWatch(kapi legoetcd.KeysAPI, path string, stats *soakStats, watching, stop chan struct{}) {
+	var index uint64
+	ctx, cancelFunc := legoetcd.DefaultBudgets.EtcdContext()
+	resp, err := kapi.Get(ctx, path, nil)
+	cancelFunc()
+	if err == nil {
+		index = resp.Index
+	} else if cerr, ok := err.(client.Error); ok {
+		index = cerr.Index
+	}
+	close(watching)
+	ctx, cancelFunc = context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancelFunc()
+	}()
+	w := kapi.Watcher(path, &client.WatcherOptions{AfterIndex: index})
+	for {
+		resp, err := w.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("error watching the certificate: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if resp.Action == "set" {
+			stats.mu.Lock()
+			stats.events++
+			stats.mu.Unlock()
+		}
+	}
+}
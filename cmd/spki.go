@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+// spkiCmd represents the spki command
+var spkiCmd = &cobra.Command{
+	Use:   "spki",
+	Short: "Print the SPKI pins of the current and the upcoming key of a certificate",
+	Long: `Print the base64 SHA-256 SPKI pin of the key of the certificate, for HPKP-like
+pinning or DANE TLSA records. Unless --reuse-key is set, the key the
+certificate is renewed with next is generated and stored if it was not already,
+and its pin is printed too so it can be published ahead of the renewal.`,
+	Run: spki,
+}
+
+func init() {
+	RootCmd.AddCommand(spkiCmd)
+
+	spkiCmd.Flags().BoolVar(&reuseKey, "reuse-key", false, "The certificate is renewed with its private key, there is no upcoming key")
+}
+
+func spki(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cert, err := legoetcd.LoadCert(store, domains)
+	if err != nil {
+		log.Fatalf("error load the certificate from etcd: %s", err)
+	}
+	current, err := cert.SPKIHash()
+	if err != nil {
+		log.Fatalf("error computing the pin of the certificate: %s", err)
+	}
+	fmt.Printf("current\t%s\n", current)
+	if reuseKey {
+		return
+	}
+
+	if cert.NextKey == nil {
+		if err := cert.PrepareNextKey(""); err != nil {
+			log.Fatalf("error generating the next key: %s", err)
+		}
+		if err := cert.Save(store, pem); err != nil {
+			log.Fatalf("error saving the next key: %s", err)
+		}
+	}
+	next, err := cert.NextSPKIHash()
+	if err != nil {
+		log.Fatalf("error computing the pin of the next key: %s", err)
+	}
+	fmt.Printf("next\t%s\n", next)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/swarm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	swarmHost         string
+	swarmCertSecret   string
+	swarmKeySecret    string
+	swarmPollInterval time.Duration
+)
+
+// swarmCmd represents the swarm command
+var swarmCmd = &cobra.Command{
+	Use:   "swarm",
+	Short: "Rotate the Docker Swarm secrets holding the certificate when it changes",
+	Long: `Watch the certificate in etcd and store it in Docker Swarm secrets every
+time it changes. A new version of each secret is created, named after the
+secret and the hash of its contents, the services mounting a previous
+version are updated to mount the new one at the same path and the previous
+versions are removed. Run it on a manager node.`,
+	Run: rotateSwarmSecrets,
+}
+
+func init() {
+	RootCmd.AddCommand(swarmCmd)
+
+	swarmCmd.Flags().StringVar(&swarmHost, "docker-host", swarm.DefaultHost, "The Docker engine of a Swarm manager, unix:///path/to/socket or tcp://host:port")
+	swarmCmd.Flags().StringVar(&swarmCertSecret, "cert-secret", "", "The name of the secret holding the certificate and its chain")
+	swarmCmd.Flags().StringVar(&swarmKeySecret, "key-secret", "", "The name of the secret holding the private key")
+	swarmCmd.Flags().DurationVar(&swarmPollInterval, "poll-interval", time.Minute, "How often the certificate is reloaded in case a change was missed")
+}
+
+func rotateSwarmSecrets(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+	if swarmCertSecret == "" && swarmKeySecret == "" {
+		log.Fatal("Please specify --cert-secret and/or --key-secret")
+	}
+	docker, err := swarm.New(swarmHost)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watchCert(store, domains, swarmPollInterval, make(chan struct{}), func(cert *legoetcd.Cert) {
+		for _, secret := range []struct {
+			prefix string
+			data   []byte
+		}{{swarmCertSecret, cert.Cert.Certificate}, {swarmKeySecret, cert.Cert.PrivateKey}} {
+			if secret.prefix == "" || len(secret.data) == 0 {
+				continue
+			}
+			name, err := docker.RotateSecret(secret.prefix, secret.data)
+			if err != nil {
+				log.Printf("error rotating the secret %s: %s", secret.prefix, err)
+				continue
+			}
+			log.Printf("the services use the secret %s", name)
+		}
+	})
+}
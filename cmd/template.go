@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	templates            []string
+	templateExec         string
+	templatePollInterval time.Duration
+)
+
+// templateCmd represents the template command
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Render the certificate to files with templates whenever it changes",
+	Long: `Watch the certificate in etcd and render it to files with Go templates
+every time it changes, then run the --exec command, for instance to reload
+nginx, haproxy or postfix. Each --template is source:destination, the
+templates are given the certificate as legoetcd.TemplateData, such as
+{{.Leaf}}{{.Chain}} or {{.PrivateKey}}. The files are replaced atomically and
+only if their contents changed.`,
+	Run: renderTemplates,
+}
+
+func init() {
+	RootCmd.AddCommand(templateCmd)
+
+	templateCmd.Flags().StringSliceVar(&templates, "template", []string{}, "A template and its destination as source:destination, can be specified multiple times")
+	templateCmd.Flags().StringVar(&templateExec, "exec", "", "Command run with sh after a file was rendered")
+	templateCmd.Flags().DurationVar(&templatePollInterval, "poll-interval", time.Minute, "How often the certificate is reloaded in case a change was missed")
+}
+
+// renderedTemplate is a template and its destination.
+type renderedTemplate struct {
+	tmpl *template.Template
+	dest string
+}
+
+func renderTemplates(cmd *cobra.Command, args []string) {
+	if len(domains) == 0 {
+		log.Fatal("Please specify the certificate with --domains/-d")
+	}
+	if len(templates) == 0 {
+		log.Fatal("Please specify at least one --template")
+	}
+	var rendered []renderedTemplate
+	for _, t := range templates {
+		parts := strings.SplitN(t, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Fatalf("invalid template %q, expected source:destination", t)
+		}
+		tmpl, err := template.New(filepath.Base(parts[0])).Funcs(template.FuncMap{"join": strings.Join}).ParseFiles(parts[0])
+		if err != nil {
+			log.Fatalf("error parsing the template %s: %s", parts[0], err)
+		}
+		rendered = append(rendered, renderedTemplate{tmpl: tmpl, dest: parts[1]})
+	}
+
+	// create the etcd store
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watchCert(store, domains, templatePollInterval, make(chan struct{}), func(cert *legoetcd.Cert) {
+		data, err := legoetcd.NewTemplateData(cert)
+		if err != nil {
+			log.Printf("error reading the certificate: %s", err)
+			return
+		}
+		changed := false
+		for _, r := range rendered {
+			var buf bytes.Buffer
+			if err := r.tmpl.Execute(&buf, data); err != nil {
+				log.Printf("error rendering %s: %s", r.dest, err)
+				continue
+			}
+			written, err := legoetcd.WriteFileIfChanged(r.dest, buf.Bytes(), 0600)
+			if err != nil {
+				log.Printf("error writing %s: %s", r.dest, err)
+				continue
+			}
+			if written {
+				log.Printf("rendered %s", r.dest)
+				changed = true
+			}
+		}
+		if changed && templateExec != "" {
+			c := exec.Command("sh", "-c", templateExec)
+			c.Stdout, c.Stderr = os.Stdout, os.Stderr
+			if err := c.Run(); err != nil {
+				log.Printf("error running %q: %s", templateExec, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// isInteractive returns true if the command is run from a terminal, rather
+// than by a daemon or a cron job.
+func isInteractive() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		fi, err := f.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// promptTOS asks the user whether they accept the terms of service of the CA.
+func promptTOS(tosURL string) (bool, error) {
+	return promptYesNo(fmt.Sprintf("The CA requires accepting its terms of service: %s\nDo you accept them?", tosURL))
+}
+
+// promptYesNo asks the user the question on the standard error, the answer
+// defaulting to no.
+func promptYesNo(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
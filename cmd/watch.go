@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// watchCert calls onChange with the certificate of domains, then every time it
+// changes until stop is closed. The certificate is watched in the etcd v2
+// keyspace if it is used, and reloaded every pollInterval regardless, which is
+// the only way to notice the changes in the v3 keyspace. With --verify-key,
+// only the certificates signed with that key are passed to onChange.
+func watchCert(store legoetcd.Store, domains []string, pollInterval time.Duration, stop chan struct{}, onChange func(cert *legoetcd.Cert)) {
+	var etcdClient client.Client
+	if !noEtcdV2 {
+		var err error
+		etcdClient, err = client.New(client.Config{Endpoints: etcdEndpoints})
+		if err != nil {
+			log.Fatalf("error creating a new etcd client: %s", err)
+		}
+	}
+	w := legoetcd.NewWatcher(store, legoetcd.NewKeysAPI(etcdClient), domains)
+	w.PollInterval = pollInterval
+	if verifyKey != "" {
+		key, err := legoetcd.LoadPublicKey(store, verifyKey)
+		if err != nil {
+			log.Fatalf("error loading the verification key %s: %s", verifyKey, err)
+		}
+		w.VerifyKey = key
+	}
+	w.Start()
+	defer w.Stop()
+	for {
+		select {
+		case cert := <-w.Updates():
+			onChange(cert)
+		case <-stop:
+			return
+		}
+	}
+}
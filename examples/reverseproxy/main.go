@@ -0,0 +1,126 @@
+// Command reverseproxy is an HTTPS reverse proxy serving a certificate
+// managed by the lego-etcd service. It is the reference for embedding the
+// service: the certificate is swapped without restarting whenever it is
+// renewed by any instance, and the health of the service and its metrics are
+// exposed on an admin listener.
+//
+//	reverseproxy -etcd-endpoints http://127.0.0.1:2379 -email me@example.com \
+//		-domains example.com -dns route53 -backend http://127.0.0.1:8080
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	_ "expvar" // registers /debug/vars
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/service"
+)
+
+var (
+	etcdEndpoints = flag.String("etcd-endpoints", "http://127.0.0.1:2379", "Comma separated list of the etcd endpoints.")
+	acmeServer    = flag.String("acme-server", "https://acme-staging.api.letsencrypt.org/directory", "The ACME directory.")
+	email         = flag.String("email", "", "The account under which to register and renew the certificate.")
+	domains       = flag.String("domains", "", "Comma separated list of the domains of the certificate.")
+	dns           = flag.String("dns", "", "Solve a DNS challenge using the specified provider.")
+	webroot       = flag.String("webroot", "", "Solve a HTTP challenge by writing to this webroot.")
+	acceptTOS     = flag.Bool("accept-tos", false, "Accept the terms of service of the CA.")
+	listen        = flag.String("listen", ":443", "Address to serve HTTPS on.")
+	adminListen   = flag.String("admin-listen", "127.0.0.1:8081", "Address to serve the health and metrics endpoints on.")
+	backend       = flag.String("backend", "http://127.0.0.1:8080", "URL of the proxied backend.")
+)
+
+// certStore holds the certificate currently served.
+type certStore struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (s *certStore) set(cert *legoetcd.Cert) error {
+	tlsCert, err := tls.X509KeyPair(cert.Cert.Certificate, cert.Cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cert = &tlsCert
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *certStore) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+func (s *certStore) ready() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert != nil
+}
+
+func main() {
+	flag.Parse()
+	if *email == "" || *domains == "" {
+		log.Fatal("-email and -domains are required")
+	}
+	backendURL, err := url.Parse(*backend)
+	if err != nil {
+		log.Fatalf("invalid backend: %s", err)
+	}
+
+	// start the service managing the certificate
+	svc := service.New(client.Config{Endpoints: strings.Split(*etcdEndpoints, ",")},
+		*acmeServer, *email, strings.Split(*domains, ","), "", *acceptTOS, false, *dns, *webroot)
+	go func() {
+		if err := svc.Run(); err != nil {
+			log.Fatalf("error running the service: %s", err)
+		}
+	}()
+
+	// swap the served certificate each time the service sends one
+	certs := &certStore{}
+	go func() {
+		for ev := range svc.CertChan {
+			cert := ev.Cert
+			if err := certs.set(cert); err != nil {
+				log.Printf("error loading the certificate for %v: %s", cert.Domains, err)
+				continue
+			}
+			exp, _ := cert.Expiration()
+			log.Printf("serving the %s certificate for %v expiring at %s", ev.Reason, cert.Domains, exp)
+		}
+	}()
+
+	// serve the health and the metrics on the admin listener
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := struct {
+			Ready bool `json:"ready"`
+			service.Status
+		}{certs.ready(), svc.Status()}
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+	go func() {
+		log.Fatal(http.ListenAndServe(*adminListen, nil))
+	}()
+
+	// proxy the requests to the backend
+	server := &http.Server{
+		Addr:      *listen,
+		Handler:   httputil.NewSingleHostReverseProxy(backendURL),
+		TLSConfig: &tls.Config{GetCertificate: certs.get},
+	}
+	log.Fatal(server.ListenAndServeTLS("", ""))
+}
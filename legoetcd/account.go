@@ -10,17 +10,17 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"time"
+	"net/url"
+	"strings"
 
-	"golang.org/x/net/context"
-
-	"github.com/coreos/etcd/client"
 	"github.com/xenolf/lego/acme"
 )
 
 const (
-	registrationKey = "/lego/accounts/%s/registration"
-	cryptoKey       = "/lego/accounts/%s/key"
+	accountsKey              = "/lego/accounts/"
+	registrationKey          = "/lego/accounts/%s/registration"
+	directoryRegistrationKey = "/lego/accounts/%s/registrations/%s"
+	cryptoKey                = "/lego/accounts/%s/key"
 )
 
 var (
@@ -40,15 +40,70 @@ var (
 // Account implements acme.Account
 type Account struct {
 	email        string
+	directory    string
 	registration *acme.RegistrationResource
 	key          crypto.PrivateKey
 }
 
-// NewAccount returns a new user with the email provided
+// AccountInfo describes an account stored in etcd.
+type AccountInfo struct {
+	// Email is the email of the account.
+	Email string `json:"email"`
+	// Directories are the host and path of the ACME directories the account is
+	// registered with.
+	Directories []string `json:"directories"`
+	// Legacy is true if the account has a registration stored before the
+	// registrations were namespaced by directory.
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// NewAccount returns a new user with the email provided, its registration is
+// stored at the location used before the registrations were namespaced by
+// directory.
 func NewAccount(email string) *Account {
 	return &Account{email: email}
 }
 
+// NewDirectoryAccount returns a new user with the email provided, registered
+// with the ACME directory. The accounts of the same email share their key
+// across the directories.
+func NewDirectoryAccount(email, directoryURL string) *Account {
+	return &Account{email: email, directory: directoryURL}
+}
+
+// ListAccounts returns the accounts stored in etcd.
+func ListAccounts(s Store) ([]*AccountInfo, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := s.Keys(ctx, accountsKey)
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*AccountInfo
+	byEmail := make(map[string]*AccountInfo)
+	for _, key := range keys {
+		parts := strings.SplitN(strings.TrimPrefix(key, accountsKey), "/", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		info, ok := byEmail[parts[0]]
+		if !ok {
+			info = &AccountInfo{Email: parts[0]}
+			byEmail[parts[0]] = info
+			accounts = append(accounts, info)
+		}
+		switch {
+		case parts[1] == "registration":
+			info.Legacy = true
+		case parts[1] == "registrations" && len(parts) == 3:
+			if directory, err := url.QueryUnescape(parts[2]); err == nil {
+				info.Directories = append(info.Directories, directory)
+			}
+		}
+	}
+	return accounts, nil
+}
+
 // GetEmail returns the email associated with this user.
 func (a *Account) GetEmail() string { return a.email }
 
@@ -59,47 +114,82 @@ func (a *Account) GetRegistration() *acme.RegistrationResource { return a.regist
 func (a *Account) GetPrivateKey() crypto.PrivateKey { return a.key }
 
 // Load loads the key from etcd.
-func (a *Account) Load(c client.Client) error {
+func (a *Account) Load(s Store) error {
 	// load the registration
-	if err := a.LoadRegistration(c); err != nil {
+	if err := a.LoadRegistration(s); err != nil {
 		return err
 	}
 	// load the key
-	if err := a.LoadKey(c); err != nil {
+	if err := a.LoadKey(s); err != nil {
 		return err
 	}
 	return nil
 }
 
-// LoadRegistration loads the registration from etcd.
-func (a *Account) LoadRegistration(c client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(c)
+// LoadRegistration loads the registration from etcd. The registration stored
+// before the namespacing by directory is used if it was made with the same CA.
+func (a *Account) LoadRegistration(s Store) error {
 	// get the registration
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	resp, err := kapi.Get(ctx, fmt.Sprintf(registrationKey, a.email), nil)
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	value, err := s.Get(ctx, a.registrationPath())
+	cancelFunc()
+	if err != nil && IsKeyNotFound(err) && a.directory != "" {
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		legacy, lerr := s.Get(ctx, fmt.Sprintf(registrationKey, a.email))
+		cancelFunc()
+		if lerr == nil && a.sameCA(legacy) {
+			value, err = legacy, nil
+		}
+	}
 	if err != nil {
 		return err
 	}
-	cancelFunc()
 	// decode the registration
 	a.registration = &acme.RegistrationResource{}
-	return json.Unmarshal([]byte(resp.Node.Value), a.registration)
+	return json.Unmarshal([]byte(value), a.registration)
+}
+
+// registrationPath returns where the registration is stored on etcd.
+func (a *Account) registrationPath() string {
+	if a.directory == "" {
+		return fmt.Sprintf(registrationKey, a.email)
+	}
+	directory := strings.TrimPrefix(strings.TrimPrefix(a.directory, "https://"), "http://")
+	return fmt.Sprintf(directoryRegistrationKey, a.email, url.QueryEscape(directory))
+}
+
+// sameCA returns whether the registration was made with the CA of the
+// directory of the account.
+func (a *Account) sameCA(registration string) bool {
+	reg := &acme.RegistrationResource{}
+	if err := json.Unmarshal([]byte(registration), reg); err != nil {
+		return false
+	}
+	regURL, err := url.Parse(reg.URI)
+	if err != nil {
+		return false
+	}
+	dirURL, err := url.Parse(a.directory)
+	if err != nil {
+		return false
+	}
+	return regURL.Host == dirURL.Host
 }
 
 // LoadKey loads the key from etcd.
-func (a *Account) LoadKey(c client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(c)
+func (a *Account) LoadKey(s Store) error {
 	// get the key
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	resp, err := kapi.Get(ctx, fmt.Sprintf(cryptoKey, a.email), nil)
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	value, err := s.Get(ctx, fmt.Sprintf(cryptoKey, a.email))
+	cancelFunc()
 	if err != nil {
 		return err
 	}
-	cancelFunc()
 	// decode the key into a keyBlock
-	keyBlock, _ := pem.Decode([]byte(resp.Node.Value))
+	keyBlock, _ := pem.Decode([]byte(value))
+	if keyBlock == nil {
+		return ErrUnknowKeyType
+	}
 	// cast the key to the correct format and store it in a.key
 	switch keyBlock.Type {
 	case "RSA PRIVATE KEY":
@@ -121,16 +211,17 @@ func (a *Account) LoadKey(c client.Client) error {
 
 // Save saves the key into etcd. The caller is responsible to ensure no race
 // conditions by grabbing a lock before calling Save().
-func (a *Account) Save(c client.Client) error {
+func (a *Account) Save(s Store) (err error) {
+	defer func() { audit(s, "save account", auditAccounts, []string{a.email}, err) }()
 	// save the registration
 	if a.registration != nil {
-		if err := a.saveRegistration(c); err != nil {
+		if err := a.saveRegistration(s); err != nil {
 			return err
 		}
 	}
 	// save the key
 	if a.key != nil {
-		if err := a.saveKey(c); err != nil {
+		if err := a.saveKey(s); err != nil {
 			return err
 		}
 	}
@@ -163,38 +254,36 @@ func (a *Account) Register(c *acme.Client) error {
 	return nil
 }
 
-func (a *Account) saveRegistration(c client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(c)
+func (a *Account) saveRegistration(s Store) error {
 	// encode the registration as json
 	registrationJSON, err := json.Marshal(a.registration)
 	if err != nil {
 		return err
 	}
 	// save it to etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	if _, err := kapi.Set(ctx, fmt.Sprintf(registrationKey, a.email), string(registrationJSON), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
-		return err
-	}
-	cancelFunc()
-	return nil
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return s.Set(ctx, a.registrationPath(), string(registrationJSON))
 }
 
-func (a *Account) saveKey(c client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(c)
+// PEMKey returns the key of the account PEM-encoded.
+func (a *Account) PEMKey() ([]byte, error) {
 	// encore the key as PEM
 	keyBytes, err := x509.MarshalECPrivateKey(a.key.(*ecdsa.PrivateKey))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	pemKey := pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
-	pemBytes := pem.EncodeToMemory(&pemKey)
-	// save it to etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	if _, err := kapi.Set(ctx, fmt.Sprintf(cryptoKey, a.email), string(pemBytes), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
+	return pem.EncodeToMemory(&pemKey), nil
+}
+
+func (a *Account) saveKey(s Store) error {
+	pemBytes, err := a.PEMKey()
+	if err != nil {
 		return err
 	}
-	cancelFunc()
-	return nil
+	// save it to etcd
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return s.Set(ctx, fmt.Sprintf(cryptoKey, a.email), string(pemBytes))
 }
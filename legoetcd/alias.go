@@ -0,0 +1,89 @@
+package legoetcd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+)
+
+const acmeChallengeLabel = "_acme-challenge."
+
+// aliasProvider presents the DNS challenges on a delegated zone, for domains
+// whose _acme-challenge record is a CNAME to _acme-challenge.<alias>.
+type aliasProvider struct {
+	acme.ChallengeProvider
+	// alias is the domain the challenges are presented on, when empty the
+	// alias is found by following the CNAME of the _acme-challenge record.
+	alias string
+}
+
+// Present implements acme.ChallengeProvider
+func (p *aliasProvider) Present(domain, token, keyAuth string) error {
+	alias, err := p.aliasFor(domain)
+	if err != nil {
+		return err
+	}
+	return p.ChallengeProvider.Present(alias, token, keyAuth)
+}
+
+// CleanUp implements acme.ChallengeProvider
+func (p *aliasProvider) CleanUp(domain, token, keyAuth string) error {
+	alias, err := p.aliasFor(domain)
+	if err != nil {
+		return err
+	}
+	return p.ChallengeProvider.CleanUp(alias, token, keyAuth)
+}
+
+// Timeout implements acme.ChallengeProviderTimeout
+func (p *aliasProvider) Timeout() (timeout, interval time.Duration) {
+	return providerTimeout(p.ChallengeProvider)
+}
+
+func (p *aliasProvider) aliasFor(domain string) (string, error) {
+	if p.alias != "" {
+		return p.alias, nil
+	}
+	target, err := queryCNAME(acmeChallengeLabel + domain)
+	if err != nil {
+		return "", fmt.Errorf("error following the CNAME of %s%s: %s", acmeChallengeLabel, domain, err)
+	}
+	// no CNAME, the record lives in the domain's zone
+	if target == "" {
+		return domain, nil
+	}
+	if !strings.HasPrefix(target, acmeChallengeLabel) {
+		return "", fmt.Errorf("the CNAME of %s%s must point to a %s record, got %s", acmeChallengeLabel, domain, acmeChallengeLabel, target)
+	}
+	return strings.TrimPrefix(target, acmeChallengeLabel), nil
+}
+
+// queryCNAME queries the CNAME of the name with the recursive nameservers of
+// the DNS challenges, and returns its target or an empty string if the name
+// does not exist or has no CNAME.
+func queryCNAME(name string) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeCNAME)
+	m.RecursionDesired = true
+	var err error
+	for _, ns := range acme.RecursiveNameservers {
+		var in *dns.Msg
+		in, _, err = (&dns.Client{Timeout: acme.DNSTimeout}).Exchange(m, ns)
+		if err != nil {
+			continue
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			return "", fmt.Errorf("the query of the CNAME of %s failed with %s", name, dns.RcodeToString[in.Rcode])
+		}
+		for _, rr := range in.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok {
+				return acme.UnFqdn(cname.Target), nil
+			}
+		}
+		return "", nil
+	}
+	return "", err
+}
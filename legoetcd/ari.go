@@ -0,0 +1,79 @@
+package legoetcd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// ErrNoRenewalInfo is returned when the ACME server does not support ACME
+// Renewal Information (ARI).
+var ErrNoRenewalInfo = errors.New("the ACME server does not provide renewal information")
+
+// RenewalWindow is the window the CA suggests renewing a certificate in.
+type RenewalWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// renewalInfo is the ARI response of the CA.
+type renewalInfo struct {
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	ExplanationURL  string        `json:"explanationURL"`
+}
+
+// RenewalInfo asks the CA when the certificate should be renewed. It returns
+// ErrNoRenewalInfo if the CA does not support ARI, which is the case of all
+// the ACME v1 servers.
+func (c *Client) RenewalInfo(cert *Cert) (*RenewalWindow, error) {
+	// find the ARI endpoint in the directory
+	var dir map[string]interface{}
+	if err := getJSON(c.directoryURL, &dir); err != nil {
+		return nil, err
+	}
+	endpoint, ok := dir["renewalInfo"].(string)
+	if !ok || endpoint == "" {
+		return nil, ErrNoRenewalInfo
+	}
+	// build the ARI identifier of the certificate
+	certs, err := parsePEMBundle(cert.Cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	leaf := certs[0]
+	if len(leaf.AuthorityKeyId) == 0 {
+		return nil, errors.New("the certificate has no authority key identifier")
+	}
+	serial := leaf.SerialNumber.Bytes()
+	if len(serial) > 0 && serial[0]&0x80 != 0 {
+		// the DER encoding of a positive integer
+		serial = append([]byte{0}, serial...)
+	}
+	id := base64.RawURLEncoding.EncodeToString(leaf.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial)
+	// get the suggested window
+	var info renewalInfo
+	if err := getJSON(strings.TrimSuffix(endpoint, "/")+"/"+id, &info); err != nil {
+		return nil, err
+	}
+	if info.SuggestedWindow.End.Before(info.SuggestedWindow.Start) {
+		return nil, fmt.Errorf("invalid renewal window from %s to %s", info.SuggestedWindow.Start, info.SuggestedWindow.End)
+	}
+	return &info.SuggestedWindow, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := acme.HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
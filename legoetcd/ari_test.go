@@ -0,0 +1,103 @@
+package legoetcd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// ariCert returns a certificate with the serial number and the authority key
+// identifier.
+func ariCert(t *testing.T, serial int64, authorityKeyID []byte) *Cert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(serial),
+		Subject:        pkix.Name{CommonName: "example.com"},
+		DNSNames:       []string{"example.com"},
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(90 * 24 * time.Hour),
+		AuthorityKeyId: authorityKeyID,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Cert{
+		Domains: []string{"example.com"},
+		Cert:    acme.CertificateResource{Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+	}
+}
+
+func TestRenewalInfo(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := RenewalWindow{Start: start, End: start.Add(48 * time.Hour)}
+	tests := []struct {
+		name   string
+		ari    bool
+		serial int64
+		window RenewalWindow
+		// wantPath is the path of the ARI request, built from the authority
+		// key identifier and the serial number
+		wantPath string
+		wantErr  bool
+	}{
+		{"renewal window", true, 0x0102, window, "/ari/AQID.AQI", false},
+		{"serial number with its high bit set", true, 0x80, window, "/ari/AQID.AIA", false},
+		{"no ARI", false, 0x0102, window, "", true},
+		{"inverted window", true, 0x0102, RenewalWindow{Start: window.End, End: window.Start}, "/ari/AQID.AQI", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			mux := http.NewServeMux()
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+			mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+				dir := map[string]string{"new-cert": srv.URL + "/new-cert"}
+				if tt.ari {
+					dir["renewalInfo"] = srv.URL + "/ari/"
+				}
+				json.NewEncoder(w).Encode(dir)
+			})
+			mux.HandleFunc("/ari/", func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				json.NewEncoder(w).Encode(renewalInfo{SuggestedWindow: tt.window})
+			})
+
+			c := &Client{directoryURL: srv.URL + "/directory"}
+			got, err := c.RenewalInfo(ariCert(t, tt.serial, []byte{1, 2, 3}))
+			if !tt.ari && err != ErrNoRenewalInfo {
+				t.Fatalf("want ErrNoRenewalInfo, got %v", err)
+			}
+			if path != tt.wantPath {
+				t.Errorf("want the renewal information at %s, got %s", tt.wantPath, path)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want an error, got the window %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Start.Equal(tt.window.Start) || !got.End.Equal(tt.window.End) {
+				t.Errorf("want the window %+v, got %+v", tt.window, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package legoetcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
+)
+
+// EventAudit is the type of the events recorded by the Auditor.
+const EventAudit notify.Event = "audit"
+
+const (
+	// auditAccounts and auditStore are the names the operations on the
+	// accounts and on the whole store are recorded under in the event log.
+	auditAccounts = "_accounts"
+	auditStore    = "_store"
+)
+
+// Audit, if set, records who performed each operation writing to etcd, see
+// Auditor. It is nil by default, disabling the audit.
+var Audit *Auditor
+
+// Actor is who performed an operation.
+type Actor struct {
+	Host string `json:"host"`
+	User string `json:"user,omitempty"`
+	PID  int    `json:"pid"`
+	// Source is what performed the operation, such as cli or service.
+	Source string `json:"source"`
+}
+
+// CurrentActor returns the actor of this process.
+func CurrentActor(source string) *Actor {
+	a := &Actor{PID: os.Getpid(), Source: source}
+	a.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		a.User = u.Username
+	}
+	return a
+}
+
+// Auditor records the operations writing to etcd along with their actor, in
+// the event log of the certificate they write, or under _accounts and _store
+// for the accounts and the whole store, and appends them to File as JSON
+// lines if it is set.
+type Auditor struct {
+	Actor *Actor
+	// File, if set, is the local audit file the events are appended to.
+	File string
+	// Retention is the number of events kept per certificate, see
+	// EventLog. A negative Retention only appends the events to File.
+	Retention int
+
+	mu sync.Mutex
+}
+
+// NewAuditor returns an auditor of the operations performed by this process
+// on behalf of source.
+func NewAuditor(source, file string) *Auditor {
+	return &Auditor{Actor: CurrentActor(source), File: file}
+}
+
+// audit records the operation if the audit is enabled.
+func audit(s Store, operation, name string, domains []string, err error) {
+	if Audit == nil {
+		return
+	}
+	if rerr := Audit.Record(s, operation, name, domains, err); rerr != nil {
+		log.Printf("error auditing the %s of %s: %s", operation, name, rerr)
+	}
+}
+
+// Record records the operation on the certificate stored under name, the
+// error being its outcome.
+func (a *Auditor) Record(s Store, operation, name string, domains []string, err error) error {
+	now := time.Now()
+	e := &Event{
+		ID:        now.UTC().Format(historyTimeFormat),
+		Type:      EventAudit,
+		Operation: operation,
+		Domains:   domains,
+		Host:      a.Actor.Host,
+		Actor:     a.Actor,
+		Time:      now,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	jsonBytes, jerr := json.Marshal(e)
+	if jerr != nil {
+		return jerr
+	}
+	if a.File != "" {
+		if ferr := a.appendFile(jsonBytes); ferr != nil {
+			return ferr
+		}
+	}
+	retention := a.Retention
+	switch {
+	case retention < 0:
+		// the event log is disabled
+		return nil
+	case retention == 0:
+		retention = DefaultEventRetention
+	}
+	return recordEvent(s, name, e.ID, jsonBytes, retention)
+}
+
+func (a *Auditor) appendFile(line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing the audit file: %s", err)
+	}
+	return f.Close()
+}
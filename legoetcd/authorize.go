@@ -0,0 +1,110 @@
+package legoetcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"time"
+)
+
+// IssuanceRequest describes an issuance submitted to the Authorizer.
+type IssuanceRequest struct {
+	// Domains are the domains of the certificate.
+	Domains []string `json:"domains"`
+	// Requester identifies who is asking for the certificate.
+	Requester string `json:"requester"`
+	// Source is the component asking for the certificate, such as cli or
+	// service.
+	Source string `json:"source"`
+	// Renewal is true if the certificate is being renewed.
+	Renewal bool `json:"renewal"`
+}
+
+// Authorizer is invoked before ordering any certificate, it returns an error
+// to deny the issuance.
+type Authorizer func(req *IssuanceRequest) error
+
+// AuthorizationDeniedError is returned when the Authorizer denied an
+// issuance.
+type AuthorizationDeniedError struct {
+	// Domains are the domains of the denied certificate.
+	Domains []string
+	// Reason is the error returned by the Authorizer.
+	Reason error
+}
+
+func (e *AuthorizationDeniedError) Error() string {
+	return fmt.Sprintf("the issuance for %v was denied: %s", e.Domains, e.Reason)
+}
+
+// WebhookAuthorizer returns an Authorizer posting the IssuanceRequest as JSON
+// to the url, the issuance is allowed if the webhook answers with a 2xx status
+// code. It denies all the issuances in offline mode.
+func WebhookAuthorizer(url string) Authorizer {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	return func(req *IssuanceRequest) error {
+		if Offline {
+			return ErrOffline
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error calling the authorization webhook: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("the authorization webhook answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+		}
+		return nil
+	}
+}
+
+// DefaultRequester returns user@host for the current process.
+func DefaultRequester() string {
+	host, _ := os.Hostname()
+	if u, err := user.Current(); err == nil {
+		return u.Username + "@" + host
+	}
+	return host
+}
+
+// checkIssuance makes sure the certificate may be issued for the domains,
+// according to the host policy, the CAA records, the rate limit errors of the
+// CA, the Authorizer and the RateLimiter.
+func (c *Client) checkIssuance(domains []string, renewal bool) error {
+	if err := c.checkIdentifiers(domains); err != nil {
+		return err
+	}
+	if err := c.HostPolicy.Check(domains); err != nil {
+		return err
+	}
+	if err := c.checkCAA(domains); err != nil {
+		return err
+	}
+	if err := c.checkBackoff(domains); err != nil {
+		return err
+	}
+	if c.Authorizer != nil {
+		req := &IssuanceRequest{
+			Domains:   domains,
+			Requester: c.Requester,
+			Source:    c.Source,
+			Renewal:   renewal,
+		}
+		if err := c.Authorizer(req); err != nil {
+			return &AuthorizationDeniedError{Domains: domains, Reason: err}
+		}
+	}
+	if c.RateLimiter != nil {
+		return c.RateLimiter.Reserve(domains, renewal)
+	}
+	return nil
+}
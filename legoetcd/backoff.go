@@ -0,0 +1,124 @@
+package legoetcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+const (
+	// backoffKey holds the time the CA allows ordering a set of domains
+	// again after it answered with a rate limit error.
+	backoffKey = "/lego/ratelimits/backoff/%s"
+	// defaultRateLimitBackoff is how long to wait when the CA does not tell.
+	defaultRateLimitBackoff = time.Hour
+)
+
+// retryAfterPattern finds the time in the rate limit errors of the CA, such
+// as "retry after 2017-01-01 00:00:00 UTC".
+var retryAfterPattern = regexp.MustCompile(`(?i)retry after (\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:Z| UTC)?)`)
+
+// retryAfterLayouts are the layouts of the times found by retryAfterPattern.
+var retryAfterLayouts = []string{"2006-01-02 15:04:05 UTC", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// backoff is the value of the backoff key.
+type backoff struct {
+	RetryAfter time.Time `json:"retryAfter"`
+	Reason     string    `json:"reason"`
+}
+
+// rateLimited returns the time the CA allows trying again if the error is a
+// rate limit error of the CA.
+func rateLimited(err error) (time.Time, bool) {
+	remote, ok := err.(acme.RemoteError)
+	if !ok || (remote.StatusCode != 429 && !strings.HasSuffix(remote.Type, ":rateLimited")) {
+		return time.Time{}, false
+	}
+	if m := retryAfterPattern.FindStringSubmatch(remote.Detail); m != nil {
+		for _, layout := range retryAfterLayouts {
+			if t, err := time.Parse(layout, m[1]); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Now().Add(defaultRateLimitBackoff), true
+}
+
+// RetryAfter returns the time the issuance is allowed again if the error is a
+// *RateLimitError or a rate limit error of the CA.
+func RetryAfter(err error) (time.Time, bool) {
+	if rerr, ok := err.(*RateLimitError); ok {
+		return rerr.RetryAfter, true
+	}
+	return rateLimited(err)
+}
+
+// issuanceID identifies the issuances of the set of domains, whatever the
+// order and the duplicates.
+func issuanceID(domains []string) string {
+	seen := make(map[string]bool)
+	var distinct []string
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if !seen[domain] {
+			seen[domain] = true
+			distinct = append(distinct, domain)
+		}
+	}
+	return domainSetID(distinct)
+}
+
+// recordBackoff stores the time the CA allows ordering the domains again if
+// one of the errors is a rate limit error, so the other clients and the
+// restarts do not retry before.
+func (c *Client) recordBackoff(domains []string, errs ...error) {
+	if c.store == nil || len(domains) == 0 {
+		return
+	}
+	for _, failure := range errs {
+		retryAfter, ok := rateLimited(failure)
+		if !ok {
+			continue
+		}
+		value, err := json.Marshal(backoff{RetryAfter: retryAfter, Reason: failure.Error()})
+		if err != nil {
+			return
+		}
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		defer cancelFunc()
+		if err := c.store.Set(ctx, fmt.Sprintf(backoffKey, issuanceID(domains)), string(value)); err != nil {
+			log.Printf("error recording the rate limit of %v: %s", domains, err)
+		}
+		return
+	}
+}
+
+// checkBackoff returns a *RateLimitError if the CA asked not to order the
+// domains before a time which has not come yet.
+func (c *Client) checkBackoff(domains []string) error {
+	if c.store == nil {
+		return nil
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	value, err := c.store.Get(ctx, fmt.Sprintf(backoffKey, issuanceID(domains)))
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var b backoff
+	if err := json.Unmarshal([]byte(value), &b); err != nil {
+		return nil
+	}
+	if time.Now().Before(b.RetryAfter) {
+		return &RateLimitError{Limit: fmt.Sprintf("the CA (%s)", b.Reason), RetryAfter: b.RetryAfter}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package legoetcd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// ErrInvalidBackupKey is returned when the encryption key of a backup is not a
+// hex encoded 256-bit key.
+var ErrInvalidBackupKey = errors.New("the backup key must be 32 hex encoded bytes")
+
+// ErrInvalidBackup is returned when a backup cannot be decrypted or contains
+// keys outside of /lego/.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backup writes all the accounts, the certificates and their metadata of the
+// store to w as a gzipped tarball, one file per key. The locks are not backed
+// up. It returns the number of keys written.
+func Backup(s Store, w io.Writer) (int, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := s.Keys(ctx, "/lego/")
+	cancelFunc()
+	if err != nil {
+		return 0, err
+	}
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	written := 0
+	now := time.Now()
+	for _, key := range keys {
+		if isLockKey(key) {
+			continue
+		}
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		value, err := s.Get(ctx, key)
+		cancelFunc()
+		if err != nil {
+			if IsKeyNotFound(err) {
+				// removed since it was listed
+				continue
+			}
+			return written, fmt.Errorf("error reading %s: %s", key, err)
+		}
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(key, "/"),
+			Mode:    0600,
+			Size:    int64(len(value)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return written, err
+		}
+		if _, err := io.WriteString(tw, value); err != nil {
+			return written, err
+		}
+		written++
+	}
+	if err := tw.Close(); err != nil {
+		return written, err
+	}
+	return written, gw.Close()
+}
+
+// Restore writes all the keys of a backup taken by Backup to the store,
+// replacing the existing values. It returns the number of keys restored.
+func Restore(s Store, r io.Reader) (int, error) {
+	restored, err := restore(s, r)
+	audit(s, "restore", auditStore, nil, err)
+	return restored, err
+}
+
+func restore(s Store, r io.Reader) (int, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", ErrInvalidBackup, err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	restored := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return restored, nil
+		}
+		if err != nil {
+			return restored, fmt.Errorf("%s: %s", ErrInvalidBackup, err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		key := "/" + strings.TrimPrefix(hdr.Name, "/")
+		if !strings.HasPrefix(key, "/lego/") || strings.Contains(key, "/../") {
+			return restored, fmt.Errorf("%s: unexpected key %s", ErrInvalidBackup, key)
+		}
+		value, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return restored, err
+		}
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		err = s.Set(ctx, key, string(value))
+		cancelFunc()
+		if err != nil {
+			return restored, fmt.Errorf("error writing %s: %s", key, err)
+		}
+		restored++
+	}
+}
+
+// ParseBackupKey decodes a hex encoded 256-bit backup encryption key, as
+// generated by `openssl rand -hex 32`.
+func ParseBackupKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidBackupKey
+	}
+	return key, nil
+}
+
+// SealBackup encrypts a backup with AES-256-GCM, the nonce is prepended to the
+// ciphertext.
+func SealBackup(key, backup []byte) ([]byte, error) {
+	aead, err := backupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, backup, nil), nil
+}
+
+// OpenBackup decrypts a backup encrypted by SealBackup.
+func OpenBackup(key, sealed []byte) ([]byte, error) {
+	aead, err := backupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidBackup
+	}
+	backup, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidBackup, err)
+	}
+	return backup, nil
+}
+
+func backupAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidBackupKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,68 @@
+package legoetcd
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrIssuanceDeadline is returned when an issuance did not complete within
+// the Issuance budget.
+var ErrIssuanceDeadline = errors.New("the issuance did not complete within its deadline")
+
+// Budgets are the deadlines of the operations performed by lego-etcd. A zero
+// value disables the budget, or falls back to the behavior documented for it.
+type Budgets struct {
+	// EtcdOp is the deadline of a single etcd request.
+	EtcdOp time.Duration
+	// LockWait is the maximum time to wait for a lock held by another
+	// instance, zero waits for the TTL of the lock plus 30 minutes.
+	LockWait time.Duration
+	// ChallengeWait is the maximum time to wait for a DNS record to propagate
+	// when the challenge configuration does not set one, zero uses the
+	// provider's timeout.
+	ChallengeWait time.Duration
+	// Issuance is the deadline of obtaining or renewing a certificate, zero
+	// means no deadline.
+	Issuance time.Duration
+	// RenewalCheck is the interval between two checks of the expiration of
+	// the certificates managed by a service.
+	RenewalCheck time.Duration
+}
+
+// DefaultBudgets are the budgets used by the library, they must be changed
+// before creating the clients and the services.
+var DefaultBudgets = Budgets{
+	EtcdOp:       10 * time.Second,
+	RenewalCheck: 12 * time.Hour,
+}
+
+// EtcdContext returns a context bounded by the EtcdOp budget.
+func (b Budgets) EtcdContext() (context.Context, context.CancelFunc) {
+	if b.EtcdOp <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), b.EtcdOp)
+}
+
+// withIssuanceDeadline runs f, giving up after the Issuance budget. The ACME
+// client cannot be interrupted, f keeps running in the background after the
+// deadline and its result is discarded.
+func withIssuanceDeadline(f func()) error {
+	if DefaultBudgets.Issuance <= 0 {
+		f()
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(DefaultBudgets.Issuance):
+		return ErrIssuanceDeadline
+	}
+}
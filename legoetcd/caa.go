@@ -0,0 +1,134 @@
+package legoetcd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/xenolf/lego/acme"
+)
+
+// CAAForbiddenError is returned when the CAA records of a domain do not
+// authorize the CA to issue certificates for it.
+type CAAForbiddenError struct {
+	// Domain is the domain whose CAA records forbid the issuance.
+	Domain string
+	// Identities are the CAA identities of the CA.
+	Identities []string
+	// Records are the CAA records found, such as issue "example.org".
+	Records []string
+}
+
+func (e *CAAForbiddenError) Error() string {
+	return fmt.Sprintf("the CAA records of %s (%s) do not authorize %s to issue certificates for it", e.Domain, strings.Join(e.Records, ", "), strings.Join(e.Identities, " or "))
+}
+
+// checkCAA checks the CAA records of the domains (RFC 8659) against the CAA
+// identities of the CA, to fail before ordering rather than with a confusing
+// authorization error. The domains which could not be checked are logged and
+// left to the CA to check.
+func (c *Client) checkCAA(domains []string) error {
+	if len(c.CAAIdentities) == 0 {
+		return nil
+	}
+	for _, domain := range domains {
+		if IsIP(domain) {
+			continue
+		}
+		records, err := lookupCAA(domain)
+		if err != nil {
+			log.Printf("was not able to check the CAA records of %s: %s", domain, err)
+			continue
+		}
+		if !caaAuthorizes(records, c.CAAIdentities, strings.HasPrefix(domain, "*.")) {
+			var found []string
+			for _, record := range records {
+				found = append(found, fmt.Sprintf("%s %q", record.Tag, record.Value))
+			}
+			return &CAAForbiddenError{Domain: domain, Identities: c.CAAIdentities, Records: found}
+		}
+	}
+	return nil
+}
+
+// lookupCAA returns the relevant CAA record set of the domain, found on the
+// domain or on the closest of its parents which has one.
+func lookupCAA(domain string) ([]*dns.CAA, error) {
+	name := strings.TrimPrefix(domain, "*.")
+	for {
+		records, err := queryCAA(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return nil, nil
+		}
+		name = name[i+1:]
+	}
+}
+
+// queryCAA queries the CAA records of the name with the recursive nameservers
+// of the DNS challenges.
+func queryCAA(name string) ([]*dns.CAA, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeCAA)
+	m.RecursionDesired = true
+	var err error
+	for _, ns := range acme.RecursiveNameservers {
+		var in *dns.Msg
+		in, _, err = (&dns.Client{Timeout: acme.DNSTimeout}).Exchange(m, ns)
+		if err != nil {
+			continue
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			return nil, fmt.Errorf("the query of the CAA records of %s failed with %s", name, dns.RcodeToString[in.Rcode])
+		}
+		var records []*dns.CAA
+		for _, rr := range in.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		return records, nil
+	}
+	return nil, err
+}
+
+// caaAuthorizes returns whether the CAA record set authorizes one of the
+// identities, with the issuewild records for a wildcard if there are any.
+func caaAuthorizes(records []*dns.CAA, identities []string, wildcard bool) bool {
+	tag := "issue"
+	if wildcard {
+		for _, record := range records {
+			if strings.ToLower(record.Tag) == "issuewild" {
+				tag = "issuewild"
+				break
+			}
+		}
+	}
+	relevant := false
+	for _, record := range records {
+		recordTag := strings.ToLower(record.Tag)
+		if recordTag != "issue" && recordTag != "issuewild" && recordTag != "iodef" && record.Flag&128 != 0 {
+			// an unknown critical property forbids the issuance
+			return false
+		}
+		if recordTag != tag {
+			continue
+		}
+		relevant = true
+		issuer := strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0])
+		for _, identity := range identities {
+			if strings.EqualFold(issuer, identity) {
+				return true
+			}
+		}
+	}
+	// no relevant record allows any CA
+	return !relevant
+}
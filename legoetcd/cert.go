@@ -2,17 +2,16 @@ package legoetcd
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"time"
 
-	"golang.org/x/net/context"
-
-	"github.com/coreos/etcd/client"
 	"github.com/xenolf/lego/acme"
 )
 
@@ -21,107 +20,275 @@ const (
 	keyKey  = "/lego/certificates/%s.key"
 	metaKey = "/lego/certificates/%s.json"
 	pemKey  = "/lego/certificates/%s.pem"
+	csrKey  = "/lego/certificates/%s.csr"
+	p12Key  = "/lego/certificates/%s.p12"
+	// issuerKey holds the issuers of the certificate alone, for the consumers
+	// needing the chain without the leaf.
+	issuerKey = "/lego/certificates/%s.issuer"
 )
 
-// ErrNoPemForCSR is returned when there is no private key.
-var ErrNoPemForCSR = errors.New("unable to save pem without private key; are you using a CSR?")
+var (
+	// ErrNoPemForCSR is returned when there is no private key.
+	ErrNoPemForCSR = errors.New("unable to save pem without private key; are you using a CSR?")
+	// ErrNoPKCS12ForCSR is returned when a PKCS#12 bundle is requested
+	// without a private key.
+	ErrNoPKCS12ForCSR = errors.New("unable to save a PKCS#12 bundle without private key; are you using a CSR?")
+	// ErrNoCertificate is returned when no certificate was found in the PEM data.
+	ErrNoCertificate = errors.New("no certificate found in the PEM data")
+)
 
 // Cert represents a domain certificate
 type Cert struct {
 	Domains []string
 	CSR     *x509.CertificateRequest
 	Cert    acme.CertificateResource
+	// Timing is the timing of the last issuance of this certificate.
+	Timing *IssuanceTiming
+	// Revision is the etcd revision of the certificate when it was loaded or
+	// saved. Save fails with ErrConflict if the certificate was modified by
+	// someone else since, a zero Revision saves unconditionally.
+	Revision int64
+	// PKCS12Password, if set, makes Save also store the certificate and its
+	// key as a PKCS#12 bundle protected by this password, for the consumers
+	// that can't use PEM. The bundle is stored base64-encoded under
+	// <domain>.p12.
+	PKCS12Password string
+	// NextKey is the PEM-encoded private key the certificate is renewed with
+	// next when the keys are rotated, see PrepareNextKey.
+	NextKey []byte
+	// SigningKey, if set, makes Save sign the checksums of the certificate, its
+	// key and its metadata with this RSA or EC key, such as the account key,
+	// for the consumers calling VerifySignature.
+	SigningKey crypto.PrivateKey
+	// LeafCert and ChainCerts are the parsed certificate and its issuers,
+	// populated by LoadCert and Reload once the chain is verified.
+	LeafCert   *x509.Certificate
+	ChainCerts []*x509.Certificate
+
+	// loadedSums are the checksums of the values loaded, by suffix.
+	loadedSums map[string]string
+}
+
+// certMeta is the metadata of the certificate stored in etcd.
+type certMeta struct {
+	acme.CertificateResource
+	Timing *IssuanceTiming `json:"timing,omitempty"`
+	// SPKIHash and NextSPKIHash are the pins of the current and the next key,
+	// for the consumers publishing them.
+	SPKIHash     string `json:"spkiHash,omitempty"`
+	NextSPKIHash string `json:"nextSpkiHash,omitempty"`
+	CertInfo
 }
 
-// NewCert obtains a new certificate for the domains or the csr.
+// NewCert obtains a new certificate for the domains or the csr, which is read
+// from a file, the standard input written as - or an etcd key written as
+// etcd:///path/to/csr. The IP addresses among the domains are requested with a
+// generated CSR, see NewCertFromConfig.
 func (c *Client) NewCert(domains []string, csrFile string, bundle bool) (*Cert, map[string]error) {
+	// order and store the internationalized domains under their ASCII form
+	domains, err := NormalizeDomains(domains)
+	if err != nil {
+		return nil, map[string]error{"domains": err}
+	}
+	if c.ReuseExistingFor > 0 && csrFile == "" {
+		if cert, err := c.findCoveringCert(domains, c.ReuseExistingFor); err == nil {
+			log.Printf("reusing a stored certificate covering %v", domains)
+			return cert, nil
+		}
+	}
+	if names, ips := splitIdentifiers(domains); len(ips) > 0 {
+		return c.NewCertFromConfig(&CSRConfig{DNSNames: names, IPAddresses: ips, MustStaple: c.MustStaple}, bundle)
+	}
 	var (
 		cert     acme.CertificateResource
 		failures map[string]error
 		csr      *x509.CertificateRequest
 	)
-	{
+	if len(domains) == 0 {
+		// read the CSR
 		var err error
-
+		csr, err = readCSR(c.store, csrFile)
+		if err != nil {
+			// we couldn't read the CSR
+			return nil, map[string]error{"csr": err}
+		}
+		// make sure we may issue for the domains of the CSR
+		if err := c.checkIssuance(csrDomains(csr), false); err != nil {
+			return nil, map[string]error{"policy": err}
+		}
+	} else if err := c.checkIssuance(domains, false); err != nil {
+		return nil, map[string]error{"policy": err}
+	}
+	timing, err := c.timeIssuance(func() {
 		// generate a domains certificate
-		if len(domains) > 0 {
-			cert, failures = c.Client.ObtainCertificate(domains, bundle, nil)
+		if csr == nil {
+			// lego generates the key unless we have one
+			cert, failures = c.obtain(domains, bundle, c.PrivateKey)
 		} else {
-			// read the CSR
-			csr, err = readCSRFile(csrFile)
-			if err != nil {
-				// we couldn't read the CSR
-				failures = map[string]error{"csr": err}
-			} else {
-				// obtain a certificate for this CSR
-				cert, failures = c.Client.ObtainCertificateForCSR(*csr, bundle)
-			}
+			// obtain a certificate for this CSR
+			cert, failures = c.obtainForCSR(*csr, bundle)
 		}
+	})
+	if err != nil {
+		return nil, map[string]error{"deadline": err}
 	}
 	if len(failures) > 0 {
 		return nil, failures
 	}
+	recordIssuance(timing)
+	c.preferChain(&cert)
 
 	return &Cert{
 		Domains: domains,
 		CSR:     csr,
 		Cert:    cert,
+		Timing:  timing,
 	}, nil
 }
 
-// LoadCert loads the certificate from ETCD
-func LoadCert(ec client.Client, domains []string) (*Cert, error) {
+// LoadCert loads the certificate from ETCD, and verifies it matches its key
+// and its chain. It returns an *InconsistentCertError for corrupt or
+// mismatched data.
+func LoadCert(s Store, domains []string) (*Cert, error) {
 	cert := &Cert{
 		Domains: domains,
 		Cert:    acme.CertificateResource{},
 	}
 
-	if err := cert.loadMeta(ec); err != nil {
+	if err := cert.loadMeta(s); err != nil {
+		return nil, err
+	}
+	if err := cert.loadCert(s); err != nil {
+		return nil, err
+	}
+	if err := cert.loadKey(s); err != nil {
+		return nil, err
+	}
+	if err := cert.loadCSR(s); err != nil {
 		return nil, err
 	}
-	if err := cert.loadCert(ec); err != nil {
+	if err := cert.loadIssuer(s); err != nil {
 		return nil, err
 	}
-	if err := cert.loadKey(ec); err != nil {
+	if err := cert.loadNextKey(s); err != nil {
+		return nil, err
+	}
+	if err := cert.verifySums(s); err != nil {
+		return nil, err
+	}
+	if err := cert.parseLoaded(); err != nil {
 		return nil, err
 	}
 
 	return cert, nil
 }
 
-// Reload re-reads the certificate from etcd.
-func (c *Cert) Reload(ec client.Client) error {
-	if err := c.loadMeta(ec); err != nil {
+// Reload re-reads and verifies the certificate from etcd, like LoadCert.
+func (c *Cert) Reload(s Store) error {
+	if err := c.loadMeta(s); err != nil {
 		return err
 	}
-	if err := c.loadCert(ec); err != nil {
+	if err := c.loadCert(s); err != nil {
 		return err
 	}
-	if err := c.loadKey(ec); err != nil {
+	if err := c.loadKey(s); err != nil {
 		return err
 	}
-	return nil
+	if err := c.loadCSR(s); err != nil {
+		return err
+	}
+	if err := c.loadIssuer(s); err != nil {
+		return err
+	}
+	if err := c.loadNextKey(s); err != nil {
+		return err
+	}
+	if err := c.verifySums(s); err != nil {
+		return err
+	}
+	return c.parseLoaded()
 }
 
 // MetaPath returns the path where the metadata of this certificate is store on etcd.
-func (c *Cert) MetaPath() string { return fmt.Sprintf(metaKey, c.Domains[0]) }
+func (c *Cert) MetaPath() string { return fmt.Sprintf(metaKey, StorageName(c.Domains[0])) }
 
 // CertPath returns the path where the CRT of this certificate is store on etcd.
-func (c *Cert) CertPath() string { return fmt.Sprintf(certKey, c.Domains[0]) }
+func (c *Cert) CertPath() string { return fmt.Sprintf(certKey, StorageName(c.Domains[0])) }
 
 // KeyPath returns the path where the PrivateKey of this certificate is store on etcd.
-func (c *Cert) KeyPath() string { return fmt.Sprintf(keyKey, c.Domains[0]) }
+func (c *Cert) KeyPath() string { return fmt.Sprintf(keyKey, StorageName(c.Domains[0])) }
 
 // PemPath returns the path where the PEM of this certificate is store on etcd.
-func (c *Cert) PemPath() string { return fmt.Sprintf(pemKey, c.Domains[0]) }
+func (c *Cert) PemPath() string { return fmt.Sprintf(pemKey, StorageName(c.Domains[0])) }
+
+// IssuerPath returns the path where the issuers of this certificate are store on etcd.
+func (c *Cert) IssuerPath() string { return fmt.Sprintf(issuerKey, StorageName(c.Domains[0])) }
+
+// P12Path returns the path where the PKCS#12 bundle of this certificate is store on etcd.
+func (c *Cert) P12Path() string { return fmt.Sprintf(p12Key, StorageName(c.Domains[0])) }
 
 // Renew renews the certificate through the ACME client.
 func (c *Cert) Renew(ac *Client, bundle bool) error {
-	cert, err := ac.RenewCertificate(c.Cert, bundle)
+	// certificates carrying a SPIFFE ID are re-issued with their URI SAN.
+	if id, err := c.SPIFFEID(); err == nil && id != nil {
+		return c.renewSPIFFE(ac, id.String(), bundle)
+	}
+	// make sure we may still issue for the domains of the certificate
+	if certs, err := parsePEMBundle(c.Cert.Certificate); err == nil {
+		if err := ac.checkIssuance(certs[0].DNSNames, true); err != nil {
+			return err
+		}
+	}
+	// the certificates obtained with a CSR are renewed with the same CSR
+	res := c.Cert
+	nextUsed := false
+	if len(res.CSR) == 0 && res.PrivateKey != nil {
+		var (
+			key crypto.PrivateKey
+			err error
+		)
+		if c.NextKey != nil && ac.PrivateKey == nil && !ac.ReuseKey {
+			// renew with the key whose pin was published ahead
+			key, err = parsePEMPrivateKey(c.NextKey)
+			nextUsed = true
+		} else {
+			key, err = ac.renewalKey(res.PrivateKey)
+		}
+		if err != nil {
+			return err
+		}
+		if res.PrivateKey, err = pemEncodePrivateKey(key); err != nil {
+			return err
+		}
+	}
+	var (
+		cert     acme.CertificateResource
+		renewErr error
+	)
+	timing, err := ac.timeIssuance(func() {
+		cert, renewErr = ac.renew(res, bundle)
+	})
 	if err != nil {
 		return err
 	}
+	if renewErr != nil {
+		return renewErr
+	}
+	recordIssuance(timing)
+	ac.preferChain(&cert)
+	if cert.PrivateKey == nil {
+		// renewed with the CSR, which is bound to the key
+		cert.PrivateKey = c.Cert.PrivateKey
+	}
+	// keep storing a reused certificate under its own domain
+	cert.Domain = c.Cert.Domain
 	c.Cert = cert
+	c.Timing = timing
+	if nextUsed {
+		// keep a key ahead for the following renewal
+		c.NextKey = nil
+		return c.PrepareNextKey("")
+	}
 	return nil
 }
 
@@ -130,14 +297,45 @@ func (c *Cert) Expiration() (time.Time, error) {
 	return acme.GetPEMCertExpiration(c.Cert.Certificate)
 }
 
-// ExpiresIn returns the duration until the certificate expires.
+// Leaf returns the parsed certificate, without the issuers of the bundle.
+func (c *Cert) Leaf() (*x509.Certificate, error) {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// ExpiresIn returns the duration until the certificate expires, according to
+// the DefaultClock.
 func (c *Cert) ExpiresIn() (time.Duration, error) {
+	return c.ExpiresInAt(DefaultClock.Now())
+}
+
+// ExpiresInAt returns the duration between now and the expiration of the
+// certificate.
+func (c *Cert) ExpiresInAt(now time.Time) (time.Duration, error) {
 	// get the expiration date/time
 	expTime, err := acme.GetPEMCertExpiration(c.Cert.Certificate)
 	if err != nil {
 		return 0, err
 	}
-	return expTime.Sub(time.Now()), nil
+	return expTime.Sub(now), nil
+}
+
+// Chain returns the PEM-encoded issuers of the certificate, taken from the
+// bundle or, for the certificates obtained with --no-bundle, from the issuer
+// certificate the CA linked to.
+func (c *Cert) Chain() []byte {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil || len(certs) < 2 {
+		return c.Cert.IssuerCertificate
+	}
+	var chain []byte
+	for _, crt := range certs[1:] {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})...)
+	}
+	return chain
 }
 
 // PEM returns this certificate PEM-encoded.
@@ -145,135 +343,212 @@ func (c *Cert) PEM() []byte {
 	return bytes.Join([][]byte{c.Cert.Certificate, c.Cert.PrivateKey}, nil)
 }
 
-// Save saves the certificate to etcd.
-func (c *Cert) Save(ec client.Client, pem bool) error {
-	if err := c.saveCert(ec); err != nil {
+// Save saves the certificate to etcd. All the keys are written in a single
+// transaction in the etcd v3 keyspace. In the v2 keyspace, the certificate key
+// watched by the consumers is written last, once the key, the PEM and the
+// metadata are in place. The certificate is validated first, an
+// *InconsistentCertError is returned rather than writing a certificate which
+// does not match its key, its chain or its domains.
+func (c *Cert) Save(s Store, pem bool) (err error) {
+	defer func() { audit(s, "save", StorageName(c.Cert.Domain), c.Domains, err) }()
+	if c.Cert.PrivateKey == nil && pem {
+		return ErrNoPemForCSR
+	}
+	if c.Cert.PrivateKey == nil && c.PKCS12Password != "" {
+		return ErrNoPKCS12ForCSR
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	// create the JSON
+	meta := certMeta{CertificateResource: c.Cert, Timing: c.Timing}
+	if meta.SPKIHash, err = c.SPKIHash(); err != nil {
+		return err
+	}
+	if meta.NextSPKIHash, err = c.NextSPKIHash(); err != nil {
+		return err
+	}
+	info, err := c.Info()
+	if err != nil {
 		return err
 	}
-	if err := c.saveMeta(ec); err != nil {
+	meta.CertInfo = *info
+	jsonBytes, err := json.Marshal(meta)
+	if err != nil {
 		return err
 	}
+	var kvs []KeyValue
+	if len(c.Cert.CSR) > 0 {
+		// the CSR is needed to renew the certificate
+		kvs = append(kvs, KeyValue{Key: fmt.Sprintf(csrKey, StorageName(c.Cert.Domain)), Value: string(c.Cert.CSR)})
+	}
 	if c.Cert.PrivateKey != nil {
-		if err := c.saveKey(ec); err != nil {
-			return err
-		}
+		kvs = append(kvs, KeyValue{Key: fmt.Sprintf(keyKey, StorageName(c.Cert.Domain)), Value: string(c.Cert.PrivateKey)})
 		if pem {
-			if err := c.savePem(ec); err != nil {
+			// combine the cert/key
+			kvs = append(kvs, KeyValue{Key: fmt.Sprintf(pemKey, StorageName(c.Cert.Domain)), Value: string(c.PEM())})
+		}
+		if c.PKCS12Password != "" {
+			p12, err := c.PKCS12(c.PKCS12Password)
+			if err != nil {
 				return err
 			}
+			// etcd values are strings, keep the binary bundle intact
+			kvs = append(kvs, KeyValue{Key: fmt.Sprintf(p12Key, StorageName(c.Cert.Domain)), Value: base64.StdEncoding.EncodeToString(p12)})
 		}
-	} else if pem {
-		return ErrNoPemForCSR
 	}
-
+	if c.NextKey != nil {
+		kvs = append(kvs, KeyValue{Key: fmt.Sprintf(nextKeyKey, StorageName(c.Cert.Domain)), Value: string(c.NextKey)})
+	}
+	if chain := c.Chain(); len(chain) > 0 {
+		kvs = append(kvs, KeyValue{Key: fmt.Sprintf(issuerKey, StorageName(c.Cert.Domain)), Value: string(chain)})
+	}
+	// index the names of the certificate for LoadCertBySAN
+	kvs = append(kvs, c.sanIndex(StorageName(c.Cert.Domain))...)
+	kvs = append(kvs,
+		KeyValue{Key: fmt.Sprintf(metaKey, StorageName(c.Cert.Domain)), Value: string(jsonBytes)},
+		KeyValue{Key: fmt.Sprintf(certKey, StorageName(c.Cert.Domain)), Value: string(c.Cert.Certificate)},
+	)
+	// the checksums go before the metadata and the certificate they verify
+	sums, err := sumsKeyValue(StorageName(c.Cert.Domain), kvs)
+	if err != nil {
+		return err
+	}
+	kvs = append([]KeyValue{sums}, kvs...)
+	if c.SigningKey != nil {
+		signature, err := sign(c.SigningKey, sums.Value)
+		if err != nil {
+			return err
+		}
+		kvs = append([]KeyValue{{Key: fmt.Sprintf(signatureKey, StorageName(c.Cert.Domain)), Value: signature}}, kvs...)
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	if c.Revision == 0 {
+		return s.SetAll(ctx, kvs)
+	}
+	rev, err := s.CompareAndSetAll(ctx, kvs, fmt.Sprintf(certKey, StorageName(c.Cert.Domain)), c.Revision)
+	if err != nil {
+		return err
+	}
+	c.Revision = rev
 	return nil
 }
 
-func (c *Cert) loadMeta(ec client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
+func (c *Cert) loadMeta(s Store) error {
 	// get it from etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	resp, err := kapi.Get(ctx, c.MetaPath(), nil)
+	value, err := c.get(s, c.MetaPath())
 	if err != nil {
 		return err
 	}
-	cancelFunc()
+	c.recordSum(metaKey, value)
 	// unmarshal right to the struct
-	return json.Unmarshal([]byte(resp.Node.Value), &c.Cert)
+	meta := certMeta{CertificateResource: c.Cert}
+	if err := json.Unmarshal([]byte(value), &meta); err != nil {
+		return err
+	}
+	c.Cert = meta.CertificateResource
+	c.Timing = meta.Timing
+	return nil
 }
 
-func (c *Cert) loadCert(ec client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
+func (c *Cert) loadCert(s Store) error {
 	// get it from etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	resp, err := kapi.Get(ctx, c.CertPath(), nil)
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	value, rev, err := s.GetRevision(ctx, c.CertPath())
 	if err != nil {
 		return err
 	}
-	cancelFunc()
+	c.recordSum(certKey, value)
 	// load the cert to the struct
-	c.Cert.Certificate = []byte(resp.Node.Value)
+	c.Cert.Certificate = []byte(value)
+	c.Revision = rev
 	return nil
 }
 
-func (c *Cert) loadKey(ec client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
+func (c *Cert) loadKey(s Store) error {
 	// get it from etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	resp, err := kapi.Get(ctx, c.KeyPath(), nil)
+	value, err := c.get(s, c.KeyPath())
 	if err != nil {
 		return err
 	}
-	cancelFunc()
+	c.recordSum(keyKey, value)
 	// load the cert to the struct
-	c.Cert.PrivateKey = []byte(resp.Node.Value)
+	c.Cert.PrivateKey = []byte(value)
 	return nil
 }
 
-func (c *Cert) saveCert(ec client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
-	// save it to etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	if _, err := kapi.Set(ctx, fmt.Sprintf(certKey, c.Cert.Domain), string(c.Cert.Certificate), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
+// Delete removes the certificate, its keys, its metadata, its checksums and
+// their signature, its PEM, its PKCS#12 bundle, its issuers, its CSR and its
+// history from etcd, along with the entries of the SAN index pointing to it.
+func (c *Cert) Delete(s Store) (err error) {
+	stored := StorageName(c.Domains[0])
+	defer func() { audit(s, "delete", stored, c.Domains, err) }()
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	var keys []string
+	for _, kv := range c.sanIndex(stored) {
+		// leave the entries taken over by another certificate
+		if value, err := s.Get(ctx, kv.Key); err == nil && value == stored {
+			keys = append(keys, kv.Key)
+		}
+	}
+	history, err := s.Keys(ctx, fmt.Sprintf(historyPrefix, stored))
+	if err != nil {
 		return err
 	}
-
-	cancelFunc()
+	keys = append(keys, history...)
+	// the certificate key watched by the consumers goes first
+	keys = append(keys, c.CertPath(), c.KeyPath(), c.MetaPath(), c.PemPath(), c.P12Path(), c.IssuerPath(), c.NextKeyPath(), fmt.Sprintf(sumsKey, stored), fmt.Sprintf(signatureKey, stored), fmt.Sprintf(csrKey, stored))
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil && !IsKeyNotFound(err) {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *Cert) saveKey(ec client.Client) error {
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
-	// save it to etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	if _, err := kapi.Set(ctx, fmt.Sprintf(keyKey, c.Cert.Domain), string(c.Cert.PrivateKey), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
+// loadCSR loads the CSR the certificate was obtained with, if it was saved.
+func (c *Cert) loadCSR(s Store) error {
+	value, err := c.get(s, fmt.Sprintf(csrKey, StorageName(c.Domains[0])))
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return nil
+		}
 		return err
 	}
-
-	cancelFunc()
+	c.Cert.CSR = []byte(value)
 	return nil
 }
 
-func (c *Cert) saveMeta(ec client.Client) error {
-	// create the JSON
-	jsonBytes, err := json.Marshal(c.Cert)
+// loadIssuer loads the issuers of the certificate, if they were saved.
+func (c *Cert) loadIssuer(s Store) error {
+	value, err := c.get(s, c.IssuerPath())
 	if err != nil {
+		if IsKeyNotFound(err) {
+			return nil
+		}
 		return err
 	}
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
-	// save it to etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	if _, err := kapi.Set(ctx, fmt.Sprintf(metaKey, c.Cert.Domain), string(jsonBytes), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
-		return err
-	}
-
-	cancelFunc()
+	c.Cert.IssuerCertificate = []byte(value)
 	return nil
 }
 
-func (c *Cert) savePem(ec client.Client) error {
-	// combine the cert/key
-	pem := c.PEM()
-	// create a new keys API
-	kapi := client.NewKeysAPI(ec)
-	// save it to etcd
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
-	if _, err := kapi.Set(ctx, fmt.Sprintf(pemKey, c.Cert.Domain), string(pem), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
-		return err
-	}
+func (c *Cert) get(s Store, key string) (string, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return s.Get(ctx, key)
+}
 
-	cancelFunc()
-	return nil
+func (c *Cert) set(s Store, key, value string) error {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return s.Set(ctx, key, value)
 }
 
-func readCSRFile(filename string) (*x509.CertificateRequest, error) {
-	bytes, err := ioutil.ReadFile(filename)
+func readCSR(s Store, source string) (*x509.CertificateRequest, error) {
+	bytes, err := readSource(s, source)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,162 @@
+package legoetcd_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+func TestCertSaveLoad(t *testing.T) {
+	ca, err := legoetcdtest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		domains []string
+		pem     bool
+	}{
+		{"single domain", []string{"example.com"}, false},
+		{"with the PEM bundle", []string{"example.com"}, true},
+		{"several domains", []string{"example.com", "www.example.com"}, false},
+		{"wildcard", []string{"*.example.com"}, true},
+		{"IP address", []string{"192.0.2.1"}, false},
+	}
+	for _, tt := range tests {
+		for kind, s := range stores() {
+			tt, s := tt, s
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				cert, err := ca.Issue(tt.domains, 90*24*time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cert.Save(s, tt.pem); err != nil {
+					t.Fatal(err)
+				}
+				loaded, err := legoetcd.LoadCert(s, tt.domains)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(loaded.Cert.Certificate, cert.Cert.Certificate) {
+					t.Error("the loaded certificate differs from the saved one")
+				}
+				if !bytes.Equal(loaded.Cert.PrivateKey, cert.Cert.PrivateKey) {
+					t.Error("the loaded key differs from the saved one")
+				}
+				if loaded.Revision == 0 {
+					t.Error("want the revision the certificate was loaded at")
+				}
+				ctx := context.Background()
+				_, err = s.Get(ctx, loaded.PemPath())
+				if tt.pem && err != nil {
+					t.Errorf("want the PEM bundle saved, got %v", err)
+				}
+				if !tt.pem && !legoetcd.IsKeyNotFound(err) {
+					t.Errorf("want no PEM bundle saved, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestCertLoadMissing(t *testing.T) {
+	for kind, s := range stores() {
+		if _, err := legoetcd.LoadCert(s, []string{"example.com"}); !legoetcd.IsKeyNotFound(err) {
+			t.Errorf("%s: want a key not found error, got %v", kind, err)
+		}
+	}
+}
+
+func TestCertSaveConflict(t *testing.T) {
+	ca, err := legoetcdtest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	domains := []string{"example.com"}
+	for kind, s := range stores() {
+		first, err := ca.Issue(domains, 90*24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := first.Save(s, false); err != nil {
+			t.Fatal(err)
+		}
+		// two instances load the certificate and renew it
+		ours, err := legoetcd.LoadCert(s, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		theirs, err := legoetcd.LoadCert(s, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []*legoetcd.Cert{theirs, ours} {
+			renewed, err := ca.Issue(domains, 90*24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Cert.Certificate, c.Cert.PrivateKey = renewed.Cert.Certificate, renewed.Cert.PrivateKey
+		}
+		if err := theirs.Save(s, false); err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if err := ours.Save(s, false); err != legoetcd.ErrConflict {
+			t.Fatalf("%s: want ErrConflict saving over their certificate, got %v", kind, err)
+		}
+		loaded, err := legoetcd.LoadCert(s, domains)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if !bytes.Equal(loaded.Cert.Certificate, theirs.Cert.Certificate) {
+			t.Errorf("%s: want their certificate kept", kind)
+		}
+		// ours picks up theirs when reloaded
+		if err := ours.Reload(s); err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if !bytes.Equal(ours.Cert.Certificate, theirs.Cert.Certificate) || ours.Revision != theirs.Revision {
+			t.Errorf("%s: want their certificate at revision %d reloaded, got revision %d", kind, theirs.Revision, ours.Revision)
+		}
+	}
+}
+
+func TestCertLoadCorrupt(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		key  func(c *legoetcd.Cert) string
+	}{
+		{"certificate", (*legoetcd.Cert).CertPath},
+		{"key", (*legoetcd.Cert).KeyPath},
+	}
+	for _, tt := range tests {
+		for kind, s := range stores() {
+			cert, err := legoetcdtest.SeedCert(s, []string{"example.com"}, 90*24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := legoetcdtest.SeedCert(legoetcdtest.NewStore(), []string{"example.com"}, 90*24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// overwrite the value without its checksum, like a partial write
+			value := string(other.Cert.Certificate)
+			if tt.key(cert) == cert.KeyPath() {
+				value = string(other.Cert.PrivateKey)
+			}
+			if err := s.Set(ctx, tt.key(cert), value); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := legoetcd.LoadCert(s, []string{"example.com"}); err == nil {
+				t.Errorf("%s/%s: want an error loading the corrupt certificate", kind, tt.name)
+			} else if _, ok := err.(*legoetcd.InconsistentCertError); !ok {
+				t.Errorf("%s/%s: want an *InconsistentCertError, got %T: %s", kind, tt.name, err, err)
+			}
+		}
+	}
+}
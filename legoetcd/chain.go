@@ -0,0 +1,45 @@
+package legoetcd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// preferChain makes the bundle of the certificate end at the preferred issuer
+// by dropping the certificates chaining above it, for instance the cross-sign
+// of a root. ACME v1 servers only offer a single chain, the bundle is left
+// untouched if the preferred issuer is not part of it.
+func (c *Client) preferChain(cert *acme.CertificateResource) {
+	if c.PreferredChain == "" {
+		return
+	}
+	var (
+		chain []byte
+		found bool
+	)
+	rest := cert.Certificate
+	for !found {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			break
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		crt, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return
+		}
+		chain = append(chain, pem.EncodeToMemory(p)...)
+		found = crt.Issuer.CommonName == c.PreferredChain || crt.Subject.CommonName == c.PreferredChain
+	}
+	if !found {
+		log.Printf("the chain of the certificate for %s does not contain %q, keeping the default chain", cert.Domain, c.PreferredChain)
+		return
+	}
+	cert.Certificate = chain
+}
@@ -0,0 +1,75 @@
+package legoetcd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+)
+
+// sumsKey holds the SHA-256 checksums of the certificate, its key and its
+// metadata, by suffix, written along with them.
+const sumsKey = "/lego/certificates/%s.sums"
+
+// checksum returns the hex SHA-256 checksum of the value.
+func checksum(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// sumsKeyValue returns the checksums of the certificate, key and metadata
+// keys among kvs, for the certificate stored under stored.
+func sumsKeyValue(stored string, kvs []KeyValue) (KeyValue, error) {
+	sums := make(map[string]string)
+	for _, kv := range kvs {
+		for _, format := range []string{certKey, keyKey, metaKey} {
+			if kv.Key == fmt.Sprintf(format, stored) {
+				sums[path.Ext(format)] = checksum(kv.Value)
+			}
+		}
+	}
+	value, err := json.Marshal(sums)
+	if err != nil {
+		return KeyValue{}, err
+	}
+	return KeyValue{Key: fmt.Sprintf(sumsKey, stored), Value: string(value)}, nil
+}
+
+// recordSum records the checksum of a value loaded from etcd, for verifySums.
+func (c *Cert) recordSum(format, value string) {
+	if c.loadedSums == nil {
+		c.loadedSums = make(map[string]string)
+	}
+	c.loadedSums[path.Ext(format)] = checksum(value)
+}
+
+// verifySums compares the checksums of the values loaded from etcd with those
+// stored along with them, and returns an *InconsistentCertError for a silent
+// corruption or a partial write. The certificates saved before the checksums
+// were stored are not verified.
+func (c *Cert) verifySums(s Store) error {
+	value, err := c.get(s, fmt.Sprintf(sumsKey, StorageName(c.Domains[0])))
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return c.checkSums(value)
+}
+
+// checkSums compares the checksums of the values loaded from etcd with the
+// stored checksums.
+func (c *Cert) checkSums(value string) error {
+	var sums map[string]string
+	if err := json.Unmarshal([]byte(value), &sums); err != nil {
+		return c.inconsistent("invalid checksums: %s", err)
+	}
+	for suffix, want := range sums {
+		if c.loadedSums[suffix] != want {
+			return c.inconsistent("the checksum of the %s key does not match", suffix)
+		}
+	}
+	return nil
+}
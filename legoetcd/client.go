@@ -1,10 +1,12 @@
 package legoetcd
 
 import (
+	"crypto"
 	"errors"
 	"fmt"
+	"log"
+	"time"
 
-	"github.com/coreos/etcd/client"
 	"github.com/xenolf/lego/acme"
 )
 
@@ -18,14 +20,78 @@ var (
 type Client struct {
 	*acme.Client
 	Account *Account
+	// MustStaple requests the OCSP Must-Staple extension in the certificates
+	// obtained for domains, the CSRs provided by the user are sent as is.
+	MustStaple bool
+	// ReuseKey renews the certificates with their private key, for the
+	// consumers pinning or caching the key, instead of a new key of the same
+	// type.
+	ReuseKey bool
+	// ReuseExistingFor, if positive, makes NewCert reuse a certificate stored
+	// under another domain which covers all the requested domains and is
+	// valid for at least this long, instead of ordering a duplicate. The
+	// certificate is copied under the first requested domain.
+	ReuseExistingFor time.Duration
+	// PrivateKey, if set, is the key the certificates are obtained and
+	// renewed with instead of a generated one, see LoadPrivateKey.
+	PrivateKey crypto.PrivateKey
+	// PreferredChain is the common name of the issuer the bundled chain should
+	// end at, such as "ISRG Root X1".
+	PreferredChain string
+	// HostPolicy restricts the domains certificates may be issued for, nil
+	// allows all the domains.
+	HostPolicy *HostPolicy
+	// Authorizer, if set, is invoked before ordering any certificate.
+	Authorizer Authorizer
+	// Requester and Source identify the issuances submitted to the
+	// Authorizer.
+	Requester string
+	Source    string
+	// RateLimiter, if set, refuses the issuances which would exceed the rate
+	// limits of the CA across all the clients sharing the etcd cluster.
+	RateLimiter *RateLimiter
+	// CAAIdentities are the CAA identities of the CA, such as
+	// letsencrypt.org, the CAA records of the domains are checked against
+	// before ordering. It defaults to letsencrypt.org for the Let's Encrypt
+	// directories, the check is skipped when it is empty.
+	CAAIdentities []string
+	// Retry is how the orders and the renewals failing with a transient error
+	// are retried, DefaultRetryPolicy by default.
+	Retry RetryPolicy
+	// Clock drives the waits between the retries, it defaults to
+	// DefaultClock.
+	Clock Clock
+	// TOSPrompt, if set, is asked whether the terms of service at the URL are
+	// accepted when RegisterAccount was not told to accept them, for instance
+	// by prompting the user. RegisterAccount fails with ErrMustAcceptTOS if it
+	// is nil, which is what the daemons want.
+	TOSPrompt func(tosURL string) (bool, error)
+
+	store          Store
+	directoryURL   string
+	keyType        acme.KeyType
+	provider       string
+	dnsChallenge   bool
+	challengeTimer challengeTimer
 }
 
 // New returns a new ACME client configured with the challenge.
-func New(ec client.Client, acmeServer, email string, keyType acme.KeyType, dns, webRoot, httpAddr, tlsAddr string) (*Client, error) {
+func New(s Store, acmeServer, email string, keyType acme.KeyType, challenge ChallengeConfig) (*Client, error) {
+	// only internal CAs may be used offline
+	if Offline && publicCA(acmeServer) {
+		return nil, ErrOffline
+	}
 	// create a new Client
-	c := &Client{}
+	if acme.UserAgent == "" {
+		// identify lego-etcd unless ConfigureACMEHTTP was called
+		acme.UserAgent = UserAgent
+	}
+	c := &Client{store: s, directoryURL: acmeServer, keyType: keyType, Retry: DefaultRetryPolicy}
+	if publicCA(acmeServer) {
+		c.CAAIdentities = []string{"letsencrypt.org"}
+	}
 	// setup the account
-	if err := c.setupAccount(ec, email); err != nil {
+	if err := c.setupAccount(s, email); err != nil {
 		return nil, err
 	}
 	// create a new ACME client
@@ -35,7 +101,7 @@ func New(ec client.Client, acmeServer, email string, keyType acme.KeyType, dns,
 	}
 	c.Client = acmeClient
 	// setup the challenge
-	if err := c.setupChallenge(dns, webRoot, httpAddr, tlsAddr); err != nil {
+	if err := c.setupChallenge(challenge); err != nil {
 		return nil, err
 	}
 
@@ -43,17 +109,17 @@ func New(ec client.Client, acmeServer, email string, keyType acme.KeyType, dns,
 }
 
 // RegisterAccount registers the account
-func (c *Client) RegisterAccount(ec client.Client, acceptTOS bool) error {
+func (c *Client) RegisterAccount(s Store, acceptTOS bool) error {
 	// does the account needs to be registered?
-	if err := c.Account.LoadRegistration(ec); err != nil {
-		if client.IsKeyNotFound(err) {
+	if err := c.Account.LoadRegistration(s); err != nil {
+		if IsKeyNotFound(err) {
 			// register the account first
 			if err := c.Account.Register(c.Client); err != nil {
 				return fmt.Errorf("error registering the account with the ACME server: %s", err)
 			}
 
 			// save the account now
-			if err := c.Account.Save(ec); err != nil {
+			if err := c.Account.Save(s); err != nil {
 				return fmt.Errorf("error saving the account to etcd: %s", err)
 			}
 		} else {
@@ -62,16 +128,30 @@ func (c *Client) RegisterAccount(ec client.Client, acceptTOS bool) error {
 	}
 
 	// do we need to accept TOS?
-	if c.Account.GetRegistration().Body.Agreement == "" {
+	reg := c.Account.GetRegistration()
+	if reg.Body.Agreement == "" {
+		// agree to the current terms, the agreement records their URL in the
+		// stored registration.
+		if tosURL := c.currentTOS(); tosURL != "" {
+			reg.TosURL = tosURL
+		}
+		if !acceptTOS && c.TOSPrompt != nil {
+			accepted, err := c.TOSPrompt(reg.TosURL)
+			if err != nil {
+				return err
+			}
+			acceptTOS = accepted
+		}
 		if acceptTOS {
 			// accept the TOS
 			if err := c.Client.AgreeToTOS(); err != nil {
 				return fmt.Errorf("could not agree to TOS: %s", err)
 			}
 			// save the account now
-			if err := c.Account.Save(ec); err != nil {
+			if err := c.Account.Save(s); err != nil {
 				return fmt.Errorf("error saving the account to etcd: %s", err)
 			}
+			log.Printf("accepted the terms of service at %s", reg.TosURL)
 		} else {
 			return ErrMustAcceptTOS
 		}
@@ -79,3 +159,19 @@ func (c *Client) RegisterAccount(ec client.Client, acceptTOS bool) error {
 
 	return nil
 }
+
+// currentTOS returns the URL of the current terms of service advertised in
+// the meta of the directory, or an empty string if the CA does not advertise
+// them and the URL linked at the registration must be used.
+func (c *Client) currentTOS() string {
+	var dir struct {
+		Meta struct {
+			TermsOfService string `json:"terms-of-service"`
+		} `json:"meta"`
+	}
+	if err := getJSON(c.directoryURL, &dir); err != nil {
+		log.Printf("error fetching the terms of service from the directory: %s", err)
+		return ""
+	}
+	return dir.Meta.TermsOfService
+}
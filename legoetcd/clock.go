@@ -0,0 +1,55 @@
+package legoetcd
+
+import "time"
+
+// Clock tells the time and creates the timers of the renewal logic, the tests
+// replace it to move the time forward without sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel receiving the time once d elapsed.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker ticking every d.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine once d elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer calls a function once, like the time.Timer of time.AfterFunc.
+type Timer interface {
+	// Stop prevents the function from being called, it returns false if it
+	// was already called or the timer stopped.
+	Stop() bool
+}
+
+// Ticker delivers ticks at intervals, like a time.Ticker.
+type Ticker interface {
+	// C returns the channel the ticks are delivered on.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// DefaultClock is the clock used by the library, it must be changed before
+// creating the clients and the services.
+var DefaultClock Clock = systemClock{}
+
+// systemClock is the Clock of the system.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// systemTicker is a Ticker backed by a time.Ticker.
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+
+func (t systemTicker) Stop() { t.t.Stop() }
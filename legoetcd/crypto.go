@@ -0,0 +1,139 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// mustStapleExtension is the TLS Feature extension (RFC 7633) requesting the
+// status_request feature, also known as OCSP Must-Staple.
+var mustStapleExtension = pkix.Extension{
+	Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24},
+	Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05},
+}
+
+// generatePrivateKey generates a new private key of the given type.
+func generatePrivateKey(keyType acme.KeyType) (crypto.PrivateKey, error) {
+	switch keyType {
+	case acme.EC256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case acme.EC384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case acme.RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case acme.RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case acme.RSA8192:
+		return rsa.GenerateKey(rand.Reader, 8192)
+	default:
+		return nil, ErrUnknowKeyType
+	}
+}
+
+// keyTypeOf returns the type of the private key.
+func keyTypeOf(key crypto.PrivateKey) (acme.KeyType, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return acme.RSA2048, nil
+		case 4096:
+			return acme.RSA4096, nil
+		case 8192:
+			return acme.RSA8192, nil
+		}
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return acme.EC256, nil
+		case elliptic.P384():
+			return acme.EC384, nil
+		}
+	}
+	return "", ErrUnknowKeyType
+}
+
+// renewalKey returns the private key to renew the certificate with, the key of
+// the client if it was given one, the key of the certificate if the client
+// reuses the keys, or a new key of the same type otherwise.
+func (c *Client) renewalKey(pemKey []byte) (crypto.PrivateKey, error) {
+	if c.PrivateKey != nil {
+		return c.PrivateKey, nil
+	}
+	key, err := parsePEMPrivateKey(pemKey)
+	if err != nil || c.ReuseKey {
+		return key, err
+	}
+	keyType, err := keyTypeOf(key)
+	if err != nil {
+		keyType = c.keyType
+	}
+	return generatePrivateKey(keyType)
+}
+
+// pemEncodePrivateKey encodes the private key as PEM.
+func pemEncodePrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case *ecdsa.PrivateKey:
+		keyBytes, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), nil
+	default:
+		return nil, ErrUnknowKeyType
+	}
+}
+
+// parsePEMPrivateKey decodes a PEM-encoded RSA or EC private key.
+func parsePEMPrivateKey(key []byte) (crypto.PrivateKey, error) {
+	keyBlock, _ := pem.Decode(key)
+	if keyBlock == nil {
+		return nil, ErrUnknowKeyType
+	}
+	switch keyBlock.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(keyBlock.Bytes)
+	default:
+		return nil, ErrUnknowKeyType
+	}
+}
+
+// parsePEMBundle decodes all the certificates of a PEM bundle, the leaf being
+// the first one.
+func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := bundle
+	for {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			break
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return certs, nil
+}
@@ -0,0 +1,120 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+)
+
+// ErrEmptyCSRConfig is returned when a CSRConfig names no identifier.
+var ErrEmptyCSRConfig = errors.New("the CSR needs a common name, a DNS name or an IP address")
+
+// CSRConfig declares the attributes of a CSR generated by lego-etcd, instead
+// of a CSR file generated out-of-band.
+type CSRConfig struct {
+	// CommonName defaults to the first DNS name.
+	CommonName   string   `json:"commonName,omitempty"`
+	DNSNames     []string `json:"dnsNames,omitempty"`
+	IPAddresses  []net.IP `json:"ipAddresses,omitempty"`
+	Organization []string `json:"organization,omitempty"`
+	// MustStaple requests the OCSP Must-Staple extension.
+	MustStaple bool `json:"mustStaple,omitempty"`
+}
+
+// Names returns the common name followed by the other DNS names and the IP
+// addresses, the first one is used to store the certificate in etcd.
+func (cfg *CSRConfig) Names() []string {
+	var names []string
+	if cfg.CommonName != "" {
+		names = append(names, cfg.CommonName)
+	}
+	for _, name := range cfg.DNSNames {
+		if name != cfg.CommonName {
+			names = append(names, name)
+		}
+	}
+	for _, ip := range cfg.IPAddresses {
+		if ip.String() != cfg.CommonName {
+			names = append(names, ip.String())
+		}
+	}
+	return names
+}
+
+// CreateCSR creates the CSR signed by the key.
+func (cfg *CSRConfig) CreateCSR(key crypto.PrivateKey) (*x509.CertificateRequest, error) {
+	names, err := NormalizeDomains(cfg.Names())
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, ErrEmptyCSRConfig
+	}
+	dnsNames, err := NormalizeDomains(cfg.DNSNames)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: names[0], Organization: cfg.Organization},
+		DNSNames:    dnsNames,
+		IPAddresses: cfg.IPAddresses,
+	}
+	if cfg.MustStaple {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, mustStapleExtension)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificateRequest(der)
+}
+
+// NewCertFromConfig generates a CSR from the config, with the client's
+// PrivateKey or a new key, and obtains a certificate for it. The CSR is saved
+// along with the certificate and the certificate is renewed with it, keeping
+// its key. The IP addresses are requested as IP SANs, but this ACME client
+// only knows of the dns identifiers of ACME v1 and authorizes the common name
+// and the DNS names of the CSR: the CA must accept an IP address as the common
+// name, and the certificates for several IP addresses need a CA which does not
+// require their authorization.
+func (c *Client) NewCertFromConfig(cfg *CSRConfig, bundle bool) (*Cert, map[string]error) {
+	key := c.PrivateKey
+	if key == nil {
+		var err error
+		if key, err = generatePrivateKey(c.keyType); err != nil {
+			return nil, map[string]error{"key": err}
+		}
+	}
+	keyPEM, err := pemEncodePrivateKey(key)
+	if err != nil {
+		return nil, map[string]error{"key": err}
+	}
+	csr, err := cfg.CreateCSR(key)
+	if err != nil {
+		return nil, map[string]error{"csr": err}
+	}
+	if err := c.checkIssuance(csrDomains(csr), false); err != nil {
+		return nil, map[string]error{"policy": err}
+	}
+	var failures map[string]error
+	// the names were normalized by CreateCSR already
+	domains, _ := NormalizeDomains(cfg.Names())
+	cert := &Cert{Domains: domains, CSR: csr}
+	timing, err := c.timeIssuance(func() {
+		cert.Cert, failures = c.obtainForCSR(*csr, bundle)
+	})
+	if err != nil {
+		return nil, map[string]error{"deadline": err}
+	}
+	if len(failures) > 0 {
+		return nil, failures
+	}
+	recordIssuance(timing)
+	c.preferChain(&cert.Cert)
+	cert.Cert.PrivateKey = keyPEM
+	cert.Timing = timing
+	return cert, nil
+}
@@ -0,0 +1,44 @@
+package legoetcd
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+)
+
+// DebugHandler serves the pprof profiles under /debug/pprof/ and the expvar
+// counters under /debug/vars, to diagnose a wedged process in production. It
+// must not be exposed publicly, the profiles leak the internals of the
+// process.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", serveVars)
+	return mux
+}
+
+// ServeDebug serves the DebugHandler on addr, it only returns on error.
+func ServeDebug(addr string) error {
+	return http.ListenAndServe(addr, DebugHandler())
+}
+
+// serveVars writes the expvar counters as JSON, like the handler expvar
+// registers on the default mux.
+func serveVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
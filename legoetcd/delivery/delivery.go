@@ -0,0 +1,243 @@
+// Package delivery streams the certificates over gRPC, to the services which
+// cannot reach etcd or are not written in Go. The messages are encoded in JSON
+// with the Codec, the clients in other languages must register the same codec
+// for the /legoetcd.Delivery/Subscribe method.
+package delivery
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// subscriberBuffer is the number of certificates queued for a subscriber, a
+// subscriber falling further behind is disconnected.
+const subscriberBuffer = 16
+
+// Certificate is a certificate sent to the subscribers.
+type Certificate struct {
+	// Domain is the domain the certificate is stored under.
+	Domain  string   `json:"domain"`
+	Domains []string `json:"domains"`
+	// Certificate is the PEM-encoded certificate followed by its chain.
+	Certificate []byte `json:"certificate"`
+	// PrivateKey is the PEM-encoded private key, empty for a certificate
+	// obtained with a CSR.
+	PrivateKey []byte    `json:"privateKey,omitempty"`
+	NotAfter   time.Time `json:"notAfter"`
+	// Revision is the etcd revision of the certificate.
+	Revision int64 `json:"revision"`
+}
+
+// SubscribeRequest selects the certificates sent to a subscriber.
+type SubscribeRequest struct {
+	// Domains are the domains the certificates are stored under, all the
+	// certificates are sent if empty.
+	Domains []string `json:"domains,omitempty"`
+}
+
+// Codec encodes the messages in JSON.
+type Codec struct{}
+
+// Marshal implements grpc.Codec.
+func (Codec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements grpc.Codec.
+func (Codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// String implements grpc.Codec.
+func (Codec) String() string { return "json" }
+
+// Server sends the certificates it is given with Publish to its subscribers,
+// starting with the last certificate published for each domain.
+type Server struct {
+	// Token, if set, must be sent by the clients in the authorization
+	// metadata as "Bearer <token>".
+	Token string
+
+	mu          sync.Mutex
+	latest      map[string]*Certificate
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	domains map[string]bool
+	certs   chan *Certificate
+	// dropped is closed when the subscriber fell behind.
+	dropped chan struct{}
+}
+
+// NewServer returns a server requiring the token if it is not empty.
+func NewServer(token string) *Server {
+	return &Server{
+		Token:       token,
+		latest:      make(map[string]*Certificate),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// ServerOptions returns the options of the gRPC server the Server is
+// registered with, they set the Codec.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.CustomCodec(Codec{})}
+}
+
+// Register registers the server as the Delivery service of the gRPC server,
+// which must be created with ServerOptions.
+func (s *Server) Register(gs *grpc.Server) {
+	gs.RegisterService(&serviceDesc, s)
+}
+
+// Publish sends the certificate to the subscribers.
+func (s *Server) Publish(cert *legoetcd.Cert) {
+	notAfter, _ := cert.Expiration()
+	c := &Certificate{
+		Domain:      cert.Domains[0],
+		Domains:     append([]string(nil), cert.Domains...),
+		Certificate: append([]byte(nil), cert.Cert.Certificate...),
+		PrivateKey:  append([]byte(nil), cert.Cert.PrivateKey...),
+		NotAfter:    notAfter,
+		Revision:    cert.Revision,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest[c.Domain] = c
+	for sub := range s.subscribers {
+		if !sub.wants(c.Domain) {
+			continue
+		}
+		select {
+		case sub.certs <- c:
+		default:
+			// never block the publisher on a slow subscriber
+			delete(s.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+func (sub *subscriber) wants(domain string) bool {
+	return len(sub.domains) == 0 || sub.domains[domain]
+}
+
+// subscribe streams the certificates until the client goes away.
+func (s *Server) subscribe(req *SubscribeRequest, stream grpc.ServerStream) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	sub := &subscriber{
+		domains: make(map[string]bool),
+		dropped: make(chan struct{}),
+	}
+	for _, domain := range req.Domains {
+		sub.domains[domain] = true
+	}
+	s.mu.Lock()
+	var replay []*Certificate
+	for domain, c := range s.latest {
+		if sub.wants(domain) {
+			replay = append(replay, c)
+		}
+	}
+	// the buffer holds the whole replay on top of the certificates published
+	// meanwhile
+	sub.certs = make(chan *Certificate, len(replay)+subscriberBuffer)
+	for _, c := range replay {
+		sub.certs <- c
+	}
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+	}()
+	for {
+		select {
+		case c := <-sub.certs:
+			if err := stream.SendMsg(c); err != nil {
+				return err
+			}
+		case <-sub.dropped:
+			return grpc.Errorf(codes.ResourceExhausted, "the subscriber fell behind")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	if s.Token == "" {
+		return nil
+	}
+	md, _ := metadata.FromContext(ctx)
+	for _, v := range md["authorization"] {
+		token := strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1 {
+			return nil
+		}
+	}
+	return grpc.Errorf(codes.Unauthenticated, "invalid token")
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "legoetcd.Delivery",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Subscribe",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := new(SubscribeRequest)
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(*Server).subscribe(req, stream)
+		},
+	}},
+}
+
+// Subscribe streams the certificates of the domains, or all the certificates
+// if domains is empty, from the server at the other end of conn, which must be
+// dialed with grpc.WithCodec(Codec{}). The channel is closed with the stream,
+// the error of the stream is then sent on the error channel.
+func Subscribe(ctx context.Context, conn *grpc.ClientConn, token string, domains []string) (<-chan *Certificate, <-chan error, error) {
+	if token != "" {
+		ctx = metadata.NewContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	}
+	stream, err := grpc.NewClientStream(ctx, &serviceDesc.Streams[0], conn, "/legoetcd.Delivery/Subscribe")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := stream.SendMsg(&SubscribeRequest{Domains: domains}); err != nil {
+		return nil, nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, nil, err
+	}
+	certs, errc := make(chan *Certificate), make(chan error, 1)
+	go func() {
+		defer close(certs)
+		for {
+			c := new(Certificate)
+			if err := stream.RecvMsg(c); err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case certs <- c:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return certs, errc, nil
+}
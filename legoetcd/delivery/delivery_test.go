@@ -0,0 +1,164 @@
+package delivery
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+// serve serves the server over gRPC on a local port, and returns a connection
+// to it.
+func serve(t *testing.T, s *Server) (*grpc.ClientConn, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer(ServerOptions()...)
+	s.Register(gs)
+	go gs.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure(), grpc.WithCodec(Codec{}))
+	if err != nil {
+		gs.Stop()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		gs.Stop()
+	}
+}
+
+// openStream subscribes to the domains with the token, if any.
+func openStream(ctx context.Context, t *testing.T, conn *grpc.ClientConn, token string, domains ...string) grpc.ClientStream {
+	if token != "" {
+		ctx = metadata.NewContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	}
+	stream, err := grpc.NewClientStream(ctx, &serviceDesc.Streams[0], conn, "/legoetcd.Delivery/Subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&SubscribeRequest{Domains: domains}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// recv returns the next certificate of the stream.
+func recv(t *testing.T, stream grpc.ClientStream) *Certificate {
+	c := new(Certificate)
+	if err := stream.RecvMsg(c); err != nil {
+		t.Fatalf("error receiving a certificate: %s", err)
+	}
+	return c
+}
+
+func testCert(domain, certificate string) *legoetcd.Cert {
+	return &legoetcd.Cert{
+		Domains: []string{domain},
+		Cert:    acme.CertificateResource{Domain: domain, Certificate: []byte(certificate)},
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewServer("secret")
+	conn, stop := serve(t, s)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.Publish(testCert("example.com", "first"))
+	s.Publish(testCert("example.org", "other"))
+	stream := openStream(ctx, t, conn, "secret", "example.com")
+	// the latest certificate of the domain is sent first
+	if c := recv(t, stream); c.Domain != "example.com" || string(c.Certificate) != "first" {
+		t.Fatalf("want the latest certificate of example.com, got %s of %s", c.Certificate, c.Domain)
+	}
+	// only the domains subscribed to are sent next
+	s.Publish(testCert("example.org", "other renewed"))
+	s.Publish(testCert("example.com", "renewed"))
+	if c := recv(t, stream); c.Domain != "example.com" || string(c.Certificate) != "renewed" {
+		t.Fatalf("want the renewed certificate of example.com, got %s of %s", c.Certificate, c.Domain)
+	}
+}
+
+func TestSubscribeInvalidToken(t *testing.T) {
+	s := NewServer("secret")
+	conn, stop := serve(t, s)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, token := range []string{"", "wrong"} {
+		stream := openStream(ctx, t, conn, token)
+		if err := stream.RecvMsg(new(Certificate)); grpc.Code(err) != codes.Unauthenticated {
+			t.Errorf("want the token %q refused, got %v", token, err)
+		}
+	}
+}
+
+// recordingStream is a server stream recording the certificates sent on it.
+type recordingStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *Certificate
+}
+
+func (s *recordingStream) Context() context.Context { return s.ctx }
+
+func (s *recordingStream) SendMsg(m interface{}) error {
+	s.sent <- m.(*Certificate)
+	return nil
+}
+
+func TestSubscribeReplaysTheLatestCertificates(t *testing.T) {
+	ca, err := legoetcdtest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("")
+	// more certificates than a subscriber may queue
+	published := make(map[string]bool)
+	for i := 0; i < 2*subscriberBuffer; i++ {
+		domain := fmt.Sprintf("%d.example.com", i)
+		cert, err := ca.Issue([]string{domain}, 90*24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Publish(cert)
+		published[domain] = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &recordingStream{ctx: ctx, sent: make(chan *Certificate)}
+	done := make(chan error, 1)
+	go func() { done <- s.subscribe(&SubscribeRequest{}, stream) }()
+	for len(published) > 0 {
+		select {
+		case c := <-stream.sent:
+			if !published[c.Domain] {
+				t.Fatalf("want each certificate replayed once, got %s again", c.Domain)
+			}
+			delete(published, c.Domain)
+		case err := <-done:
+			t.Fatalf("want the certificates replayed, the subscription ended with %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want the certificates replayed, %d are missing", len(published))
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("want the subscription canceled, got %v", err)
+	}
+}
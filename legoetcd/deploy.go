@@ -0,0 +1,82 @@
+package legoetcd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// deploymentPollInterval is how often VerifyDeployment connects to the
+// endpoint.
+const deploymentPollInterval = 10 * time.Second
+
+// DeploymentMismatchError is returned by VerifyDeployment when the endpoint
+// still serves another certificate once the timeout elapsed.
+type DeploymentMismatchError struct {
+	// Endpoint is the endpoint verified.
+	Endpoint string
+	// Served describes the certificate served, or the last error connecting.
+	Served string
+}
+
+func (e *DeploymentMismatchError) Error() string {
+	return fmt.Sprintf("%s does not serve the new certificate: %s", e.Endpoint, e.Served)
+}
+
+// VerifyDeployment connects to the endpoint, such as https://example.com:443
+// or example.com:443, until it serves the certificate or the timeout elapses,
+// to make sure the consumers picked up a renewed certificate. The server
+// name sent is the host of the endpoint. It returns a
+// *DeploymentMismatchError if the endpoint does not serve it in time.
+func (c *Cert) VerifyDeployment(endpoint string, timeout time.Duration) error {
+	addr := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		addr = u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Host, "443")
+		}
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	leaf, err := c.Leaf()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		served := compareServed(addr, host, leaf)
+		if served == "" {
+			return nil
+		}
+		if !time.Now().Add(deploymentPollInterval).Before(deadline) {
+			return &DeploymentMismatchError{Endpoint: endpoint, Served: served}
+		}
+		time.Sleep(deploymentPollInterval)
+	}
+}
+
+// compareServed connects to addr and returns an empty string if it serves the
+// leaf, or a description of what it serves otherwise.
+func compareServed(addr, serverName string, leaf *x509.Certificate) string {
+	dialer := &net.Dialer{Timeout: deploymentPollInterval}
+	// the certificate itself is compared, whether it is trusted does not matter
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		return err.Error()
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "no certificate"
+	}
+	if bytes.Equal(certs[0].Raw, leaf.Raw) {
+		return ""
+	}
+	return fmt.Sprintf("serial %s, valid until %s", certs[0].SerialNumber, certs[0].NotAfter.Format("2006-01-02"))
+}
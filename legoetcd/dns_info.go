@@ -0,0 +1,31 @@
+package legoetcd
+
+//go:generate go run gen_dns_registry.go
+
+// DNSProviderInfo describes a DNS provider accepted by ChallengeConfig.DNS and
+// the environment variables configuring it.
+type DNSProviderInfo struct {
+	// Name is the name of the provider, such as route53.
+	Name string `json:"name"`
+	// Description is the DNS service of the provider.
+	Description string `json:"description"`
+	// Env are the environment variables the provider requires.
+	Env []string `json:"env"`
+	// OptionalEnv are the environment variables the provider may use.
+	OptionalEnv []string `json:"optionalEnv,omitempty"`
+	// Notes explains the configuration beyond the variables, if any.
+	Notes string `json:"notes,omitempty"`
+}
+
+// DNSProviders returns the DNS providers available to this binary, sorted by
+// name: only those not depending on a third-party service in offline mode.
+func DNSProviders() []DNSProviderInfo {
+	var providers []DNSProviderInfo
+	for _, p := range dnsProviders {
+		if Offline && !offlineDNSProviders[p.Name] {
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
@@ -0,0 +1,19 @@
+//go:build !offline
+// +build !offline
+
+package legoetcd
+
+import (
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/providers/dns"
+)
+
+const offlineBuild = false
+
+// newDNSProvider returns the DNS provider named name.
+func newDNSProvider(name string) (acme.ChallengeProvider, error) {
+	if Offline && !offlineDNSProviders[name] {
+		return nil, ErrOffline
+	}
+	return dns.NewDNSChallengeProviderByName(name)
+}
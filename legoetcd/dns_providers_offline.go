@@ -0,0 +1,30 @@
+//go:build offline
+// +build offline
+
+package legoetcd
+
+import (
+	"github.com/xenolf/lego/acme"
+	"github.com/xenolf/lego/providers/dns/exec"
+	"github.com/xenolf/lego/providers/dns/pdns"
+	"github.com/xenolf/lego/providers/dns/rfc2136"
+)
+
+const offlineBuild = true
+
+// newDNSProvider returns the DNS provider named name, only the providers not
+// depending on a third-party service are built in.
+func newDNSProvider(name string) (acme.ChallengeProvider, error) {
+	switch name {
+	case "manual":
+		return acme.NewDNSProviderManual()
+	case "exec":
+		return exec.NewDNSProvider()
+	case "pdns":
+		return pdns.NewDNSProvider()
+	case "rfc2136":
+		return rfc2136.NewDNSProvider()
+	default:
+		return nil, ErrOffline
+	}
+}
@@ -0,0 +1,60 @@
+package legoetcd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+func TestDNSProviders(t *testing.T) {
+	offline := legoetcd.Offline
+	legoetcd.Offline = false
+	defer func() { legoetcd.Offline = offline }()
+
+	var names []string
+	for _, p := range legoetcd.DNSProviders() {
+		if p.Description == "" {
+			t.Errorf("want the DNS provider %s described", p.Name)
+		}
+		names = append(names, p.Name)
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("want the DNS providers sorted by name, got %v", names)
+	}
+}
+
+func TestDNSRegistryGenerated(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generating the registry builds gen_dns_registry.go")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not available")
+	}
+	dir, err := ioutil.TempDir("", "dns_registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "dns_registry.go")
+	if out, err := exec.Command(goTool, "run", "gen_dns_registry.go", "-output", output).CombinedOutput(); err != nil {
+		t.Fatalf("error generating the registry: %s\n%s", err, out)
+	}
+	want, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile("dns_registry.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("dns_registry.go is not up to date with the registry of lego, run go generate:\n%s", want)
+	}
+}
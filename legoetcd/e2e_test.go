@@ -0,0 +1,115 @@
+//go:build e2e
+// +build e2e
+
+package legoetcd_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/xenolf/lego/acme"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// The end-to-end tests run the obtain, renew and watch cycle against a local
+// Pebble and etcd, configured by the environment:
+//
+//	LEGO_ETCD_E2E_DIRECTORY  the directory of Pebble, https://localhost:14000/dir by default
+//	LEGO_ETCD_E2E_ENDPOINTS  the comma separated endpoints of etcd, http://127.0.0.1:2379 by default
+//	LEGO_ETCD_E2E_DOMAINS    the comma separated domains to obtain, example.com by default
+//	LEGO_ETCD_E2E_HTTP_ADDR  the interface:port Pebble validates the HTTP challenges on, :5002 by default
+//
+// Run them with go test -tags e2e ./legoetcd.
+
+// e2eWatchTimeout is how long the watcher may take to receive a certificate.
+const e2eWatchTimeout = time.Minute
+
+func e2eEnv(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+func TestE2E(t *testing.T) {
+	directory := e2eEnv("LEGO_ETCD_E2E_DIRECTORY", "https://localhost:14000/dir")
+	endpoints := strings.Split(e2eEnv("LEGO_ETCD_E2E_ENDPOINTS", "http://127.0.0.1:2379"), ",")
+	domains := strings.Split(e2eEnv("LEGO_ETCD_E2E_DOMAINS", "example.com"), ",")
+	httpAddr := e2eEnv("LEGO_ETCD_E2E_HTTP_ADDR", ":5002")
+
+	// Pebble serves its directory with a certificate of its own CA
+	legoetcd.ACMETransport().TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	etcdClient, err := client.New(client.Config{Endpoints: endpoints})
+	if err != nil {
+		t.Fatalf("error creating a new etcd client: %s", err)
+	}
+	store := legoetcd.NewV2Store(etcdClient)
+
+	// serve the challenges presented in etcd
+	l, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, legoetcd.HTTPChallengeHandler(store))
+
+	acmeClient, err := legoetcd.New(store, directory, "e2e@example.com", acme.EC256, legoetcd.ChallengeConfig{HTTPEtcd: true})
+	if err != nil {
+		t.Fatalf("error creating a new ACME client: %s", err)
+	}
+	if err := acmeClient.RegisterAccount(store, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// watch the certificate like a consumer
+	w := legoetcd.NewWatcher(store, legoetcd.NewKeysAPI(etcdClient), domains)
+	w.PollInterval = time.Second
+	w.Start()
+	defer w.Stop()
+
+	cert, failures := acmeClient.NewCert(domains, "", true)
+	for domain, err := range failures {
+		t.Fatalf("error obtaining the certificate of %s: %s", domain, err)
+	}
+	if err := cert.Save(store, true); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cert.Delete(store); err != nil {
+			t.Error(err)
+		}
+	}()
+	waitForCert(t, w, cert.Cert.Certificate)
+
+	if err := cert.Renew(acmeClient, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Save(store, true); err != nil {
+		t.Fatal(err)
+	}
+	waitForCert(t, w, cert.Cert.Certificate)
+}
+
+// waitForCert waits for the watcher to receive the certificate.
+func waitForCert(t *testing.T, w *legoetcd.Watcher, certificate []byte) {
+	timeout := time.After(e2eWatchTimeout)
+	for {
+		select {
+		case cert := <-w.Updates():
+			if bytes.Equal(cert.Cert.Certificate, certificate) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the watcher did not receive the certificate within %s", e2eWatchTimeout)
+		}
+	}
+}
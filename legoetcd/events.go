@@ -0,0 +1,276 @@
+package legoetcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
+	"golang.org/x/net/context"
+)
+
+const (
+	eventKey    = "/lego/events/%s/%s"
+	eventPrefix = "/lego/events/%s/"
+	eventsDir   = "/lego/events/"
+)
+
+// DefaultEventRetention is the number of events kept per certificate by
+// default.
+const DefaultEventRetention = 100
+
+// Event is an entry of the event log of a certificate.
+type Event struct {
+	// ID identifies the event, it is the UTC time of the event.
+	ID string `json:"-"`
+	// Type is what happened, one of the events of the notify package such
+	// as renewed, or EventAudit.
+	Type notify.Event `json:"type"`
+	// Operation is the operation audited by an EventAudit, such as save.
+	Operation string   `json:"operation,omitempty"`
+	Domains   []string `json:"domains"`
+	// NotAfter is the expiration of the new certificate, if any.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// Error is the reason of a failure.
+	Error string `json:"error,omitempty"`
+	// Host is the hostname of the instance the event happened on.
+	Host string `json:"host"`
+	// Actor is who performed the operation audited by an EventAudit.
+	Actor *Actor    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// EventLog records the events of the certificates in etcd, under
+// /lego/events/<domain>/<time>, so the operators can tell when a certificate
+// was last renewed and by which host from etcd alone. It is a notifier, the
+// events are recorded by adding it to the notifiers.
+type EventLog struct {
+	Store Store
+	// Retention is the number of events kept per certificate, the oldest are
+	// removed, DefaultEventRetention if it is zero.
+	Retention int
+}
+
+// Notify implements notify.Notifier
+func (l *EventLog) Notify(n *notify.Notification) error {
+	if len(n.Domains) == 0 {
+		return nil
+	}
+	e := &Event{
+		ID:      n.Time.UTC().Format(historyTimeFormat),
+		Type:    n.Event,
+		Domains: n.Domains,
+		Error:   n.Error,
+		Host:    n.Host,
+		Time:    n.Time,
+	}
+	if !n.NotAfter.IsZero() {
+		notAfter := n.NotAfter
+		e.NotAfter = &notAfter
+	}
+	jsonBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	retention := l.Retention
+	if retention == 0 {
+		retention = DefaultEventRetention
+	}
+	return recordEvent(l.Store, StorageName(n.Domains[0]), e.ID, jsonBytes, retention)
+}
+
+// recordEvent writes the event to the event log of name, and removes the
+// oldest events to keep at most retention.
+func recordEvent(s Store, name, id string, jsonBytes []byte, retention int) error {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	err := s.Set(ctx, fmt.Sprintf(eventKey, name, id), string(jsonBytes))
+	cancelFunc()
+	if err != nil {
+		return err
+	}
+	return pruneEvents(s, name, retention)
+}
+
+// Events returns the events of the certificate of the domains, the most
+// recent first.
+func Events(s Store, domains []string) ([]*Event, error) {
+	domain := StorageName(domains[0])
+	ids, err := eventIDs(s, domain)
+	if err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for i := len(ids) - 1; i >= 0; i-- {
+		e, err := loadEvent(s, fmt.Sprintf(eventKey, domain, ids[i]))
+		if err != nil {
+			if IsKeyNotFound(err) {
+				// pruned since it was listed
+				continue
+			}
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// eventIDs returns the IDs of the events of the domain, the oldest first.
+func eventIDs(s Store, domain string) ([]string, error) {
+	prefix := fmt.Sprintf(eventPrefix, domain)
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := s.Keys(ctx, prefix)
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, prefix))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func pruneEvents(s Store, domain string, retention int) error {
+	ids, err := eventIDs(s, domain)
+	if err != nil {
+		return err
+	}
+	for len(ids) > retention {
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		err := s.Delete(ctx, fmt.Sprintf(eventKey, domain, ids[0]))
+		cancelFunc()
+		if err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// WatchEvents streams the events of all the certificates recorded in etcd
+// from now on, until ctx is done and the channel is closed. The events are
+// watched in the etcd v2 keyspace with kapi, if it is not nil, and polled
+// every DefaultWatchPollInterval regardless, which is the only way to notice
+// the events recorded in the v3 keyspace.
+func WatchEvents(ctx context.Context, s Store, kapi KeyWatcher) <-chan *Event {
+	events := make(chan *Event)
+	go watchEvents(ctx, s, kapi, events)
+	return events
+}
+
+func watchEvents(ctx context.Context, s Store, kapi KeyWatcher, events chan<- *Event) {
+	defer close(events)
+	// only stream the events recorded from now on
+	seen, err := eventKeys(s)
+	if err != nil {
+		log.Printf("error listing the events: %s", err)
+	}
+	var index uint64
+	for {
+		// wait for the next change or the next poll
+		if kapi == nil {
+			select {
+			case <-time.After(DefaultWatchPollInterval):
+			case <-ctx.Done():
+				return
+			}
+		} else {
+			watchCtx, cancelFunc := context.WithTimeout(ctx, DefaultWatchPollInterval)
+			resp, err := kapi.Watcher(eventsDir, &client.WatcherOptions{AfterIndex: index, Recursive: true}).Next(watchCtx)
+			cancelFunc()
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			switch {
+			case err == nil:
+				index = resp.Node.ModifiedIndex
+			case err == context.DeadlineExceeded:
+			default:
+				if cerr, ok := err.(client.Error); ok && cerr.Code == client.ErrorCodeEventIndexCleared {
+					// start over from the current index, the listing catches up
+					index = cerr.Index
+					break
+				}
+				log.Printf("error watching the events: %s", err)
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		// send the events recorded since the last listing, in order
+		keys, err := eventKeys(s)
+		if err != nil {
+			log.Printf("error listing the events: %s", err)
+			continue
+		}
+		var recorded []string
+		for key := range keys {
+			if !seen[key] {
+				recorded = append(recorded, key)
+			}
+		}
+		sort.Sort(byEventID(recorded))
+		for _, key := range recorded {
+			e, err := loadEvent(s, key)
+			if err != nil {
+				if !IsKeyNotFound(err) {
+					log.Printf("error loading the event %s: %s", key, err)
+				}
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		seen = keys
+	}
+}
+
+// eventKeys returns the keys of the events of all the certificates.
+func eventKeys(s Store) (map[string]bool, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	keys, err := s.Keys(ctx, eventsDir)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set, nil
+}
+
+func loadEvent(s Store, key string) (*Event, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	e := &Event{ID: path.Base(key)}
+	if err := json.Unmarshal([]byte(value), e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// byEventID sorts the keys of the events chronologically, whatever their
+// certificate.
+type byEventID []string
+
+func (k byEventID) Len() int           { return len(k) }
+func (k byEventID) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k byEventID) Less(i, j int) bool { return path.Base(k[i]) < path.Base(k[j]) }
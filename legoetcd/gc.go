@@ -0,0 +1,162 @@
+package legoetcd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// certSuffixes are the suffixes of the keys of a certificate.
+var certSuffixes = []string{".cert", ".next.key", ".key", ".json", ".pem", ".p12", ".issuer", ".sums", ".sig", ".csr"}
+
+// GCResult describes a certificate or a key removed by GC.
+type GCResult struct {
+	// Name is the name the certificate is stored under.
+	Name string
+	// Reason is why it was removed.
+	Reason string
+	// Keys are the keys removed.
+	Keys []string
+}
+
+// GC removes the certificates which expired more than expiredFor ago, the
+// certificates without metadata, the keys left without a certificate and the
+// SAN index entries pointing to a missing certificate. Nothing is removed if
+// dryRun is true, the results tell what would have been.
+func GC(s Store, expiredFor time.Duration, dryRun bool) ([]*GCResult, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := s.Keys(ctx, certificatesPrefix)
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	// group the keys by certificate
+	byName := make(map[string][]string)
+	var names []string
+	for _, key := range keys {
+		if isLockKey(key) {
+			continue
+		}
+		name := strings.TrimPrefix(key, certificatesPrefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			// the history of the certificate
+			name = name[:i]
+		} else {
+			for _, suffix := range certSuffixes {
+				name = strings.TrimSuffix(name, suffix)
+			}
+		}
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], key)
+	}
+	var results []*GCResult
+	for _, name := range names {
+		result := gcCert(s, name, byName[name], expiredFor)
+		if result == nil {
+			continue
+		}
+		results = append(results, result)
+		if dryRun {
+			continue
+		}
+		err := deleteKeys(s, result.Keys)
+		audit(s, "gc: "+result.Reason, result.Name, nil, err)
+		if err != nil {
+			return results, err
+		}
+	}
+	index, err := gcIndex(s)
+	if err != nil {
+		return results, err
+	}
+	if index != nil {
+		results = append(results, index)
+		if !dryRun {
+			if err := deleteKeys(s, index.Keys); err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// gcCert returns what to remove of the certificate, if anything.
+func gcCert(s Store, name string, keys []string, expiredFor time.Duration) *GCResult {
+	has := make(map[string]bool)
+	for _, key := range keys {
+		has[key] = true
+	}
+	cert := &Cert{Domains: []string{name}}
+	if !has[cert.CertPath()] {
+		return &GCResult{Name: name, Reason: "no certificate", Keys: keys}
+	}
+	if !has[cert.MetaPath()] {
+		return &GCResult{Name: name, Reason: "no metadata", Keys: certKeysFirst(cert, keys)}
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	value, err := s.Get(ctx, cert.CertPath())
+	cancelFunc()
+	if err != nil {
+		return nil
+	}
+	cert.Cert.Certificate = []byte(value)
+	leaf, err := cert.Leaf()
+	if err != nil {
+		return &GCResult{Name: name, Reason: fmt.Sprintf("invalid certificate: %s", err), Keys: certKeysFirst(cert, keys)}
+	}
+	if expired := time.Now().Sub(leaf.NotAfter); expired > expiredFor {
+		return &GCResult{Name: name, Reason: fmt.Sprintf("expired on %s", leaf.NotAfter.Format("2006-01-02")), Keys: certKeysFirst(cert, keys)}
+	}
+	return nil
+}
+
+// certKeysFirst puts the certificate key, watched by the consumers, first.
+func certKeysFirst(cert *Cert, keys []string) []string {
+	sorted := []string{cert.CertPath()}
+	for _, key := range keys {
+		if key != cert.CertPath() {
+			sorted = append(sorted, key)
+		}
+	}
+	return sorted
+}
+
+// gcIndex returns the SAN index entries pointing to a missing certificate.
+func gcIndex(s Store) (*GCResult, error) {
+	prefix := fmt.Sprintf(sanIndexKey, "")
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	keys, err := s.Keys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, key := range keys {
+		stored, err := s.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		cert := &Cert{Domains: []string{stored}}
+		if _, err := s.Get(ctx, cert.CertPath()); IsKeyNotFound(err) {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	return &GCResult{Name: strings.TrimSuffix(prefix, "/"), Reason: "index entries of missing certificates", Keys: stale}, nil
+}
+
+func deleteKeys(s Store, keys []string) error {
+	for _, key := range keys {
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		err := s.Delete(ctx, key)
+		cancelFunc()
+		if err != nil && !IsKeyNotFound(err) {
+			return fmt.Errorf("error deleting %s: %s", key, err)
+		}
+	}
+	return nil
+}
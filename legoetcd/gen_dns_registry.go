@@ -0,0 +1,332 @@
+//go:build ignore
+// +build ignore
+
+// gen_dns_registry writes dns_registry.go, the DNS providers of the registry of
+// lego, dns.NewDNSChallengeProviderByName, along with the environment
+// variables their packages read. The descriptions, the notes and which of the
+// variables are optional cannot be read from lego, they are listed in
+// descriptions below. Run go generate after updating lego.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	registryPackage = "github.com/xenolf/lego/providers/dns"
+	registryFunc    = "NewDNSChallengeProviderByName"
+)
+
+// description is what is known of a provider beyond the variables its package
+// reads.
+type description struct {
+	description string
+	// sdkEnv are the variables read by the SDK the provider uses rather than
+	// by lego.
+	sdkEnv []string
+	// optional are the variables which may be left unset.
+	optional []string
+	notes    string
+}
+
+var descriptions = map[string]description{
+	"auroradns":    {description: "Aurora DNS by PCextreme", optional: []string{"AURORA_ENDPOINT"}},
+	"azure":        {description: "Azure DNS"},
+	"bluecat":      {description: "Bluecat Address Manager"},
+	"cloudflare":   {description: "Cloudflare"},
+	"cloudxns":     {description: "CloudXNS"},
+	"digitalocean": {description: "DigitalOcean"},
+	"dnsimple":     {description: "DNSimple", optional: []string{"DNSIMPLE_BASE_URL"}},
+	"dnsmadeeasy":  {description: "DNS Made Easy", optional: []string{"DNSMADEEASY_SANDBOX"}},
+	"dnspod":       {description: "DNSPod"},
+	"duckdns":      {description: "Duck DNS"},
+	"dyn":          {description: "Dyn Managed DNS"},
+	"exec": {
+		description: "External program",
+		notes:       "EXEC_PATH is run with present or cleanup, the FQDN and the value of the TXT record.",
+	},
+	"exoscale": {description: "Exoscale", optional: []string{"EXOSCALE_ENDPOINT"}},
+	"fastdns":  {description: "Akamai FastDNS"},
+	"gandi":    {description: "Gandi"},
+	"gandiv5":  {description: "Gandi LiveDNS"},
+	"gcloud": {
+		description: "Google Cloud DNS",
+		sdkEnv:      []string{"GOOGLE_APPLICATION_CREDENTIALS"},
+		optional:    []string{"GCE_SERVICE_ACCOUNT_FILE", "GOOGLE_APPLICATION_CREDENTIALS"},
+		notes:       "Without a service account file, the application default credentials are used.",
+	},
+	"glesys":  {description: "GleSYS"},
+	"godaddy": {description: "GoDaddy"},
+	"lightsail": {
+		description: "Amazon Lightsail",
+		sdkEnv:      []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "DNS_ZONE"},
+		optional:    []string{"AWS_SESSION_TOKEN", "DNS_ZONE"},
+		notes:       "The credentials may come from ~/.aws/credentials or the EC2 IAM role instead.",
+	},
+	"linode": {description: "Linode"},
+	"manual": {
+		description: "Manual",
+		notes:       "Prints the TXT record to create and waits for Enter, only usable from a terminal.",
+	},
+	"namecheap":  {description: "Namecheap"},
+	"namedotcom": {description: "Name.com", optional: []string{"NAMECOM_SERVER"}},
+	"ns1":        {description: "NS1"},
+	"otc":        {description: "Open Telekom Cloud", optional: []string{"OTC_IDENTITY_ENDPOINT"}},
+	"ovh":        {description: "OVH", notes: "OVH_ENDPOINT is ovh-eu or ovh-ca."},
+	"pdns":       {description: "PowerDNS"},
+	"rackspace":  {description: "Rackspace Cloud DNS"},
+	"rfc2136": {
+		description: "RFC 2136 dynamic updates",
+		optional:    []string{"RFC2136_TSIG_ALGORITHM", "RFC2136_TSIG_KEY", "RFC2136_TSIG_SECRET", "RFC2136_TIMEOUT"},
+		notes:       "The updates are signed if RFC2136_TSIG_KEY and RFC2136_TSIG_SECRET are set.",
+	},
+	"route53": {
+		description: "Amazon Route 53",
+		sdkEnv:      []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_REGION", "AWS_SESSION_TOKEN"},
+		optional:    []string{"AWS_SESSION_TOKEN", "AWS_HOSTED_ZONE_ID"},
+		notes:       "The credentials may come from ~/.aws/credentials or the EC2 IAM role instead. The hosted zone is found from the domain unless AWS_HOSTED_ZONE_ID is set.",
+	},
+	"vultr": {description: "Vultr"},
+}
+
+// provider is a DNS provider of the registry.
+type provider struct {
+	name string
+	// pkg is the import path of the package of the provider, empty if it is
+	// not one of the providers/dns packages.
+	pkg string
+}
+
+// byName sorts the providers by name.
+type byName []provider
+
+func (p byName) Len() int           { return len(p) }
+func (p byName) Less(i, j int) bool { return p[i].name < p[j].name }
+func (p byName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func main() {
+	output := flag.String("output", "dns_registry.go", "the file to write")
+	flag.Parse()
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// lego is found from the package, in its vendor directory if any
+	pkg, err := build.Import(registryPackage, wd, build.FindOnly)
+	if err != nil {
+		log.Fatal(err)
+	}
+	providers, err := registryProviders(filepath.Join(pkg.Dir, "dns_providers.go"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(providers) == 0 {
+		log.Fatalf("no DNS provider found in %s.%s", registryPackage, registryFunc)
+	}
+	sort.Sort(byName(providers))
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "// Code generated by gen_dns_registry.go; DO NOT EDIT.")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "package legoetcd")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "// dnsProviders are the DNS providers of the registry of lego, sorted by name.")
+	fmt.Fprintln(&b, "var dnsProviders = []DNSProviderInfo{")
+	found := make(map[string]bool)
+	for _, p := range providers {
+		d, ok := descriptions[p.name]
+		if !ok {
+			log.Fatalf("the DNS provider %s is not described, add it to the descriptions of gen_dns_registry.go", p.name)
+		}
+		found[p.name] = true
+		var env []string
+		if p.pkg != "" {
+			dir, err := build.Import(p.pkg, wd, build.FindOnly)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if env, err = readEnv(dir.Dir); err != nil {
+				log.Fatal(err)
+			}
+		}
+		required, optional := splitEnv(append(env, d.sdkEnv...), d.optional)
+		fmt.Fprintf(&b, "\t{Name: %q, Description: %q", p.name, d.description)
+		if len(required) > 0 {
+			fmt.Fprintf(&b, ", Env: %s", stringSlice(required))
+		}
+		if len(optional) > 0 {
+			fmt.Fprintf(&b, ", OptionalEnv: %s", stringSlice(optional))
+		}
+		if d.notes != "" {
+			fmt.Fprintf(&b, ", Notes: %q", d.notes)
+		}
+		fmt.Fprintln(&b, "},")
+	}
+	fmt.Fprintln(&b, "}")
+	for name := range descriptions {
+		if !found[name] {
+			log.Fatalf("the DNS provider %s is no longer in the registry of lego, remove it from the descriptions of gen_dns_registry.go", name)
+		}
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// registryProviders returns the providers of the cases of the switch of the
+// registry function in the file, with the package of the function each case
+// calls.
+func registryProviders(file string) ([]provider, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = importPath
+	}
+	var providers []provider
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != registryFunc {
+			continue
+		}
+		var err error
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			clause, ok := n.(*ast.CaseClause)
+			if !ok {
+				return true
+			}
+			pkg := calledPackage(clause, imports)
+			for _, expr := range clause.List {
+				lit, ok := expr.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				name, uerr := strconv.Unquote(lit.Value)
+				if uerr != nil {
+					err = uerr
+					return false
+				}
+				providers = append(providers, provider{name: name, pkg: pkg})
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return providers, nil
+}
+
+// calledPackage returns the import path of the providers/dns package whose
+// function the case calls, if any.
+func calledPackage(clause *ast.CaseClause, imports map[string]string) string {
+	var pkg string
+	for _, stmt := range clause.Body {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return pkg == ""
+			}
+			if x, ok := sel.X.(*ast.Ident); ok && strings.HasPrefix(imports[x.Name], registryPackage+"/") {
+				pkg = imports[x.Name]
+			}
+			return false
+		})
+	}
+	return pkg
+}
+
+// readEnv returns the environment variables read with os.Getenv or
+// os.LookupEnv by the package in the directory, in the order they are read.
+func readEnv(dir string) ([]string, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "Getenv" && sel.Sel.Name != "LookupEnv") {
+				return true
+			}
+			if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "os" {
+				return true
+			}
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				if name, err := strconv.Unquote(lit.Value); err == nil {
+					env = append(env, name)
+				}
+			}
+			return true
+		})
+	}
+	return env, nil
+}
+
+// splitEnv splits the variables, without duplicates, into the required and
+// the optional ones.
+func splitEnv(env, optional []string) (required, optionalEnv []string) {
+	isOptional := make(map[string]bool)
+	for _, name := range optional {
+		isOptional[name] = true
+	}
+	seen := make(map[string]bool)
+	for _, name := range append(env, optional...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if isOptional[name] {
+			optionalEnv = append(optionalEnv, name)
+		} else {
+			required = append(required, name)
+		}
+	}
+	return required, optionalEnv
+}
+
+func stringSlice(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
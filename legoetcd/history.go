@@ -0,0 +1,156 @@
+package legoetcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	historyKey    = "/lego/certificates/%s/history/%s"
+	historyPrefix = "/lego/certificates/%s/history/"
+	// historyTimeFormat sorts the history entries chronologically.
+	historyTimeFormat = "20060102T150405.000000000Z"
+)
+
+// DefaultHistoryRetention is the number of previous certificates kept in the
+// history by default.
+const DefaultHistoryRetention = 5
+
+// HistoryEntry is a previous version of a certificate, archived when it was
+// replaced.
+type HistoryEntry struct {
+	// ID identifies the entry, it is the UTC time the certificate was
+	// archived.
+	ID          string          `json:"-"`
+	Certificate []byte          `json:"certificate"`
+	PrivateKey  []byte          `json:"private_key,omitempty"`
+	Meta        json.RawMessage `json:"meta"`
+}
+
+// Archive copies the certificate currently stored in etcd to its history
+// before it is replaced, and removes the oldest entries to keep at most
+// retention entries. Nothing is archived if retention is not positive or if
+// no certificate is stored yet.
+func (c *Cert) Archive(s Store, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	entry := &HistoryEntry{ID: time.Now().UTC().Format(historyTimeFormat)}
+	cert, err := c.get(s, c.CertPath())
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	entry.Certificate = []byte(cert)
+	meta, err := c.get(s, c.MetaPath())
+	if err != nil {
+		return err
+	}
+	entry.Meta = json.RawMessage(meta)
+	// the certificates obtained with a CSR have no key
+	if key, err := c.get(s, c.KeyPath()); err == nil {
+		entry.PrivateKey = []byte(key)
+	} else if !IsKeyNotFound(err) {
+		return err
+	}
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := c.set(s, fmt.Sprintf(historyKey, StorageName(c.Domains[0]), entry.ID), string(jsonBytes)); err != nil {
+		return err
+	}
+	return c.pruneHistory(s, retention)
+}
+
+// History returns the archived versions of the certificate, the most recent
+// first.
+func (c *Cert) History(s Store) ([]*HistoryEntry, error) {
+	ids, err := c.historyIDs(s)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*HistoryEntry
+	for i := len(ids) - 1; i >= 0; i-- {
+		entry, err := c.historyEntry(s, ids[i])
+		if err != nil {
+			if IsKeyNotFound(err) {
+				// pruned since it was listed
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Rollback replaces the certificate stored in etcd by the archived version
+// id. The entry is kept in the history.
+func (c *Cert) Rollback(s Store, id string, pem bool) (err error) {
+	defer func() { audit(s, "rollback to "+id, StorageName(c.Domains[0]), c.Domains, err) }()
+	entry, err := c.historyEntry(s, id)
+	if err != nil {
+		return err
+	}
+	meta := certMeta{}
+	if err := json.Unmarshal(entry.Meta, &meta); err != nil {
+		return err
+	}
+	c.Cert = meta.CertificateResource
+	c.Cert.Certificate = entry.Certificate
+	c.Cert.PrivateKey = entry.PrivateKey
+	c.Timing = meta.Timing
+	return c.Save(s, pem && entry.PrivateKey != nil)
+}
+
+func (c *Cert) historyEntry(s Store, id string) (*HistoryEntry, error) {
+	value, err := c.get(s, fmt.Sprintf(historyKey, StorageName(c.Domains[0]), id))
+	if err != nil {
+		return nil, err
+	}
+	entry := &HistoryEntry{ID: id}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// historyIDs returns the IDs of the history entries, the oldest first.
+func (c *Cert) historyIDs(s Store) ([]string, error) {
+	prefix := fmt.Sprintf(historyPrefix, StorageName(c.Domains[0]))
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := s.Keys(ctx, prefix)
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, prefix))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (c *Cert) pruneHistory(s Store, retention int) error {
+	ids, err := c.historyIDs(s)
+	if err != nil {
+		return err
+	}
+	for len(ids) > retention {
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		err := s.Delete(ctx, fmt.Sprintf(historyKey, StorageName(c.Domains[0]), ids[0]))
+		cancelFunc()
+		if err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
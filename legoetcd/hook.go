@@ -0,0 +1,37 @@
+package legoetcd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RunHook runs the command with sh after the certificate was obtained or
+// renewed. The event is in LEGO_ETCD_EVENT, the domain the certificate is
+// stored under in LEGO_ETCD_DOMAIN and all its domains, separated by commas,
+// in LEGO_ETCD_DOMAINS. LEGO_ETCD_CERT_PATH, LEGO_ETCD_KEY_PATH,
+// LEGO_ETCD_META_PATH and LEGO_ETCD_PEM_PATH are the etcd keys of the
+// certificate and LEGO_ETCD_EXPIRY its expiration, formatted as RFC 3339.
+func RunHook(command string, event string, cert *Cert) error {
+	env := []string{
+		"LEGO_ETCD_EVENT=" + event,
+		"LEGO_ETCD_DOMAIN=" + cert.Domains[0],
+		"LEGO_ETCD_DOMAINS=" + strings.Join(cert.Domains, ","),
+		"LEGO_ETCD_CERT_PATH=" + cert.CertPath(),
+		"LEGO_ETCD_KEY_PATH=" + cert.KeyPath(),
+		"LEGO_ETCD_META_PATH=" + cert.MetaPath(),
+		"LEGO_ETCD_PEM_PATH=" + cert.PemPath(),
+	}
+	if expiry, err := cert.Expiration(); err == nil {
+		env = append(env, "LEGO_ETCD_EXPIRY="+expiry.Format(time.RFC3339))
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("the hook %q failed: %s: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package legoetcd
+
+import (
+	"net"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// UserAgent identifies lego-etcd to the CAs, it is sent after the user agent
+// of lego.
+const UserAgent = "lego-etcd"
+
+// HTTPTimeouts are the timeouts of the requests to the ACME server, a zero
+// timeout keeps the default of lego.
+type HTTPTimeouts struct {
+	// Dial is the timeout of establishing the connection, 30 seconds by
+	// default.
+	Dial time.Duration
+	// TLSHandshake is the timeout of the TLS handshake, 15 seconds by
+	// default.
+	TLSHandshake time.Duration
+	// ResponseHeader is the timeout of waiting for the response once the
+	// request is sent, 15 seconds by default.
+	ResponseHeader time.Duration
+}
+
+// ConfigureACMEHTTP applies the timeouts to the requests to the ACME server
+// and sends the user agent of lego-etcd, followed by extraUserAgent if set,
+// so the CAs can identify the client. Like the budgets, it must be called
+// before creating the clients.
+func ConfigureACMEHTTP(timeouts HTTPTimeouts, extraUserAgent string) {
+	acme.UserAgent = UserAgent
+	if extraUserAgent != "" {
+		acme.UserAgent += " " + extraUserAgent
+	}
+	transport := ACMETransport()
+	if transport == nil {
+		return
+	}
+	if timeouts.Dial > 0 {
+		transport.Dial = (&net.Dialer{Timeout: timeouts.Dial, KeepAlive: 30 * time.Second}).Dial
+	}
+	if timeouts.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	}
+	if timeouts.ResponseHeader > 0 {
+		transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+	}
+}
@@ -0,0 +1,63 @@
+package legoetcd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// httpChallengeKey holds the key authorization of an HTTP-01 challenge
+	// token, served by the HTTPChallengeHandler of any instance.
+	httpChallengeKey = "/lego/challenges/http/%s"
+	// httpChallengePath is the path the ACME server fetches the tokens from.
+	httpChallengePath = "/.well-known/acme-challenge/"
+)
+
+// etcdHTTPProvider presents the HTTP-01 challenges in etcd, for the
+// HTTPChallengeHandler mounted by the frontends of the domains, so the
+// challenges are solved whichever instance the ACME server reaches.
+type etcdHTTPProvider struct {
+	store Store
+}
+
+// Present implements acme.ChallengeProvider
+func (p *etcdHTTPProvider) Present(domain, token, keyAuth string) error {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return p.store.Set(ctx, fmt.Sprintf(httpChallengeKey, token), keyAuth)
+}
+
+// CleanUp implements acme.ChallengeProvider
+func (p *etcdHTTPProvider) CleanUp(domain, token, keyAuth string) error {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return p.store.Delete(ctx, fmt.Sprintf(httpChallengeKey, token))
+}
+
+// HTTPChallengeHandler serves the HTTP-01 challenges presented in etcd with
+// ChallengeConfig.HTTPEtcd under /.well-known/acme-challenge/.
+func HTTPChallengeHandler(s Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, httpChallengePath)
+		if !strings.HasPrefix(r.URL.Path, httpChallengePath) || token == "" || strings.Contains(token, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		defer cancelFunc()
+		keyAuth, err := s.Get(ctx, fmt.Sprintf(httpChallengeKey, token))
+		if err != nil {
+			if !IsKeyNotFound(err) {
+				log.Printf("error reading the challenge %s: %s", token, err)
+				http.Error(w, "error reading the challenge", http.StatusServiceUnavailable)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
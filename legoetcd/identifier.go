@@ -0,0 +1,92 @@
+package legoetcd
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrDNSChallengeForIP is returned when an IP address would be validated with
+// the DNS challenge, which RFC 8738 forbids.
+var ErrDNSChallengeForIP = errors.New("IP addresses can only be validated with the HTTP or the TLS challenge")
+
+// IsIP returns true if the identifier is an IP address rather than a domain.
+func IsIP(identifier string) bool {
+	return net.ParseIP(identifier) != nil
+}
+
+// NormalizeDomain returns the ASCII form of an internationalized domain,
+// lower-cased and punycode-encoded, so bücher.example and xn--bcher-kva.example
+// are the same certificate. The ASCII domains and the IP addresses are
+// returned as is.
+func NormalizeDomain(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	return idna.ToASCII(strings.ToLower(domain))
+}
+
+// NormalizeDomains normalizes the domains with NormalizeDomain.
+func NormalizeDomains(domains []string) ([]string, error) {
+	normalized := make([]string, len(domains))
+	for i, domain := range domains {
+		var err error
+		if normalized[i], err = NormalizeDomain(domain); err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// StorageName returns the name the certificate of the identifier is stored
+// under in etcd. The internationalized domains are stored under their ASCII
+// form, and the IP addresses under their canonical form with the colons of
+// the IPv6 addresses replaced by underscores, which never appear in a domain.
+func StorageName(identifier string) string {
+	ip := net.ParseIP(identifier)
+	if ip == nil {
+		if domain, err := NormalizeDomain(identifier); err == nil {
+			return domain
+		}
+		return identifier
+	}
+	return strings.Replace(ip.String(), ":", "_", -1)
+}
+
+// splitIdentifiers splits the identifiers into domains and IP addresses.
+func splitIdentifiers(identifiers []string) (domains []string, ips []net.IP) {
+	for _, identifier := range identifiers {
+		if ip := net.ParseIP(identifier); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			domains = append(domains, identifier)
+		}
+	}
+	return domains, ips
+}
+
+// checkIdentifiers makes sure the IP addresses are not validated with the DNS
+// challenge.
+func (c *Client) checkIdentifiers(identifiers []string) error {
+	if !c.dnsChallenge {
+		return nil
+	}
+	for _, identifier := range identifiers {
+		if IsIP(identifier) {
+			return ErrDNSChallengeForIP
+		}
+	}
+	return nil
+}
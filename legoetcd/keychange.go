@@ -0,0 +1,137 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xenolf/lego/acme"
+	"gopkg.in/square/go-jose.v1"
+)
+
+var (
+	// ErrKeyChangeUnsupported is returned by RotateKey when the ACME server
+	// does not support changing the key of an account.
+	ErrKeyChangeUnsupported = errors.New("the ACME server does not support account key changes")
+	// ErrAccountNotRegistered is returned when an operation requires the
+	// account to be registered.
+	ErrAccountNotRegistered = errors.New("the account is not registered")
+)
+
+// RotateKey generates a new key for the account and rolls the registration
+// over to it through the ACME key-change operation. The account must be saved
+// right after, and the ACME clients re-created with New as they still sign
+// their requests with the previous key.
+func (a *Account) RotateKey(c *Client) error {
+	if a.registration == nil || a.registration.URI == "" {
+		return ErrAccountNotRegistered
+	}
+	// find the key-change endpoint in the directory
+	var dir map[string]interface{}
+	if err := getJSON(c.directoryURL, &dir); err != nil {
+		return err
+	}
+	endpoint, ok := dir["key-change"].(string)
+	if !ok || endpoint == "" {
+		return ErrKeyChangeUnsupported
+	}
+	// generate the new key, of the same kind as the one of GenerateKey
+	newKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	// the inner request is signed by the new key, proving we hold it
+	inner, err := json.Marshal(struct {
+		Account string           `json:"account"`
+		NewKey  *jose.JsonWebKey `json:"newKey"`
+	}{
+		Account: a.registration.URI,
+		NewKey:  &jose.JsonWebKey{Key: &newKey.PublicKey},
+	})
+	if err != nil {
+		return err
+	}
+	innerJWS, err := signJWS(newKey, inner, nil)
+	if err != nil {
+		return err
+	}
+	// the outer request is signed by the current key, authorizing the change
+	var outer map[string]interface{}
+	if err := json.Unmarshal([]byte(innerJWS.FullSerialize()), &outer); err != nil {
+		return err
+	}
+	outer["resource"] = "key-change"
+	outerBytes, err := json.Marshal(outer)
+	if err != nil {
+		return err
+	}
+	outerJWS, err := signJWS(a.key, outerBytes, &directoryNonce{url: c.directoryURL})
+	if err != nil {
+		return err
+	}
+	resp, err := acme.HTTPClient.Post(endpoint, "application/jose+json", strings.NewReader(outerJWS.FullSerialize()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("the key change was refused with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	a.key = newKey
+	return nil
+}
+
+// signJWS signs the payload with the key, embedding the public key.
+func signJWS(key crypto.PrivateKey, payload []byte, nonces jose.NonceSource) (*jose.JsonWebSignature, error) {
+	var alg jose.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		alg = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = jose.ES256
+		case elliptic.P384():
+			alg = jose.ES384
+		default:
+			return nil, ErrUnknowKeyType
+		}
+	default:
+		return nil, ErrUnknowKeyType
+	}
+	signer, err := jose.NewSigner(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	if nonces != nil {
+		signer.SetNonceSource(nonces)
+	}
+	return signer.Sign(payload)
+}
+
+// directoryNonce gets a fresh nonce from the ACME server for each request.
+type directoryNonce struct {
+	url string
+}
+
+// Nonce implements jose.NonceSource
+func (n *directoryNonce) Nonce() (string, error) {
+	resp, err := acme.HTTPClient.Head(n.url)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("the ACME server did not return a nonce")
+	}
+	return nonce, nil
+}
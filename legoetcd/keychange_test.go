@@ -0,0 +1,125 @@
+package legoetcd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xenolf/lego/acme"
+	"gopkg.in/square/go-jose.v1"
+)
+
+func TestRotateKey(t *testing.T) {
+	const accountURI = "https://ca.example/acct/1"
+	tests := []struct {
+		name       string
+		registered bool
+		keyChange  bool
+		status     int
+		wantErr    error
+	}{
+		{"rotate the key", true, true, http.StatusOK, nil},
+		{"not registered", false, true, http.StatusOK, ErrAccountNotRegistered},
+		{"no key change", true, false, http.StatusOK, ErrKeyChangeUnsupported},
+		{"refused", true, true, http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var newKey *ecdsa.PublicKey
+			mux := http.NewServeMux()
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+			mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Replay-Nonce", "nonce")
+				dir := map[string]string{"new-cert": srv.URL + "/new-cert"}
+				if tt.keyChange {
+					dir["key-change"] = srv.URL + "/key-change"
+				}
+				json.NewEncoder(w).Encode(dir)
+			})
+			mux.HandleFunc("/key-change", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				// the outer request is signed by the current key
+				outer, err := jose.ParseSigned(string(body))
+				if err != nil {
+					t.Errorf("error parsing the key change: %s", err)
+					return
+				}
+				if nonce := outer.Signatures[0].Header.Nonce; nonce != "nonce" {
+					t.Errorf("want the nonce of the directory, got %q", nonce)
+				}
+				payload, err := outer.Verify(&key.PublicKey)
+				if err != nil {
+					t.Errorf("want the key change signed by the current key: %s", err)
+					return
+				}
+				// the inner request is signed by the new key
+				inner, err := jose.ParseSigned(string(payload))
+				if err != nil {
+					t.Errorf("error parsing the inner key change: %s", err)
+					return
+				}
+				signer := inner.Signatures[0].Header.JsonWebKey
+				if signer == nil {
+					t.Error("want the new key embedded in the inner key change")
+					return
+				}
+				innerPayload, err := inner.Verify(signer.Key)
+				if err != nil {
+					t.Errorf("error verifying the inner key change: %s", err)
+					return
+				}
+				var change struct {
+					Account string           `json:"account"`
+					NewKey  *jose.JsonWebKey `json:"newKey"`
+				}
+				if err := json.Unmarshal(innerPayload, &change); err != nil {
+					t.Errorf("error decoding the inner key change: %s", err)
+					return
+				}
+				if change.Account != accountURI {
+					t.Errorf("want the key of %s changed, got %s", accountURI, change.Account)
+				}
+				if change.NewKey == nil || !reflect.DeepEqual(change.NewKey.Key, signer.Key) {
+					t.Error("want the inner key change signed by the new key")
+					return
+				}
+				newKey, _ = change.NewKey.Key.(*ecdsa.PublicKey)
+				w.WriteHeader(tt.status)
+			})
+
+			a := &Account{key: key}
+			if tt.registered {
+				a.registration = &acme.RegistrationResource{URI: accountURI}
+			}
+			err = a.RotateKey(&Client{directoryURL: srv.URL + "/directory"})
+			if tt.status != http.StatusOK {
+				if err == nil {
+					t.Fatal("want the refusal of the CA")
+				}
+			} else if err != tt.wantErr {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				if a.key != key {
+					t.Error("want the key of the account kept")
+				}
+				return
+			}
+			rotated, ok := a.key.(*ecdsa.PrivateKey)
+			if !ok || newKey == nil || !reflect.DeepEqual(&rotated.PublicKey, newKey) {
+				t.Error("want the account to use the new key accepted by the CA")
+			}
+		})
+	}
+}
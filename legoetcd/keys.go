@@ -0,0 +1,46 @@
+package legoetcd
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/coreos/etcd/client"
+)
+
+// KeyGetter reads the keys of the etcd v2 keyspace.
+type KeyGetter interface {
+	Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error)
+}
+
+// KeySetter writes the keys of the etcd v2 keyspace.
+type KeySetter interface {
+	Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error)
+}
+
+// KeyDeleter removes the keys of the etcd v2 keyspace.
+type KeyDeleter interface {
+	Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error)
+}
+
+// KeyWatcher watches the keys of the etcd v2 keyspace.
+type KeyWatcher interface {
+	Watcher(key string, opts *client.WatcherOptions) client.Watcher
+}
+
+// KeysAPI is the part of the etcd v2 keys API the package uses, a
+// client.KeysAPI satisfies it. Accepting it rather than a client.Client lets
+// the tests inject a fake keyspace and other transports be adapted to etcd.
+type KeysAPI interface {
+	KeyGetter
+	KeySetter
+	KeyDeleter
+	KeyWatcher
+}
+
+// NewKeysAPI returns the keys API of the etcd v2 client, or nil if the client
+// is nil.
+func NewKeysAPI(c client.Client) KeysAPI {
+	if c == nil {
+		return nil
+	}
+	return client.NewKeysAPI(c)
+}
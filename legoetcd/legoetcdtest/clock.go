@@ -0,0 +1,166 @@
+package legoetcdtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// Clock is a legoetcd.Clock whose time only moves when Advance is called, the
+// timers and the tickers firing as the time passes their deadline.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	changed *sync.Cond
+}
+
+// waiter is a timer or a ticker of the Clock.
+type waiter struct {
+	clock    *Clock
+	deadline time.Time
+	// period is the interval of a ticker, zero for a timer.
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+// NewClock returns a Clock set at now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements legoetcd.Clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements legoetcd.Clock
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0, nil).c
+}
+
+// NewTicker implements legoetcd.Clock
+func (c *Clock) NewTicker(d time.Duration) legoetcd.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return ticker{c.add(d, d, nil)}
+}
+
+// AfterFunc implements legoetcd.Clock
+func (c *Clock) AfterFunc(d time.Duration, f func()) legoetcd.Timer {
+	return timer{c.add(d, 0, f)}
+}
+
+// Advance moves the time forward by d, firing the timers and the tickers
+// whose deadline passed in order. Like a time.Ticker, a ticker whose channel
+// is full drops the ticks.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		next := c.next(end)
+		if next == nil {
+			break
+		}
+		c.now = next.deadline
+		if next.period > 0 {
+			next.deadline = next.deadline.Add(next.period)
+		} else {
+			c.remove(next)
+		}
+		if next.f != nil {
+			go next.f()
+			continue
+		}
+		select {
+		case next.c <- c.now:
+		default:
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil blocks until n timers and tickers are waiting on the clock, so
+// the test advances the time once the code under test started waiting.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.changed.Wait()
+	}
+}
+
+func (c *Clock) add(d, period time.Duration, f func()) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{clock: c, deadline: c.now.Add(d), period: period, c: make(chan time.Time, 1), f: f}
+	if period == 0 && d <= 0 {
+		// fire right away, like the timers of the time package
+		if f != nil {
+			go f()
+		} else {
+			w.c <- c.now
+		}
+		return w
+	}
+	c.waiters = append(c.waiters, w)
+	c.changed.Broadcast()
+	return w
+}
+
+// next returns the waiter with the earliest deadline not after end, c.mu must
+// be held.
+func (c *Clock) next(end time.Time) *waiter {
+	var next *waiter
+	for _, w := range c.waiters {
+		if !w.deadline.After(end) && (next == nil || w.deadline.Before(next.deadline)) {
+			next = w
+		}
+	}
+	return next
+}
+
+// remove removes the waiter from the clock, it returns false if it was not
+// waiting. c.mu must be held.
+func (c *Clock) remove(w *waiter) bool {
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// stop removes the waiter from the clock, it returns false if it already
+// fired or was stopped.
+func (w *waiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+// ticker is the legoetcd.Ticker of a Clock.
+type ticker struct {
+	w *waiter
+}
+
+func (t ticker) C() <-chan time.Time { return t.w.c }
+
+func (t ticker) Stop() { t.w.stop() }
+
+// timer is the legoetcd.Timer of a Clock.
+type timer struct {
+	w *waiter
+}
+
+func (t timer) Stop() bool { return t.w.stop() }
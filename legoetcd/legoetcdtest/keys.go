@@ -0,0 +1,297 @@
+package legoetcdtest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// KeysAPI is an in-memory legoetcd.KeysAPI behaving like the etcd v2
+// keyspace: every write increments the index of the keyspace, the conditions
+// of the writes fail with the errors of etcd, the keys set with a TTL expire
+// and the watchers receive the changes after their index.
+type KeysAPI struct {
+	// Clock expires the keys set with a TTL, it defaults to
+	// legoetcd.DefaultClock.
+	Clock legoetcd.Clock
+
+	mu      sync.Mutex
+	index   uint64
+	nodes   map[string]*client.Node
+	events  []*client.Response
+	changed chan struct{}
+}
+
+// NewKeysAPI returns an empty KeysAPI.
+func NewKeysAPI() *KeysAPI {
+	return &KeysAPI{
+		nodes:   make(map[string]*client.Node),
+		changed: make(chan struct{}),
+	}
+}
+
+// Get implements legoetcd.KeyGetter
+func (k *KeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.expire()
+	if node, ok := k.nodes[key]; ok {
+		return &client.Response{Action: "get", Node: copyNode(node), Index: k.index}, nil
+	}
+	recursive := opts != nil && opts.Recursive
+	dir := k.dir(strings.TrimSuffix(key, "/"), recursive)
+	if dir == nil {
+		return nil, k.error(client.ErrorCodeKeyNotFound, key)
+	}
+	return &client.Response{Action: "get", Node: dir, Index: k.index}, nil
+}
+
+// Set implements legoetcd.KeySetter
+func (k *KeysAPI) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &client.SetOptions{}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.expire()
+	prev, exists := k.nodes[key]
+	action := "set"
+	switch {
+	case opts.PrevExist == client.PrevNoExist:
+		if exists {
+			return nil, k.error(client.ErrorCodeNodeExist, key)
+		}
+		action = "create"
+	case opts.PrevValue != "" || opts.PrevIndex != 0:
+		if !exists {
+			return nil, k.error(client.ErrorCodeKeyNotFound, key)
+		}
+		if (opts.PrevValue != "" && prev.Value != opts.PrevValue) || (opts.PrevIndex != 0 && prev.ModifiedIndex != opts.PrevIndex) {
+			return nil, k.error(client.ErrorCodeTestFailed, key)
+		}
+		action = "compareAndSwap"
+	case opts.PrevExist == client.PrevExist:
+		if !exists {
+			return nil, k.error(client.ErrorCodeKeyNotFound, key)
+		}
+		action = "update"
+	}
+	k.index++
+	node := &client.Node{Key: key, Value: value, CreatedIndex: k.index, ModifiedIndex: k.index}
+	if exists {
+		node.CreatedIndex = prev.CreatedIndex
+	}
+	if opts.TTL > 0 {
+		expiration := k.clock().Now().Add(opts.TTL)
+		node.Expiration = &expiration
+		node.TTL = int64(opts.TTL / time.Second)
+	}
+	k.nodes[key] = node
+	return k.record(action, node, prev), nil
+}
+
+// Delete implements legoetcd.KeyDeleter
+func (k *KeysAPI) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &client.DeleteOptions{}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.expire()
+	prev, exists := k.nodes[key]
+	if !exists {
+		if opts.Recursive && k.dir(key, false) != nil {
+			k.index++
+			for path := range k.nodes {
+				if strings.HasPrefix(path, key+"/") {
+					delete(k.nodes, path)
+				}
+			}
+			return k.record("delete", &client.Node{Key: key, Dir: true, ModifiedIndex: k.index}, nil), nil
+		}
+		return nil, k.error(client.ErrorCodeKeyNotFound, key)
+	}
+	action := "delete"
+	if opts.PrevValue != "" || opts.PrevIndex != 0 {
+		if (opts.PrevValue != "" && prev.Value != opts.PrevValue) || (opts.PrevIndex != 0 && prev.ModifiedIndex != opts.PrevIndex) {
+			return nil, k.error(client.ErrorCodeTestFailed, key)
+		}
+		action = "compareAndDelete"
+	}
+	k.index++
+	delete(k.nodes, key)
+	return k.record(action, &client.Node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: k.index}, prev), nil
+}
+
+// Watcher implements legoetcd.KeyWatcher
+func (k *KeysAPI) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	w := &watcher{k: k, key: key}
+	if opts != nil {
+		w.after, w.recursive = opts.AfterIndex, opts.Recursive
+	}
+	if w.after == 0 {
+		// like etcd, only the changes made from now on
+		k.mu.Lock()
+		w.after = k.index
+		k.mu.Unlock()
+	}
+	return w
+}
+
+// Index returns the current index of the keyspace.
+func (k *KeysAPI) Index() uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.index
+}
+
+// Dump returns a copy of all the keys which did not expire and their values,
+// to assert what was written.
+func (k *KeysAPI) Dump() map[string]string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.expire()
+	dump := make(map[string]string, len(k.nodes))
+	for key, node := range k.nodes {
+		dump[key] = node.Value
+	}
+	return dump
+}
+
+func (k *KeysAPI) clock() legoetcd.Clock {
+	if k.Clock != nil {
+		return k.Clock
+	}
+	return legoetcd.DefaultClock
+}
+
+// expire removes the keys whose TTL elapsed, k.mu must be held.
+func (k *KeysAPI) expire() {
+	now := k.clock().Now()
+	var expired []string
+	for key, node := range k.nodes {
+		if node.Expiration != nil && !now.Before(*node.Expiration) {
+			expired = append(expired, key)
+		}
+	}
+	sort.Strings(expired)
+	for _, key := range expired {
+		prev := k.nodes[key]
+		k.index++
+		delete(k.nodes, key)
+		k.record("expire", &client.Node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: k.index}, prev)
+	}
+}
+
+// dir returns the directory node of the keys under key, nil if there are
+// none, with the nodes of its sub-directories if recursive. k.mu must be held.
+func (k *KeysAPI) dir(key string, recursive bool) *client.Node {
+	var paths []string
+	for path := range k.nodes {
+		if strings.HasPrefix(path, key+"/") {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	dir := &client.Node{Key: key, Dir: true}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		rest := strings.TrimPrefix(path, key+"/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			child := key + "/" + rest[:i]
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			node := &client.Node{Key: child, Dir: true}
+			if recursive {
+				node = k.dir(child, true)
+			}
+			dir.Nodes = append(dir.Nodes, node)
+			continue
+		}
+		dir.Nodes = append(dir.Nodes, copyNode(k.nodes[path]))
+	}
+	return dir
+}
+
+// record appends the change to the history and wakes up the watchers, k.mu
+// must be held.
+func (k *KeysAPI) record(action string, node, prev *client.Node) *client.Response {
+	resp := &client.Response{Action: action, Node: copyNode(node), Index: k.index}
+	if prev != nil {
+		resp.PrevNode = copyNode(prev)
+	}
+	k.events = append(k.events, resp)
+	close(k.changed)
+	k.changed = make(chan struct{})
+	return resp
+}
+
+// error returns the error of etcd with the code, k.mu must be held.
+func (k *KeysAPI) error(code int, key string) error {
+	messages := map[int]string{
+		client.ErrorCodeKeyNotFound: "Key not found",
+		client.ErrorCodeTestFailed:  "Compare failed",
+		client.ErrorCodeNodeExist:   "Key already exists",
+	}
+	return client.Error{Code: code, Message: messages[code], Cause: key, Index: k.index}
+}
+
+func copyNode(node *client.Node) *client.Node {
+	c := *node
+	return &c
+}
+
+// watcher is the client.Watcher of a KeysAPI.
+type watcher struct {
+	k         *KeysAPI
+	key       string
+	recursive bool
+	after     uint64
+}
+
+// Next implements client.Watcher
+func (w *watcher) Next(ctx context.Context) (*client.Response, error) {
+	for {
+		w.k.mu.Lock()
+		w.k.expire()
+		for _, resp := range w.k.events {
+			if resp.Index <= w.after || !w.matches(resp.Node.Key) {
+				continue
+			}
+			w.after = resp.Index
+			w.k.mu.Unlock()
+			return resp, nil
+		}
+		changed := w.k.changed
+		w.k.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (w *watcher) matches(key string) bool {
+	return key == w.key || (w.recursive && strings.HasPrefix(key, strings.TrimSuffix(w.key, "/")+"/"))
+}
@@ -0,0 +1,126 @@
+package legoetcdtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// SeedAccount stores an account of email with a new key, as if it had been
+// created by a service, but not registered with the directory.
+func SeedAccount(s legoetcd.Store, email, directoryURL string) (*legoetcd.Account, error) {
+	acc := legoetcd.NewDirectoryAccount(email, directoryURL)
+	if err := acc.GenerateKey(); err != nil {
+		return nil, err
+	}
+	if err := acc.Save(s); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// CA is a throwaway certificate authority issuing the certificates of
+// SeedCert.
+type CA struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	// PEM is the PEM-encoded certificate of the CA, to trust it.
+	PEM []byte
+}
+
+// NewCA returns a new CA valid for a year.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "legoetcdtest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		Cert: cert,
+		Key:  key,
+		PEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// Issue returns a certificate for the domains, which may be IP addresses,
+// valid from now on for validFor, bundled with the certificate of the CA.
+func (ca *CA) Issue(domains []string, validFor time.Duration) (*legoetcd.Cert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domains[0]},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, domain := range domains {
+		if ip := net.ParseIP(domain); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, domain)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	cert := &legoetcd.Cert{Domains: domains}
+	cert.Cert.Domain = domains[0]
+	cert.Cert.Certificate = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), ca.PEM...)
+	cert.Cert.PrivateKey = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	return cert, nil
+}
+
+// SeedCert stores a certificate for the domains issued by a new CA, valid for
+// validFor, as if it had been obtained by a service. A validFor shorter than
+// the renewal threshold makes the services renew it.
+func SeedCert(s legoetcd.Store, domains []string, validFor time.Duration) (*legoetcd.Cert, error) {
+	ca, err := NewCA()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ca.Issue(domains, validFor)
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.Save(s, true); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
@@ -0,0 +1,137 @@
+// Package legoetcdtest provides an in-memory Store behaving like the etcd v3
+// keyspace, and helpers seeding it with accounts and certificates, for the
+// integration tests of lego-etcd and of its users which should not require a
+// running etcd cluster.
+package legoetcdtest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// Store is an in-memory legoetcd.Store. Like the etcd v3 keyspace, every
+// write increments a global revision, the keys record the revision they were
+// last modified at and the writes of SetAll and CompareAndSetAll are atomic.
+type Store struct {
+	mu       sync.Mutex
+	revision int64
+	values   map[string]string
+	revs     map[string]int64
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		values: make(map[string]string),
+		revs:   make(map[string]int64),
+	}
+}
+
+// Get implements legoetcd.Store
+func (s *Store) Get(ctx context.Context, key string) (string, error) {
+	value, _, err := s.GetRevision(ctx, key)
+	return value, err
+}
+
+// GetRevision implements legoetcd.Store
+func (s *Store) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return "", 0, legoetcd.ErrKeyNotFound
+	}
+	return value, s.revs[key], nil
+}
+
+// Set implements legoetcd.Store
+func (s *Store) Set(ctx context.Context, key, value string) error {
+	return s.SetAll(ctx, []legoetcd.KeyValue{{Key: key, Value: value}})
+}
+
+// Delete implements legoetcd.Store
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		s.revision++
+		delete(s.values, key)
+		delete(s.revs, key)
+	}
+	return nil
+}
+
+// Keys implements legoetcd.Store
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// SetAll implements legoetcd.Store
+func (s *Store) SetAll(ctx context.Context, kvs []legoetcd.KeyValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setAll(kvs)
+	return nil
+}
+
+// CompareAndSetAll implements legoetcd.Store
+func (s *Store) CompareAndSetAll(ctx context.Context, kvs []legoetcd.KeyValue, key string, rev int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the revision of a missing key compares equal to zero
+	if s.revs[key] != rev {
+		return 0, legoetcd.ErrConflict
+	}
+	s.setAll(kvs)
+	return s.revision, nil
+}
+
+// setAll writes the keys at the next revision, s.mu must be held.
+func (s *Store) setAll(kvs []legoetcd.KeyValue) {
+	s.revision++
+	for _, kv := range kvs {
+		s.values[kv.Key] = kv.Value
+		s.revs[kv.Key] = s.revision
+	}
+}
+
+// Dump returns a copy of all the keys and their values, to assert what was
+// written.
+func (s *Store) Dump() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dump := make(map[string]string, len(s.values))
+	for key, value := range s.values {
+		dump[key] = value
+	}
+	return dump
+}
@@ -0,0 +1,146 @@
+package legoetcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+// The log levels, the zero value is LogInfo.
+const (
+	LogDebug LogLevel = iota - 1
+	LogInfo
+	LogWarn
+	LogError
+)
+
+// The prefixes setting the level of a message logged with the standard
+// logger, they are stripped from the message. The messages starting with
+// "error " are errors and the messages logged by log.Fatal are never filtered
+// out, the other messages are LogInfo.
+const (
+	DebugPrefix   = "DEBUG: "
+	WarningPrefix = "WARNING: "
+	ErrorPrefix   = "ERROR: "
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel parses debug, info, warn or error.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return LogInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+}
+
+// ConfigureLogging makes the standard logger, used by the library, the
+// service and the commands, drop the messages below level and write the
+// others to the standard error as text or as JSON lines for the log
+// aggregators.
+func ConfigureLogging(level LogLevel, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	log.SetFlags(0)
+	log.SetOutput(&logWriter{out: os.Stderr, level: level, json: format == "json"})
+	return nil
+}
+
+// logWriter is the output of the standard logger configured by
+// ConfigureLogging, the logger calls Write once per message.
+type logWriter struct {
+	out   io.Writer
+	level LogLevel
+	json  bool
+}
+
+// logLine is a message written as JSON.
+type logLine struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	level, msg := levelOf(strings.TrimSuffix(string(p), "\n"))
+	fatal := loggedByFatal()
+	if fatal {
+		level = LogError
+	} else if level < w.level {
+		return len(p), nil
+	}
+	now := time.Now()
+	var err error
+	if w.json {
+		line := &logLine{Time: now.UTC(), Level: level.String(), Msg: msg}
+		if fatal {
+			line.Level = "fatal"
+		}
+		err = json.NewEncoder(w.out).Encode(line)
+	} else {
+		_, err = fmt.Fprintf(w.out, "%s %-5s %s\n", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// levelOf returns the level of the message and the message without its
+// level prefix.
+func levelOf(msg string) (LogLevel, string) {
+	switch {
+	case strings.HasPrefix(msg, DebugPrefix):
+		return LogDebug, strings.TrimPrefix(msg, DebugPrefix)
+	case strings.HasPrefix(msg, WarningPrefix):
+		return LogWarn, strings.TrimPrefix(msg, WarningPrefix)
+	case strings.HasPrefix(msg, ErrorPrefix):
+		return LogError, strings.TrimPrefix(msg, ErrorPrefix)
+	case strings.HasPrefix(msg, "error "):
+		return LogError, msg
+	default:
+		return LogInfo, msg
+	}
+}
+
+// loggedByFatal returns true if the message is logged by log.Fatal or
+// log.Panic, which must be shown whatever the level.
+func loggedByFatal() bool {
+	pcs := make([]uintptr, 10)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		for _, prefix := range []string{"log.Fatal", "log.Panic", "log.(*Logger).Fatal", "log.(*Logger).Panic"} {
+			if strings.HasPrefix(frame.Function, prefix) {
+				return true
+			}
+		}
+		if !more {
+			return false
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package legoetcd
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// CertInfo are the fields of the certificate stored in its metadata, so the
+// tooling reading etcd does not need to parse the PEM.
+type CertInfo struct {
+	// Serial is the hex-encoded serial number of the certificate.
+	Serial    string    `json:"serial,omitempty"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// Issuer is the common name of the issuer of the certificate.
+	Issuer string `json:"issuer,omitempty"`
+	// KeyType is the type of the key of the certificate, such as ec256.
+	KeyType string `json:"keyType,omitempty"`
+	// SANs are the DNS names and the IP addresses of the certificate.
+	SANs []string `json:"sans,omitempty"`
+	// IssuedAt is the time the certificate was obtained by lego-etcd, if
+	// known.
+	IssuedAt *time.Time `json:"issuedAt,omitempty"`
+	// WrittenBy is the Version of lego-etcd which stored the certificate.
+	WrittenBy string `json:"writtenBy,omitempty"`
+}
+
+// Info returns the fields of the certificate stored in its metadata.
+func (c *Cert) Info() (*CertInfo, error) {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	leaf := certs[0]
+	info := &CertInfo{
+		Serial:    fmt.Sprintf("%x", leaf.SerialNumber),
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		Issuer:    leaf.Issuer.CommonName,
+		KeyType:   publicKeyType(leaf.PublicKey),
+		SANs:      append([]string(nil), leaf.DNSNames...),
+		WrittenBy: Version,
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	if c.Timing != nil && !c.Timing.IssuedAt.IsZero() {
+		issuedAt := c.Timing.IssuedAt
+		info.IssuedAt = &issuedAt
+	}
+	return info, nil
+}
+
+// LoadCertInfo returns the fields of the certificate of the domains from its
+// metadata, without loading the certificate. The fields are empty if the
+// certificate was stored by a version of lego-etcd not recording them.
+func LoadCertInfo(s Store, domains []string) (*CertInfo, error) {
+	c := &Cert{Domains: domains}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	value, err := s.Get(ctx, c.MetaPath())
+	if err != nil {
+		return nil, err
+	}
+	var meta certMeta
+	if err := json.Unmarshal([]byte(value), &meta); err != nil {
+		return nil, err
+	}
+	return &meta.CertInfo, nil
+}
+
+// ScanCerts returns the fields of all the certificates stored in etcd, by the
+// name they are stored under. The fields are parsed from the certificates,
+// along with the issuance time and the version recorded in their metadata if
+// any, so the certificates stored by the previous versions are included. It
+// only reads from etcd.
+func ScanCerts(s Store) (map[string]*CertInfo, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := s.Keys(ctx, certificatesPrefix)
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]*CertInfo)
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, certificatesPrefix)
+		if strings.Contains(name, "/") || !strings.HasSuffix(name, ".cert") {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".cert")
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		value, err := s.Get(ctx, key)
+		cancelFunc()
+		if err != nil {
+			if IsKeyNotFound(err) {
+				// removed since it was listed
+				continue
+			}
+			return nil, err
+		}
+		c := &Cert{}
+		c.Cert.Certificate = []byte(value)
+		info, err := c.Info()
+		if err != nil {
+			log.Printf("skipping the invalid certificate %s: %s", name, err)
+			continue
+		}
+		info.WrittenBy = ""
+		if stored, err := LoadCertInfo(s, []string{name}); err == nil {
+			info.IssuedAt = stored.IssuedAt
+			info.WrittenBy = stored.WrittenBy
+		}
+		infos[name] = info
+	}
+	return infos, nil
+}
+
+// publicKeyType returns the name of the type of the public key, as accepted
+// by --key-type.
+func publicKeyType(pub interface{}) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa%d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ec%d", k.Curve.Params().BitSize)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,145 @@
+package legoetcd
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// ErrAlreadyImported is returned for the accounts and the certificates that
+// already exist in etcd and were not overwritten.
+var ErrAlreadyImported = errors.New("already exists in etcd")
+
+// ImportReport is the result of an import from lego's file storage, keyed by
+// email for the accounts and by domain for the certificates.
+type ImportReport struct {
+	Accounts     map[string]error
+	Certificates map[string]error
+}
+
+// ImportLegoDir imports the accounts registered with the ACME directory and
+// all the certificates of the data directory of the lego CLI (usually
+// ~/.lego). The accounts and the certificates already stored in etcd are
+// skipped, unless overwrite is true.
+func ImportLegoDir(s Store, legoDir, directoryURL string, overwrite bool) (*ImportReport, error) {
+	report := &ImportReport{
+		Accounts:     make(map[string]error),
+		Certificates: make(map[string]error),
+	}
+	// the accounts are stored by CA host, the same way as the lego CLI
+	u, err := url.Parse(directoryURL)
+	if err != nil {
+		return nil, err
+	}
+	accountsDir := filepath.Join(legoDir, "accounts", strings.Replace(u.Host, ":", "_", -1))
+	emails, err := ioutil.ReadDir(accountsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, fi := range emails {
+		if fi.IsDir() {
+			report.Accounts[fi.Name()] = importLegoAccount(s, filepath.Join(accountsDir, fi.Name()), fi.Name(), directoryURL, overwrite)
+		}
+	}
+	// import the certificates, described by their metadata
+	metas, err := filepath.Glob(filepath.Join(legoDir, "certificates", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, meta := range metas {
+		domain := strings.TrimSuffix(filepath.Base(meta), ".json")
+		report.Certificates[domain] = importLegoCert(s, meta, overwrite)
+	}
+	return report, nil
+}
+
+func importLegoAccount(s Store, accountDir, email, directoryURL string, overwrite bool) error {
+	acc := NewDirectoryAccount(email, directoryURL)
+	if !overwrite {
+		if err := acc.LoadRegistration(s); err == nil {
+			return ErrAlreadyImported
+		} else if !IsKeyNotFound(err) {
+			return err
+		}
+	}
+	// read the registration
+	accountJSON, err := ioutil.ReadFile(filepath.Join(accountDir, "account.json"))
+	if err != nil {
+		return err
+	}
+	var legoAccount struct {
+		Registration *acme.RegistrationResource `json:"registration"`
+	}
+	if err := json.Unmarshal(accountJSON, &legoAccount); err != nil {
+		return err
+	}
+	if legoAccount.Registration == nil {
+		return ErrAccountNotRegistered
+	}
+	// read the key
+	keyPEM, err := ioutil.ReadFile(filepath.Join(accountDir, "keys", email+".key"))
+	if err != nil {
+		return err
+	}
+	key, err := parsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		return fmt.Errorf("the key of the account is not an EC key: %s", ErrUnknowKeyType)
+	}
+	// the key is shared by the directories, do not replace another one
+	existing := NewAccount(email)
+	if err := existing.LoadKey(s); err == nil {
+		existingPEM, _ := existing.PEMKey()
+		importedPEM, _ := pemEncodePrivateKey(key)
+		if string(existingPEM) != string(importedPEM) && !overwrite {
+			return fmt.Errorf("a different key is stored for the account: %s", ErrAlreadyImported)
+		}
+	} else if !IsKeyNotFound(err) {
+		return err
+	}
+	acc.key = key
+	acc.registration = legoAccount.Registration
+	return acc.Save(s)
+}
+
+func importLegoCert(s Store, metaFile string, overwrite bool) error {
+	// read the metadata
+	metaJSON, err := ioutil.ReadFile(metaFile)
+	if err != nil {
+		return err
+	}
+	var res acme.CertificateResource
+	if err := json.Unmarshal(metaJSON, &res); err != nil {
+		return err
+	}
+	cert := &Cert{Domains: []string{res.Domain}}
+	if !overwrite {
+		if _, err := cert.get(s, cert.CertPath()); err == nil {
+			return ErrAlreadyImported
+		} else if !IsKeyNotFound(err) {
+			return err
+		}
+	}
+	// read the certificate and its key, missing for the certificates
+	// obtained with a CSR
+	base := strings.TrimSuffix(metaFile, ".json")
+	if res.Certificate, err = ioutil.ReadFile(base + ".crt"); err != nil {
+		return err
+	}
+	if res.PrivateKey, err = ioutil.ReadFile(base + ".key"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	cert.Cert = res
+	_, err = os.Stat(base + ".pem")
+	return cert.Save(s, err == nil && res.PrivateKey != nil)
+}
@@ -0,0 +1,138 @@
+// Package nomad writes the certificates to Nomad Variables, so the jobs
+// rendering them with template blocks are re-rendered and reloaded on
+// renewal.
+package nomad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAddress is the address of the local Nomad agent.
+const DefaultAddress = "http://127.0.0.1:4646"
+
+// Client writes Nomad Variables through the HTTP API.
+type Client struct {
+	// Address is the address of the Nomad API.
+	Address string
+	// Token is the ACL token, it needs the write capability on the variables.
+	Token string
+	// Namespace of the variables, the default namespace if empty.
+	Namespace string
+
+	httpClient *http.Client
+}
+
+// New returns a client of the Nomad API at address.
+func New(address, token, namespace string) *Client {
+	return &Client{
+		Address:    strings.TrimSuffix(address, "/"),
+		Token:      token,
+		Namespace:  namespace,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// PutVariable replaces the items of the variable at path, unless they are
+// already current. It returns whether the variable was written.
+func (c *Client) PutVariable(path string, items map[string]string) (bool, error) {
+	current, index, err := c.getVariable(path)
+	if err != nil {
+		return false, err
+	}
+	if current != nil && sameItems(current, items) {
+		return false, nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"Namespace": c.Namespace,
+		"Path":      path,
+		"Items":     items,
+	})
+	if err != nil {
+		return false, err
+	}
+	// check-and-set against the version we read, not to overwrite a change
+	// made in the meantime
+	resp, err := c.request("PUT", path, fmt.Sprintf("cas=%d", index), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return false, fmt.Errorf("the variable %s was modified concurrently", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("nomad answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return true, nil
+}
+
+// getVariable returns the items and the modify index of the variable, or nil
+// and zero if it does not exist.
+func (c *Client) getVariable(path string) (map[string]string, uint64, error) {
+	resp, err := c.request("GET", path, "", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("nomad answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	var v struct {
+		Items       map[string]string
+		ModifyIndex uint64
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, 0, err
+	}
+	return v.Items, v.ModifyIndex, nil
+}
+
+func (c *Client) request(method, path, query string, body io.Reader) (*http.Response, error) {
+	u := c.Address + "/v1/var/" + strings.TrimPrefix(path, "/")
+	params := url.Values{}
+	if c.Namespace != "" {
+		params.Set("namespace", c.Namespace)
+	}
+	if query != "" {
+		extra, _ := url.ParseQuery(query)
+		for k, v := range extra {
+			params[k] = v
+		}
+	}
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Nomad-Token", c.Token)
+	}
+	return c.httpClient.Do(req)
+}
+
+func sameItems(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+// Package notify sends notifications about the certificates to Slack or by
+// email, for the teams that do not run their own webhook receiver.
+package notify
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event is what happened to a certificate.
+type Event string
+
+const (
+	// EventObtained is sent when a new certificate was obtained.
+	EventObtained Event = "obtained"
+	// EventRenewed is sent when a certificate was renewed.
+	EventRenewed Event = "renewed"
+	// EventRenewalFailed is sent when a certificate could not be renewed.
+	EventRenewalFailed Event = "renewal_failed"
+	// EventRevoked is sent when a revoked certificate was replaced.
+	EventRevoked Event = "revoked"
+	// EventDeploymentFailed is sent when an endpoint did not serve a renewed
+	// certificate in time.
+	EventDeploymentFailed Event = "deployment_failed"
+)
+
+// DefaultTemplate is the message sent by the notifiers without a template.
+const DefaultTemplate = `[{{.Host}}] {{if eq .Event "obtained"}}obtained a certificate for {{join .Domains ", "}}, valid until {{.NotAfter.Format "2006-01-02"}}` +
+	`{{else if eq .Event "renewed"}}renewed the certificate for {{join .Domains ", "}}, valid until {{.NotAfter.Format "2006-01-02"}}` +
+	`{{else if eq .Event "revoked"}}replaced the revoked certificate for {{join .Domains ", "}}` +
+	`{{else if eq .Event "deployment_failed"}}the renewed certificate for {{join .Domains ", "}} is not deployed: {{.Error}}` +
+	`{{else}}failed to renew the certificate for {{join .Domains ", "}}: {{.Error}}{{end}}`
+
+// Notification describes the event of a certificate.
+type Notification struct {
+	Event   Event
+	Domains []string
+	// NotAfter is the expiration of the new certificate, if any.
+	NotAfter time.Time
+	// Error is the reason of a failure.
+	Error string
+	// Host is the hostname of the sender.
+	Host string
+	Time time.Time
+}
+
+// NewNotification returns a notification of the event sent by this host.
+func NewNotification(event Event, domains []string, notAfter time.Time, err error) *Notification {
+	host, _ := os.Hostname()
+	n := &Notification{
+		Event:    event,
+		Domains:  domains,
+		NotAfter: notAfter,
+		Host:     host,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	return n
+}
+
+// Notifier sends the notifications somewhere.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// NotifyAll sends the notification to all the notifiers, the failures are
+// only logged so a broken notifier never blocks the others.
+func NotifyAll(notifiers []Notifier, n *Notification) {
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("error sending the %s notification for %v: %s", n.Event, n.Domains, err)
+		}
+	}
+}
+
+// Render renders the notification with the text/template, or with the
+// DefaultTemplate if it is empty. The join function of the strings package is
+// available to the templates.
+func Render(tmpl string, n *Notification) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	t, err := template.New("notification").Funcs(template.FuncMap{"join": strings.Join}).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Slack posts the notifications to a Slack incoming webhook.
+type Slack struct {
+	// WebhookURL is the URL of the incoming webhook.
+	WebhookURL string
+	// Template is the text/template of the message, DefaultTemplate is used
+	// if empty.
+	Template string
+}
+
+var slackClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notify posts the notification to the webhook.
+func (s *Slack) Notify(n *Notification) error {
+	text, err := Render(s.Template, n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := slackClient.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error posting to slack: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("slack answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package legoetcd
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrOffline is returned when an external integration is used in offline
+// mode.
+var ErrOffline = errors.New("external integrations are disabled in offline mode")
+
+// Offline disables the integrations with third-party services, such as the
+// authorization webhooks, the public CAs and the cloud DNS providers, for
+// airgapped environments working with an internal ACME CA and etcd only. It
+// is always enabled in the binaries built with the offline tag, which do not
+// include the cloud DNS providers.
+var Offline = offlineBuild
+
+// offlineDNSProviders are the DNS providers not depending on a third-party
+// service.
+var offlineDNSProviders = map[string]bool{
+	"manual":  true,
+	"exec":    true,
+	"pdns":    true,
+	"rfc2136": true,
+}
+
+// publicCA returns whether the ACME directory belongs to a public CA.
+func publicCA(directoryURL string) bool {
+	u, err := url.Parse(directoryURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	return host == "letsencrypt.org" || strings.HasSuffix(host, ".letsencrypt.org")
+}
@@ -0,0 +1,377 @@
+package legoetcd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// pendingKey holds the authorizations created by an issuance in progress by
+// an account, so the next attempt cleans them up if the issuance never
+// completed because the process crashed or lost its lock.
+const pendingKey = "/lego/pending/%s/%s"
+
+// pendingIssuance is the value of the pending key.
+type pendingIssuance struct {
+	// Account is the URI of the account which created the authorizations,
+	// only it may deactivate them.
+	Account        string    `json:"account"`
+	Domains        []string  `json:"domains"`
+	Authorizations []string  `json:"authorizations"`
+	Started        time.Time `json:"started"`
+
+	store Store
+	key   string
+	// recordKeys are the keys of the issuance in pendingByAuthz.
+	recordKeys []string
+}
+
+var (
+	// pendingMu protects pendingByAuthz.
+	pendingMu sync.Mutex
+	// pendingByAuthz are the issuances in progress by account key and
+	// domain, see authzRecordKey, which the authorizations created by the
+	// ACME clients are recorded in. Several clients, with different accounts
+	// or not, may issue certificates for the same domain at once.
+	pendingByAuthz = make(map[string][]*pendingIssuance)
+	// recorderOnce installs the authzRecorder once.
+	recorderOnce sync.Once
+)
+
+// authzRecorder records the authorizations created by lego, which does not
+// expose them, into the pending issuances of their account and domain, and
+// times the requests of the phases of the issuances, see phaseTimer.
+type authzRecorder struct {
+	base http.RoundTripper
+}
+
+// installAuthzRecorder wraps the transport of the ACME client with an
+// authzRecorder.
+func installAuthzRecorder() {
+	recorderOnce.Do(func() {
+		base := acme.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		acme.HTTPClient.Transport = &authzRecorder{base: base}
+	})
+}
+
+// ACMETransport returns the transport of the requests to the ACME server, to
+// configure it.
+func ACMETransport() *http.Transport {
+	transport := acme.HTTPClient.Transport
+	if recorder, ok := transport.(*authzRecorder); ok {
+		transport = recorder.base
+	}
+	t, _ := transport.(*http.Transport)
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *authzRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, req := readACMERequest(req)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	recordPhase(r, time.Since(start))
+	if err != nil || r.resource != "new-authz" || resp.StatusCode != http.StatusCreated {
+		return resp, err
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		recordAuthz(authzRecordKey(r.accountKey, r.domain), location)
+	}
+	return resp, nil
+}
+
+// authzRecordKey returns the key of the issuances in pendingByAuthz, the
+// public key of their account and the domain of the authorization.
+func authzRecordKey(accountKey, domain string) string {
+	return accountKey + " " + strings.ToLower(domain)
+}
+
+// accountKeyID returns the publicKeyID of the key of the account of the
+// client, or an empty string without one.
+func (c *Client) accountKeyID() string {
+	if c.Account == nil {
+		return ""
+	}
+	signer, ok := c.Account.key.(crypto.Signer)
+	if !ok {
+		return ""
+	}
+	return publicKeyID(signer.Public())
+}
+
+// publicKeyID identifies a public key of an account by its parameters, it
+// returns an empty string for an unknown key type.
+func publicKeyID(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("EC %s %x %x", k.Curve.Params().Name, k.X, k.Y)
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %x %x", k.N, big.NewInt(int64(k.E)))
+	}
+	return ""
+}
+
+// jwkID identifies the public key of a JWK like publicKeyID.
+func jwkID(jwk map[string]string) string {
+	param := func(name string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk[name], "="))
+		return new(big.Int).SetBytes(b)
+	}
+	switch jwk["kty"] {
+	case "EC":
+		return fmt.Sprintf("EC %s %x %x", jwk["crv"], param("x"), param("y"))
+	case "RSA":
+		return fmt.Sprintf("RSA %x %x", param("n"), param("e"))
+	}
+	return ""
+}
+
+// acmeRequest is what the authzRecorder reads of a request to the ACME server.
+type acmeRequest struct {
+	// accountKey identifies the key of the account signing the request, see
+	// publicKeyID.
+	accountKey string
+	// resource is the ACME v1 resource of the request, new-authz or new-cert
+	// for instance.
+	resource string
+	// domain is the identifier of a new-authz request.
+	domain string
+}
+
+// readACMERequest reads the account and the resource of the request if it is
+// signed by an account, along with a copy of the request to send in place of
+// it as its body was read.
+func readACMERequest(req *http.Request) (acmeRequest, *http.Request) {
+	var r acmeRequest
+	if req.Method != "POST" || req.Body == nil {
+		return r, req
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	clone := new(http.Request)
+	*clone = *req
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return r, clone
+	}
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return r, clone
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jws.Payload, "="))
+	if err != nil {
+		return r, clone
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jws.Protected, "="))
+	if err != nil {
+		return r, clone
+	}
+	// the ACME v1 requests embed the key of the account in the header
+	var header struct {
+		JWK map[string]string `json:"jwk"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return r, clone
+	}
+	accountKey := jwkID(header.JWK)
+	if accountKey == "" {
+		return r, clone
+	}
+	var resource struct {
+		Resource   string `json:"resource"`
+		Identifier struct {
+			Value string `json:"value"`
+		} `json:"identifier"`
+	}
+	if err := json.Unmarshal(payload, &resource); err != nil {
+		return r, clone
+	}
+	r.accountKey = accountKey
+	r.resource = resource.Resource
+	r.domain = resource.Identifier.Value
+	return r, clone
+}
+
+// recordAuthz records the authorization in the pending issuances of its
+// account and domain, if any.
+func recordAuthz(recordKey, url string) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for _, p := range pendingByAuthz[recordKey] {
+		p.Authorizations = append(p.Authorizations, url)
+		if err := p.save(); err != nil {
+			log.Printf("error recording the authorization %s of %v: %s", url, p.Domains, err)
+		}
+	}
+}
+
+// beginPending cleans up the authorizations left by a previous issuance of the
+// domains which did not complete, and starts recording those of this one.
+func (c *Client) beginPending(domains []string) *pendingIssuance {
+	if c.store == nil || len(domains) == 0 {
+		return nil
+	}
+	installAuthzRecorder()
+	var account string
+	if reg := c.Account.GetRegistration(); reg != nil {
+		account = reg.URI
+	}
+	sum := sha256.Sum256([]byte(account))
+	key := fmt.Sprintf(pendingKey, hex.EncodeToString(sum[:]), issuanceID(domains))
+	c.cleanupPending(key)
+	p := &pendingIssuance{
+		Account: account,
+		Domains: domains,
+		Started: time.Now(),
+		store:   c.store,
+		key:     key,
+	}
+	if err := p.save(); err != nil {
+		log.Printf("error recording the issuance of %v: %s", domains, err)
+	}
+	accountKey := c.accountKeyID()
+	if accountKey == "" {
+		return p
+	}
+	pendingMu.Lock()
+	for _, domain := range domains {
+		recordKey := authzRecordKey(accountKey, domain)
+		pendingByAuthz[recordKey] = append(pendingByAuthz[recordKey], p)
+		p.recordKeys = append(p.recordKeys, recordKey)
+	}
+	pendingMu.Unlock()
+	return p
+}
+
+// end stops recording the authorizations of the issuance. Once it completed,
+// its record is removed as its authorizations are valid, otherwise it is kept
+// for the next attempt to clean them up.
+func (p *pendingIssuance) end(completed bool) {
+	if p == nil {
+		return
+	}
+	pendingMu.Lock()
+	for _, recordKey := range p.recordKeys {
+		var others []*pendingIssuance
+		for _, other := range pendingByAuthz[recordKey] {
+			if other != p {
+				others = append(others, other)
+			}
+		}
+		if len(others) == 0 {
+			delete(pendingByAuthz, recordKey)
+		} else {
+			pendingByAuthz[recordKey] = others
+		}
+	}
+	pendingMu.Unlock()
+	if !completed {
+		return
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	if err := p.store.Delete(ctx, p.key); err != nil {
+		log.Printf("error removing the record of the issuance of %v: %s", p.Domains, err)
+	}
+}
+
+func (p *pendingIssuance) save() error {
+	value, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	return p.store.Set(ctx, p.key, string(value))
+}
+
+// cleanupPending deactivates the authorizations of the previous issuance
+// still pending. The valid ones are kept, the CA reuses them for the
+// issuance resuming it.
+func (c *Client) cleanupPending(key string) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	value, err := c.store.Get(ctx, key)
+	cancelFunc()
+	if err != nil {
+		if !IsKeyNotFound(err) {
+			log.Printf("error loading the previous issuance: %s", err)
+		}
+		return
+	}
+	var previous pendingIssuance
+	if err := json.Unmarshal([]byte(value), &previous); err != nil {
+		log.Printf("error parsing the previous issuance: %s", err)
+		return
+	}
+	reg := c.Account.GetRegistration()
+	if reg == nil || reg.URI != previous.Account {
+		log.Printf("the previous issuance of %v was made by another account, leaving its authorizations", previous.Domains)
+		return
+	}
+	log.Printf("the issuance of %v started at %s did not complete, cleaning up its %d authorization(s)", previous.Domains, previous.Started, len(previous.Authorizations))
+	for _, url := range previous.Authorizations {
+		var authz struct {
+			Status string `json:"status"`
+		}
+		if err := getJSON(url, &authz); err != nil {
+			log.Printf("error checking the authorization %s: %s", url, err)
+			continue
+		}
+		if authz.Status != "pending" {
+			continue
+		}
+		if err := c.deactivateAuthz(url); err != nil {
+			log.Printf("error deactivating the authorization %s: %s", url, err)
+		}
+	}
+}
+
+// deactivateAuthz deactivates the authorization so it does not count against
+// the limit of pending authorizations of the account.
+func (c *Client) deactivateAuthz(url string) error {
+	payload, err := json.Marshal(struct {
+		Resource string `json:"resource"`
+		Status   string `json:"status"`
+	}{
+		Resource: "authz",
+		Status:   "deactivated",
+	})
+	if err != nil {
+		return err
+	}
+	jws, err := signJWS(c.Account.key, payload, &directoryNonce{url: c.directoryURL})
+	if err != nil {
+		return err
+	}
+	resp, err := acme.HTTPClient.Post(url, "application/jose+json", strings.NewReader(jws.FullSerialize()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("the deactivation was refused with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package legoetcd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/net/context"
+	"gopkg.in/square/go-jose.v1"
+)
+
+// mapStore is a minimal in-memory Store, the legoetcdtest package cannot be
+// imported by the tests of the package.
+type mapStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newMapStore() *mapStore { return &mapStore{values: make(map[string]string)} }
+
+func (s *mapStore) Get(ctx context.Context, key string) (string, error) {
+	value, _, err := s.GetRevision(ctx, key)
+	return value, err
+}
+
+func (s *mapStore) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return "", 0, ErrKeyNotFound
+	}
+	return value, 1, nil
+}
+
+func (s *mapStore) Set(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func (s *mapStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *mapStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *mapStore) SetAll(ctx context.Context, kvs []KeyValue) error {
+	for _, kv := range kvs {
+		s.Set(ctx, kv.Key, kv.Value)
+	}
+	return nil
+}
+
+func (s *mapStore) CompareAndSetAll(ctx context.Context, kvs []KeyValue, key string, rev int64) (int64, error) {
+	return 1, s.SetAll(ctx, kvs)
+}
+
+// newAuthzBody returns the body of the new-authz request of lego for the
+// domain, signed by the key.
+func newAuthzBody(t *testing.T, key *ecdsa.PrivateKey, domain string) string {
+	return signedBody(t, key, map[string]interface{}{
+		"resource":   "new-authz",
+		"identifier": map[string]string{"type": "dns", "value": domain},
+	})
+}
+
+// signedBody returns the body of an ACME v1 request of the payload, signed by
+// the key.
+func signedBody(t *testing.T, key *ecdsa.PrivateKey, payload map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	param := func(b []byte) string {
+		// the coordinates are padded to the size of the curve
+		padded := make([]byte, 32)
+		copy(padded[32-len(b):], b)
+		return base64.RawURLEncoding.EncodeToString(padded)
+	}
+	protected := map[string]interface{}{
+		"alg": "ES256",
+		"jwk": map[string]string{"kty": "EC", "crv": "P-256", "x": param(key.X.Bytes()), "y": param(key.Y.Bytes())},
+	}
+	body, err := json.Marshal(map[string]string{"protected": encode(protected), "payload": encode(payload), "signature": "c2ln"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestPendingRecordsTheAuthorizationsOfTheirAccount(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		authzs int
+	)
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authzs++
+		w.Header().Set("Location", fmt.Sprintf("http://%s/authz/%d", r.Host, authzs))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ca.Close()
+
+	store := newMapStore()
+	domains := []string{"example.com", "www.example.com"}
+	var (
+		keys    []*ecdsa.PrivateKey
+		pending []*pendingIssuance
+	)
+	for i := 0; i < 2; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Client{
+			store:   store,
+			Account: &Account{key: key, registration: &acme.RegistrationResource{URI: fmt.Sprintf("https://ca.example/acct/%d", i)}},
+		}
+		keys = append(keys, key)
+		pending = append(pending, c.beginPending(domains))
+	}
+	if pending[0].key == pending[1].key {
+		t.Fatalf("want the issuances of the accounts recorded apart, both are at %s", pending[0].key)
+	}
+
+	// the first account creates an authorization for a domain of both
+	resp, err := acme.HTTPClient.Post(ca.URL+"/new-authz", "application/jose+json", strings.NewReader(newAuthzBody(t, keys[0], "www.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for i, want := range []int{1, 0} {
+		value, err := store.Get(context.Background(), pending[i].key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recorded pendingIssuance
+		if err := json.Unmarshal([]byte(value), &recorded); err != nil {
+			t.Fatal(err)
+		}
+		if len(recorded.Authorizations) != want {
+			t.Errorf("account %d: want %d authorization(s) recorded, got %v", i, want, recorded.Authorizations)
+		}
+	}
+
+	// a completed issuance removes its record, a failed one keeps it
+	pending[0].end(true)
+	pending[1].end(false)
+	if _, err := store.Get(context.Background(), pending[0].key); err != ErrKeyNotFound {
+		t.Errorf("want the record of the completed issuance removed, got %v", err)
+	}
+	if _, err := store.Get(context.Background(), pending[1].key); err != nil {
+		t.Errorf("want the record of the failed issuance kept, got %v", err)
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if len(pendingByAuthz) != 0 {
+		t.Errorf("want the issuances no longer recording, got %v", pendingByAuthz)
+	}
+}
+
+func TestPendingCleansUpThePreviousIssuance(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		deactivated []string
+	)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]string{"/authz/1": "pending", "/authz/2": "valid", "/authz/3": "pending"}
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/directory":
+			w.Header().Set("Replay-Nonce", "nonce")
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(map[string]string{"status": statuses[r.URL.Path]})
+		case r.Method == "POST":
+			body, _ := ioutil.ReadAll(r.Body)
+			jws, err := jose.ParseSigned(string(body))
+			if err != nil {
+				t.Errorf("error parsing the deactivation: %s", err)
+				return
+			}
+			payload, err := jws.Verify(&key.PublicKey)
+			if err != nil {
+				t.Errorf("want the deactivation signed by the account: %s", err)
+				return
+			}
+			var authz struct {
+				Resource string `json:"resource"`
+				Status   string `json:"status"`
+			}
+			if err := json.Unmarshal(payload, &authz); err != nil || authz.Resource != "authz" || authz.Status != "deactivated" {
+				t.Errorf("want the authorization deactivated, got %s", payload)
+			}
+			mu.Lock()
+			deactivated = append(deactivated, r.URL.Path)
+			mu.Unlock()
+		}
+	}))
+	defer ca.Close()
+
+	tests := []struct {
+		name    string
+		account string
+		want    []string
+	}{
+		{"same account", "https://ca.example/acct/1", []string{"/authz/1", "/authz/3"}},
+		{"another account", "https://ca.example/acct/2", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			deactivated = nil
+			mu.Unlock()
+			store := newMapStore()
+			c := &Client{
+				store:        store,
+				directoryURL: ca.URL + "/directory",
+				Account:      &Account{key: key, registration: &acme.RegistrationResource{URI: "https://ca.example/acct/1"}},
+			}
+			domains := []string{"example.com"}
+			// the previous issuance did not complete
+			previous := c.beginPending(domains)
+			previous.Account = tt.account
+			for _, path := range []string{"/authz/1", "/authz/2", "/authz/3"} {
+				previous.Authorizations = append(previous.Authorizations, ca.URL+path)
+			}
+			if err := previous.save(); err != nil {
+				t.Fatal(err)
+			}
+			previous.end(false)
+
+			p := c.beginPending(domains)
+			defer p.end(true)
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(deactivated, tt.want) {
+				t.Errorf("want the authorizations %v deactivated, got %v", tt.want, deactivated)
+			}
+			// the record of the previous issuance is replaced
+			value, err := store.Get(context.Background(), p.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var recorded pendingIssuance
+			if err := json.Unmarshal([]byte(value), &recorded); err != nil {
+				t.Fatal(err)
+			}
+			if len(recorded.Authorizations) != 0 {
+				t.Errorf("want the authorizations of the previous issuance forgotten, got %v", recorded.Authorizations)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"unicode/utf16"
+)
+
+// pkcs12Iterations is the iteration count of the key derivations.
+const pkcs12Iterations = 2048
+
+var (
+	oidData                       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidRSAEncryption              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECPublicKey                = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidP256                       = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384                       = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// EncodePKCS12 encodes the private key and the certificates, the leaf first,
+// as a PKCS#12 bundle protected by the password, for the Java and the Windows
+// consumers. The key is encrypted with 3DES and the bundle is authenticated
+// with HMAC-SHA1, which all the PKCS#12 implementations support.
+func EncodePKCS12(key crypto.PrivateKey, certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+	bmpPassword := bmpString(password)
+	localKeyID := sha1.Sum(certs[0].Raw)
+	keyIDAttr, err := localKeyIDAttribute(localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificates, the leaf paired with the key
+	var certBags []safeBag
+	for i, cert := range certs {
+		bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		sb := safeBag{ID: oidCertBag, Value: asn1.RawValue{FullBytes: explicit0(bag)}}
+		if i == 0 {
+			sb.Attributes = []pkcs12Attribute{keyIDAttr}
+		}
+		certBags = append(certBags, sb)
+	}
+
+	// the encrypted key
+	keyDER, err := marshalPKCS8(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encrypted, err := pbeEncrypt(keyDER, bmpPassword, salt)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidPKCS8ShroudedKeyBag, Value: asn1.RawValue{FullBytes: explicit0(keyBag)}, Attributes: []pkcs12Attribute{keyIDAttr}}}
+
+	// the authenticated safe holds the two safe contents as data
+	var authSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		contents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := dataContentInfo(contents)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, ci)
+	}
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	pfxAuthSafe, err := dataContentInfo(authSafeDER)
+	if err != nil {
+		return nil, err
+	}
+
+	// authenticate the bundle
+	macSalt := make([]byte, 8)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pbkdf(bmpPassword, macSalt, 3, pkcs12Iterations, 20))
+	mac.Write(authSafeDER)
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: pfxAuthSafe,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// PKCS12 returns the certificate, its issuers and its private key as a
+// PKCS#12 bundle protected by the password.
+func (c *Cert) PKCS12(password string) ([]byte, error) {
+	if c.Cert.PrivateKey == nil {
+		return nil, ErrNoPKCS12ForCSR
+	}
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePEMPrivateKey(c.Cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePKCS12(key, certs, password)
+}
+
+// dataContentInfo wraps the contents in a data content info.
+func dataContentInfo(contents []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(contents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: asn1.RawValue{FullBytes: explicit0(octets)}}, nil
+}
+
+// explicit0 wraps the DER in an explicit context-specific tag 0.
+func explicit0(der []byte) []byte {
+	wrapped, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der})
+	return wrapped
+}
+
+func localKeyIDAttribute(id []byte) (pkcs12Attribute, error) {
+	octets, err := asn1.Marshal(id)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{ID: oidLocalKeyID, Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: octets}}, nil
+}
+
+// marshalPKCS8 encodes an RSA or EC private key in PKCS#8.
+func marshalPKCS8(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return asn1.Marshal(pkcs8{
+			Algo:       pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+			PrivateKey: x509.MarshalPKCS1PrivateKey(k),
+		})
+	case *ecdsa.PrivateKey:
+		var curve asn1.ObjectIdentifier
+		switch k.Curve {
+		case elliptic.P256():
+			curve = oidP256
+		case elliptic.P384():
+			curve = oidP384
+		default:
+			return nil, ErrUnknowKeyType
+		}
+		params, err := asn1.Marshal(curve)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8{
+			Algo:       pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey, Parameters: asn1.RawValue{FullBytes: params}},
+			PrivateKey: ecKey,
+		})
+	default:
+		return nil, ErrUnknowKeyType
+	}
+}
+
+// pbeEncrypt encrypts with pbeWithSHAAnd3-KeyTripleDES-CBC.
+func pbeEncrypt(data, password, salt []byte) ([]byte, error) {
+	block, err := des.NewTripleDESCipher(pbkdf(password, salt, 1, pkcs12Iterations, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := pbkdf(password, salt, 2, pkcs12Iterations, block.BlockSize())
+	// PKCS#7 padding
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	padded := make([]byte, len(data), len(data)+padding)
+	copy(padded, data)
+	for i := 0; i < padding; i++ {
+		padded = append(padded, byte(padding))
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return padded, nil
+}
+
+// pbkdf derives size bytes of key material for the purpose id (1 for keys, 2
+// for IVs and 3 for MAC keys) as described in RFC 7292 appendix B.2, with
+// SHA-1.
+func pbkdf(password, salt []byte, id byte, iterations, size int) []byte {
+	const u, v = sha1.Size, 64
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		filled := make([]byte, v*((len(b)+v-1)/v))
+		for i := range filled {
+			filled[i] = b[i%len(b)]
+		}
+		return filled
+	}
+	i := append(fill(salt), fill(password)...)
+	var out []byte
+	one := big.NewInt(1)
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+		// Ij = (Ij + B + 1) mod 2^(v*8)
+		b := new(big.Int).SetBytes(fill(a)[:v])
+		b.Add(b, one)
+		for j := 0; j < len(i); j += v {
+			ij := new(big.Int).SetBytes(i[j : j+v])
+			ij.Add(ij, b)
+			bytes := ij.Bytes()
+			if len(bytes) > v {
+				bytes = bytes[len(bytes)-v:]
+			}
+			block := i[j : j+v]
+			for k := range block {
+				block[k] = 0
+			}
+			copy(block[v-len(bytes):], bytes)
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes the password in UTF-16 big endian with a terminating
+// null, as PKCS#12 expects.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return append(b, 0, 0)
+}
@@ -0,0 +1,73 @@
+package legoetcd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// HostPolicy restricts the domains the certificates may be issued for. A
+// pattern is either an exact domain or a wildcard such as *.example.com, which
+// matches the subdomains of example.com at any depth but not example.com
+// itself.
+type HostPolicy struct {
+	// Allow are the patterns of the allowed domains, all the domains are
+	// allowed when empty.
+	Allow []string
+	// Deny are the patterns of the denied domains, they take precedence over
+	// Allow.
+	Deny []string
+}
+
+// HostNotAllowedError is returned when a certificate is requested for a
+// domain rejected by the host policy.
+type HostNotAllowedError struct {
+	// Domain is the rejected domain.
+	Domain string
+}
+
+func (e *HostNotAllowedError) Error() string {
+	return fmt.Sprintf("the domain %q is not allowed by the host policy", e.Domain)
+}
+
+// Check returns a *HostNotAllowedError for the first domain rejected by the
+// policy. A nil policy allows all the domains.
+func (p *HostPolicy) Check(domains []string) error {
+	if p == nil {
+		return nil
+	}
+	for _, domain := range domains {
+		if matchHosts(p.Deny, domain) || (len(p.Allow) > 0 && !matchHosts(p.Allow, domain)) {
+			return &HostNotAllowedError{Domain: domain}
+		}
+	}
+	return nil
+}
+
+func matchHosts(patterns []string, domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(domain, pattern[1:]) {
+				return true
+			}
+		} else if pattern == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// csrDomains returns the domains requested by the CSR.
+func csrDomains(csr *x509.CertificateRequest) []string {
+	var domains []string
+	if csr.Subject.CommonName != "" {
+		domains = append(domains, csr.Subject.CommonName)
+	}
+	domains = append(domains, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		domains = append(domains, ip.String())
+	}
+	return domains
+}
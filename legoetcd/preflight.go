@@ -0,0 +1,213 @@
+package legoetcd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// preflightKey is the key written by CheckStore.
+	preflightKey = "/lego/preflight/%s"
+	// preflightTimeout bounds the network checks.
+	preflightTimeout = 10 * time.Second
+)
+
+// nonce returns a random hex token for the preflight checks.
+func nonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CheckStore checks that the store is reachable and writable by writing,
+// reading back and removing a throwaway key.
+func CheckStore(s Store) error {
+	token, err := nonce()
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf(preflightKey, token)
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	if err := s.Set(ctx, key, token); err != nil {
+		return fmt.Errorf("error writing %s: %s", key, err)
+	}
+	defer s.Delete(ctx, key)
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", key, err)
+	}
+	if value != token {
+		return fmt.Errorf("read %q back from %s instead of %q", value, key, token)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		return fmt.Errorf("error removing %s: %s", key, err)
+	}
+	return nil
+}
+
+// CheckDirectory checks that the ACME directory is reachable and looks like
+// one.
+func CheckDirectory(directoryURL string) error {
+	httpClient := &http.Client{Timeout: preflightTimeout}
+	resp, err := httpClient.Get(directoryURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the directory answered with %s", resp.Status)
+	}
+	var directory map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&directory); err != nil {
+		return fmt.Errorf("the directory is not valid JSON: %s", err)
+	}
+	if directory["new-reg"] == nil && directory["newAccount"] == nil {
+		return fmt.Errorf("%s is not an ACME directory", directoryURL)
+	}
+	return nil
+}
+
+// CheckDNSProvider checks the credentials of the DNS provider of the
+// challenge config by creating and removing a throwaway TXT record for the
+// domain, through the alias if there is one.
+func CheckDNSProvider(cc ChallengeConfig, domain string) error {
+	provider, err := newDNSProvider(cc.DNS)
+	if err != nil {
+		return fmt.Errorf("error setting up the DNS provider %q: %s", cc.DNS, err)
+	}
+	if cc.DNSAlias != "" || cc.DNSFollowCNAME {
+		provider = &aliasProvider{ChallengeProvider: provider, alias: cc.DNSAlias}
+	}
+	token, err := nonce()
+	if err != nil {
+		return err
+	}
+	if err := provider.Present(domain, token, token); err != nil {
+		return fmt.Errorf("error creating the TXT record: %s", err)
+	}
+	if err := provider.CleanUp(domain, token, token); err != nil {
+		return fmt.Errorf("error removing the TXT record: %s", err)
+	}
+	return nil
+}
+
+// CheckHTTPChallenge checks that the domain reaches the HTTP challenges, by
+// writing a token to the webroot of the challenge config, or by serving it on
+// its HTTP address, and fetching it from http://domain/.well-known/acme-challenge.
+func CheckHTTPChallenge(cc ChallengeConfig, domain string) error {
+	token, err := nonce()
+	if err != nil {
+		return err
+	}
+	path := "/.well-known/acme-challenge/" + token
+	if cc.WebRoot != "" {
+		filename := filepath.Join(cc.WebRoot, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, []byte(token), 0644); err != nil {
+			return err
+		}
+		defer os.Remove(filename)
+	} else {
+		addr := cc.HTTPAddr
+		if addr == "" {
+			addr = ":80"
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %s", addr, err)
+		}
+		defer l.Close()
+		mux := http.NewServeMux()
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(token))
+		})
+		go http.Serve(l, mux)
+	}
+	httpClient := &http.Client{Timeout: preflightTimeout}
+	resp, err := httpClient.Get("http://" + domain + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != token {
+		return fmt.Errorf("http://%s%s answered with %s instead of the token", domain, path, resp.Status)
+	}
+	return nil
+}
+
+// CheckTLSChallenge checks that the domain reaches the TLS address of the
+// challenge config, by listening on it and connecting to the domain on the
+// same port.
+func CheckTLSChallenge(cc ChallengeConfig, domain string) error {
+	addr := cc.TLSAddr
+	if addr == "" {
+		addr = ":443"
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ErrAddressInvalid
+	}
+	token, err := nonce()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", addr, err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(preflightTimeout))
+			buf := make([]byte, len(token))
+			n, _ := io.ReadFull(conn, buf)
+			conn.Close()
+			select {
+			case received <- string(buf[:n]):
+			default:
+			}
+		}
+	}()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(domain, port), preflightTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(token)); err != nil {
+		return err
+	}
+	timeout := time.After(preflightTimeout)
+	for {
+		select {
+		case got := <-received:
+			if got == token {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("%s:%s is not reaching %s", domain, port, addr)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package legoetcd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	rateLimitDomainKey = "/lego/ratelimits/domains/%s"
+	rateLimitSetKey    = "/lego/ratelimits/sets/%s"
+	// rateLimitAttempts is the number of attempts to record an issuance when
+	// the counters are modified concurrently.
+	rateLimitAttempts = 5
+)
+
+// RateLimitError is returned when an issuance would exceed a rate limit of the
+// CA.
+type RateLimitError struct {
+	// Limit describes the limit which would be exceeded.
+	Limit string
+	// RetryAfter is the time the issuance is allowed again.
+	RetryAfter time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("the rate limit of %s would be exceeded, retry after %s", e.Limit, e.RetryAfter.Format(time.RFC3339))
+}
+
+// RateLimiter counts the issuances of all the clients sharing the etcd
+// cluster, and refuses the issuances which would exceed the rate limits of
+// the CA. The attempts are counted, not only the certificates issued.
+type RateLimiter struct {
+	// Store is where the issuances are counted.
+	Store Store
+	// CertificatesPerDomain is the maximum number of certificates per
+	// registered domain in Window, the renewals are counted but not limited.
+	CertificatesPerDomain int
+	// DuplicatesPerSet is the maximum number of certificates for the exact
+	// same set of domains in Window.
+	DuplicatesPerSet int
+	// Window is the sliding window of the limits.
+	Window time.Duration
+}
+
+// NewRateLimiter returns a RateLimiter enforcing the limits of Let's Encrypt:
+// 50 certificates per registered domain and 5 duplicate certificates per week.
+func NewRateLimiter(s Store) *RateLimiter {
+	return &RateLimiter{
+		Store:                 s,
+		CertificatesPerDomain: 50,
+		DuplicatesPerSet:      5,
+		Window:                7 * 24 * time.Hour,
+	}
+}
+
+// Reserve records an issuance for the domains, or returns a *RateLimitError if
+// it would exceed a limit.
+func (r *RateLimiter) Reserve(domains []string, renewal bool) error {
+	var err error
+	for i := 0; i < rateLimitAttempts; i++ {
+		if err = r.reserve(domains, renewal); err != ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *RateLimiter) reserve(domains []string, renewal bool) error {
+	now := time.Now()
+	var counters []*rateCounter
+	// the exact set of domains
+	set, err := r.load(fmt.Sprintf(rateLimitSetKey, domainSetID(domains)), now)
+	if err != nil {
+		return err
+	}
+	if r.DuplicatesPerSet > 0 && len(set.times) >= r.DuplicatesPerSet {
+		return &RateLimitError{
+			Limit:      fmt.Sprintf("%d duplicate certificates for %v", r.DuplicatesPerSet, domains),
+			RetryAfter: set.times[0].Add(r.Window),
+		}
+	}
+	counters = append(counters, set)
+	// the registered domains
+	for _, registered := range registeredDomains(domains) {
+		counter, err := r.load(fmt.Sprintf(rateLimitDomainKey, registered), now)
+		if err != nil {
+			return err
+		}
+		if !renewal && r.CertificatesPerDomain > 0 && len(counter.times) >= r.CertificatesPerDomain {
+			return &RateLimitError{
+				Limit:      fmt.Sprintf("%d certificates for %s", r.CertificatesPerDomain, registered),
+				RetryAfter: counter.times[0].Add(r.Window),
+			}
+		}
+		counters = append(counters, counter)
+	}
+	// record the issuance
+	for _, counter := range counters {
+		counter.times = append(counter.times, now)
+		if err := r.save(counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateCounter is the time of the issuances counted by a key, within the
+// window and in chronological order.
+type rateCounter struct {
+	key   string
+	rev   int64
+	times []time.Time
+}
+
+func (r *RateLimiter) load(key string, now time.Time) (*rateCounter, error) {
+	counter := &rateCounter{key: key}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	value, rev, err := r.Store.GetRevision(ctx, key)
+	cancelFunc()
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return counter, nil
+		}
+		return nil, err
+	}
+	var times []time.Time
+	if err := json.Unmarshal([]byte(value), &times); err != nil {
+		return nil, err
+	}
+	counter.rev = rev
+	// forget the issuances out of the window
+	for _, t := range times {
+		if now.Sub(t) < r.Window {
+			counter.times = append(counter.times, t)
+		}
+	}
+	return counter, nil
+}
+
+func (r *RateLimiter) save(counter *rateCounter) error {
+	jsonBytes, err := json.Marshal(counter.times)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	_, err = r.Store.CompareAndSetAll(ctx, []KeyValue{{Key: counter.key, Value: string(jsonBytes)}}, counter.key, counter.rev)
+	return err
+}
+
+// domainSetID identifies a set of domains regardless of their order.
+func domainSetID(domains []string) string {
+	sorted := make([]string, len(domains))
+	for i, domain := range domains {
+		sorted[i] = strings.ToLower(domain)
+	}
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// registeredDomains returns the distinct registered domains of the domains,
+// the domains without one are returned as is.
+func registeredDomains(domains []string) []string {
+	seen := make(map[string]bool)
+	var registered []string
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(strings.ToLower(domain), "*.")
+		if etld1, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+			domain = etld1
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			registered = append(registered, domain)
+		}
+	}
+	return registered
+}
@@ -0,0 +1,105 @@
+package legoetcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// UpdateContact replaces the contact URLs of the registration, such as
+// mailto:admin@example.com, with the ACME server. The account must be saved
+// right after.
+func (a *Account) UpdateContact(c *Client, contact []string) error {
+	if a.registration == nil || a.registration.URI == "" {
+		return ErrAccountNotRegistered
+	}
+	return a.postRegistration(c, map[string]interface{}{
+		"contact":   contact,
+		"agreement": a.registration.Body.Agreement,
+	})
+}
+
+// Deactivate deactivates the registration with the ACME server, which then
+// refuses any request of the account for good, including revoking its
+// certificates. The registration should be removed from etcd with Remove
+// right after.
+func (a *Account) Deactivate(c *Client) error {
+	if a.registration == nil || a.registration.URI == "" {
+		return ErrAccountNotRegistered
+	}
+	return a.postRegistration(c, map[string]interface{}{"status": "deactivated"})
+}
+
+// Remove deletes the registration of the account from etcd, and its key
+// unless the account is still registered with other directories. The caller
+// is responsible to ensure no race conditions by grabbing a lock before
+// calling Remove().
+func (a *Account) Remove(s Store) (err error) {
+	defer func() { audit(s, "remove account", auditAccounts, []string{a.email}, err) }()
+	paths := []string{a.registrationPath()}
+	if a.directory != "" {
+		// the registration may have been loaded from the legacy location
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		legacy, err := s.Get(ctx, fmt.Sprintf(registrationKey, a.email))
+		cancelFunc()
+		if err == nil && a.sameCA(legacy) {
+			paths = append(paths, fmt.Sprintf(registrationKey, a.email))
+		}
+	}
+	for _, path := range paths {
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		err := s.Delete(ctx, path)
+		cancelFunc()
+		if err != nil && !IsKeyNotFound(err) {
+			return err
+		}
+	}
+	// the key is shared with the registrations of the other directories
+	accounts, err := ListAccounts(s)
+	if err != nil {
+		return err
+	}
+	for _, info := range accounts {
+		if info.Email == a.email && (info.Legacy || len(info.Directories) > 0) {
+			return nil
+		}
+	}
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	if err := s.Delete(ctx, fmt.Sprintf(cryptoKey, a.email)); err != nil && !IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// postRegistration posts the update to the registration, signed with the key
+// of the account, and records the registration returned by the ACME server.
+func (a *Account) postRegistration(c *Client, update map[string]interface{}) error {
+	update["resource"] = "reg"
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	jws, err := signJWS(a.key, payload, &directoryNonce{url: c.directoryURL})
+	if err != nil {
+		return err
+	}
+	resp, err := acme.HTTPClient.Post(a.registration.URI, "application/jose+json", strings.NewReader(jws.FullSerialize()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("the registration update was refused with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var body acme.Registration
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	a.registration.Body = body
+	return nil
+}
@@ -0,0 +1,100 @@
+package legoetcd
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// TemplateData is the certificate as seen by the templates rendering it to
+// files.
+type TemplateData struct {
+	Domains []string
+	// Certificate is the certificate as stored, bundled with its issuers
+	// unless it was obtained with --no-bundle.
+	Certificate string
+	// Leaf is the certificate alone and Chain its issuers.
+	Leaf  string
+	Chain string
+	// PrivateKey is empty for the certificates obtained with a CSR.
+	PrivateKey string
+	NotAfter   time.Time
+	// Resource is the metadata of the certificate.
+	Resource acme.CertificateResource
+	// Timing is the timing of the last issuance, if known.
+	Timing *IssuanceTiming
+}
+
+// NewTemplateData returns the data rendering the certificate.
+func NewTemplateData(c *Cert) (*TemplateData, error) {
+	data := &TemplateData{
+		Domains:     c.Domains,
+		Certificate: string(c.Cert.Certificate),
+		PrivateKey:  string(c.Cert.PrivateKey),
+		Resource:    c.Cert,
+		Timing:      c.Timing,
+	}
+	leaf, err := c.Leaf()
+	if err != nil {
+		return nil, err
+	}
+	data.NotAfter = leaf.NotAfter
+	// split the bundle into the certificate and its chain
+	var chain bytes.Buffer
+	rest := c.Cert.Certificate
+	for {
+		var p *pem.Block
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			break
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		if data.Leaf == "" {
+			data.Leaf = string(pem.EncodeToMemory(p))
+		} else {
+			chain.Write(pem.EncodeToMemory(p))
+		}
+	}
+	data.Chain = chain.String()
+	if data.Chain == "" {
+		// not bundled, use the issuers stored along
+		data.Chain = string(c.Cert.IssuerCertificate)
+	}
+	return data, nil
+}
+
+// WriteFileIfChanged atomically replaces the file with data, by renaming a
+// temporary file written next to it, unless it already contains data. It
+// returns whether the file was written.
+func WriteFileIfChanged(filename string, data []byte, perm os.FileMode) (bool, error) {
+	if current, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,143 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/x509"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// RetryPolicy is how the orders and the renewals failing with a transient
+// error, a timeout, a connection error or a 5xx of the CA, are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, one or less disables
+	// the retries.
+	MaxAttempts int
+	// Backoff is the time to wait before the first retry, doubled after each
+	// attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of the clients, it tries three times
+// waiting 5 then 10 seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     5 * time.Second,
+	MaxBackoff:  time.Minute,
+}
+
+// transientMessages are found in the transient errors lego only returns as
+// text.
+var transientMessages = []string{"timeout", "connection reset", "connection refused", "unexpected EOF", "TLS handshake"}
+
+// isTransient returns whether the error may go away by trying again.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case acme.NonceError:
+		return true
+	case acme.RemoteError:
+		return e.StatusCode >= 500 || strings.HasSuffix(e.Type, ":serverInternal")
+	case net.Error:
+		return e.Timeout() || e.Temporary()
+	}
+	msg := err.Error()
+	for _, transient := range transientMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// allTransient returns whether all the failures are transient.
+func allTransient(failures map[string]error) bool {
+	for _, err := range failures {
+		if !isTransient(err) {
+			return false
+		}
+	}
+	return len(failures) > 0
+}
+
+// retry calls attempt until it succeeds, fails with an error which is not
+// transient or the attempts of the retry policy are exhausted.
+func (c *Client) retry(what string, attempt func() (transient bool)) {
+	backoff := c.Retry.Backoff
+	for i := 1; ; i++ {
+		if !attempt() || i >= c.Retry.MaxAttempts {
+			return
+		}
+		log.Printf("%s failed with a transient error, retrying in %s (attempt %d/%d)", what, backoff, i+1, c.Retry.MaxAttempts)
+		<-c.clock().After(backoff)
+		backoff *= 2
+		if c.Retry.MaxBackoff > 0 && backoff > c.Retry.MaxBackoff {
+			backoff = c.Retry.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return DefaultClock
+}
+
+// obtain obtains a certificate for the domains, retrying the transient
+// failures.
+func (c *Client) obtain(domains []string, bundle bool, privateKey crypto.PrivateKey) (cert acme.CertificateResource, failures map[string]error) {
+	pending := c.beginPending(domains)
+	c.retry("obtaining the certificate", func() bool {
+		cert, failures = c.Client.ObtainCertificate(domains, bundle, privateKey, c.MustStaple)
+		return allTransient(failures)
+	})
+	pending.end(len(failures) == 0)
+	c.recordBackoff(domains, failureList(failures)...)
+	return cert, failures
+}
+
+// obtainForCSR obtains a certificate for the CSR, retrying the transient
+// failures.
+func (c *Client) obtainForCSR(csr x509.CertificateRequest, bundle bool) (cert acme.CertificateResource, failures map[string]error) {
+	domains := csrDomains(&csr)
+	pending := c.beginPending(domains)
+	c.retry("obtaining the certificate", func() bool {
+		cert, failures = c.Client.ObtainCertificateForCSR(csr, bundle)
+		return allTransient(failures)
+	})
+	pending.end(len(failures) == 0)
+	c.recordBackoff(domains, failureList(failures)...)
+	return cert, failures
+}
+
+// renew renews the certificate, retrying the transient failures.
+func (c *Client) renew(res acme.CertificateResource, bundle bool) (cert acme.CertificateResource, err error) {
+	var domains []string
+	if certs, perr := parsePEMBundle(res.Certificate); perr == nil {
+		domains = certs[0].DNSNames
+	}
+	pending := c.beginPending(domains)
+	c.retry("renewing the certificate", func() bool {
+		cert, err = c.Client.RenewCertificate(res, bundle, c.MustStaple)
+		return err != nil && isTransient(err)
+	})
+	pending.end(err == nil)
+	if err != nil {
+		c.recordBackoff(domains, err)
+	}
+	return cert, err
+}
+
+// failureList returns the errors of the failures.
+func failureList(failures map[string]error) []error {
+	var errs []error
+	for _, err := range failures {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,54 @@
+package legoetcd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingClock is a Clock recording the waits instead of waiting.
+type recordingClock struct {
+	Clock
+	waits []time.Duration
+}
+
+func (c *recordingClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, Backoff: 5 * time.Second, MaxBackoff: 15 * time.Second}
+	tests := []struct {
+		name string
+		// failures are the errors of the attempts, in order
+		failures  []error
+		wantWaits []time.Duration
+	}{
+		{"success", []error{nil}, nil},
+		{"transient failure", []error{errors.New("i/o timeout"), nil}, []time.Duration{5 * time.Second}},
+		{"permanent failure", []error{errors.New("the domain is not allowed")}, nil},
+		{"attempts exhausted", []error{errors.New("i/o timeout"), errors.New("i/o timeout"), errors.New("i/o timeout"), errors.New("i/o timeout")}, []time.Duration{5 * time.Second, 10 * time.Second, 15 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &recordingClock{Clock: DefaultClock}
+			c := &Client{Retry: policy, Clock: clock}
+			attempts := 0
+			c.retry("testing", func() bool {
+				err := tt.failures[attempts]
+				attempts++
+				return err != nil && isTransient(err)
+			})
+			if attempts != len(tt.failures) {
+				t.Errorf("want %d attempt(s), got %d", len(tt.failures), attempts)
+			}
+			if !reflect.DeepEqual(clock.waits, tt.wantWaits) {
+				t.Errorf("want the waits %v, got %v", tt.wantWaits, clock.waits)
+			}
+		})
+	}
+}
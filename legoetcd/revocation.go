@@ -0,0 +1,83 @@
+package legoetcd
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// ErrNoRevocationInfo is returned when the certificate carries neither an
+// OCSP responder nor a CRL distribution point.
+var ErrNoRevocationInfo = errors.New("the certificate has neither an OCSP responder nor a CRL distribution point")
+
+// Revoked checks whether the certificate was revoked by its issuer. The OCSP
+// responder of the certificate is asked first, the CRL distribution points are
+// used for the certificates without one.
+func (c *Cert) Revoked() (bool, error) {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return false, err
+	}
+	leaf := certs[0]
+	if len(leaf.OCSPServer) > 0 {
+		_, resp, err := acme.GetOCSPForCert(c.Cert.Certificate)
+		if err != nil {
+			return false, err
+		}
+		return resp.Status == acme.OCSPRevoked, nil
+	}
+	if len(leaf.CRLDistributionPoints) == 0 {
+		return false, ErrNoRevocationInfo
+	}
+	// the issuer is only known if the certificate was bundled, the CRL
+	// signature is not verified otherwise.
+	var issuer *x509.Certificate
+	if len(certs) > 1 {
+		issuer = certs[1]
+	}
+	// use the first distribution point that answers
+	var lastErr error
+	for _, url := range leaf.CRLDistributionPoints {
+		revoked, err := crlRevoked(url, leaf, issuer)
+		if err == nil {
+			return revoked, nil
+		}
+		lastErr = fmt.Errorf("error checking the CRL %s: %s", url, err)
+	}
+	return false, lastErr
+}
+
+// crlRevoked downloads the CRL at url and looks for the serial number of the
+// certificate in it.
+func crlRevoked(url string, cert, issuer *x509.Certificate) (bool, error) {
+	resp, err := acme.HTTPClient.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	crl, err := x509.ParseCRL(body)
+	if err != nil {
+		return false, err
+	}
+	if issuer != nil {
+		if err := issuer.CheckCRLSignature(crl); err != nil {
+			return false, err
+		}
+	}
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
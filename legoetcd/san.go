@@ -0,0 +1,225 @@
+package legoetcd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	certificatesPrefix = "/lego/certificates/"
+	// sanIndexKey maps a name to the name the certificate covering it is
+	// stored under.
+	sanIndexKey = "/lego/index/sans/%s"
+)
+
+// ListCerts returns the names the certificates are stored under in etcd.
+func ListCerts(s Store) ([]string, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	keys, err := s.Keys(ctx, certificatesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, certificatesPrefix)
+		// skip the history and the other keys of the certificates
+		if strings.Contains(name, "/") || !strings.HasSuffix(name, ".cert") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, ".cert"))
+	}
+	return names, nil
+}
+
+// LoadCertBySAN loads the certificate covering the name, a domain or an IP
+// address, whatever the domain it is stored under. The SAN index is looked up
+// first, for the name then for the wildcard covering it. Without an entry, the
+// certificates saved before the index existed are scanned: a certificate
+// listing the name is preferred over a wildcard certificate covering it, and
+// the one expiring last is preferred among several. It returns ErrKeyNotFound
+// if no certificate covers the name.
+func LoadCertBySAN(s Store, name string) (*Cert, error) {
+	name, err := NormalizeDomain(name)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []string{name}
+	if i := strings.Index(name, "."); i > 0 && !IsIP(name) {
+		candidates = append(candidates, "*"+name[i:])
+	}
+	for _, candidate := range candidates {
+		if cert, err := loadIndexedCert(s, candidate, name); err == nil {
+			return cert, nil
+		} else if !IsKeyNotFound(err) {
+			return nil, err
+		}
+	}
+	return scanCertBySAN(s, name)
+}
+
+// loadIndexedCert loads the certificate indexed under san, as long as it still
+// covers the name.
+func loadIndexedCert(s Store, san, name string) (*Cert, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	stored, err := s.Get(ctx, fmt.Sprintf(sanIndexKey, StorageName(san)))
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := LoadCert(s, []string{stored})
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := cert.Leaf()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := covers(leaf, name); !ok {
+		// a stale entry, the certificate was renewed without the name
+		return nil, ErrKeyNotFound
+	}
+	return cert, nil
+}
+
+// findCoveringCert returns a copy of a stored certificate covering all the
+// domains and valid for at least validity, to be saved under the first domain.
+func (c *Client) findCoveringCert(domains []string, validity time.Duration) (*Cert, error) {
+	if c.store == nil {
+		return nil, ErrKeyNotFound
+	}
+	existing, err := FindValidCert(c.store, domains, validity)
+	if err != nil {
+		return nil, err
+	}
+	res := existing.Cert
+	res.Domain = domains[0]
+	return &Cert{Domains: domains, Cert: res, Timing: existing.Timing}, nil
+}
+
+// FindValidCert returns the stored certificate covering all the domains and
+// valid for at least validity, whatever the name it is stored under. It
+// returns ErrKeyNotFound if there is none.
+func FindValidCert(s Store, domains []string, validity time.Duration) (*Cert, error) {
+	domains, err := NormalizeDomains(domains)
+	if err != nil {
+		return nil, err
+	}
+	if len(domains) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	cert, err := LoadCertBySAN(s, domains[0])
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := cert.Leaf()
+	if err != nil {
+		return nil, err
+	}
+	if leaf.NotAfter.Sub(DefaultClock.Now()) < validity {
+		return nil, ErrKeyNotFound
+	}
+	for _, domain := range domains[1:] {
+		if _, ok := covers(leaf, domain); !ok {
+			return nil, ErrKeyNotFound
+		}
+	}
+	return cert, nil
+}
+
+// sanNames returns the names the certificate is indexed under.
+func sanNames(leaf *x509.Certificate) []string {
+	names := append([]string(nil), leaf.DNSNames...)
+	if leaf.Subject.CommonName != "" {
+		names = append(names, leaf.Subject.CommonName)
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// sanIndex returns the entries of the SAN index pointing to the certificate.
+func (c *Cert) sanIndex(stored string) []KeyValue {
+	leaf, err := c.Leaf()
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var kvs []KeyValue
+	for _, name := range sanNames(leaf) {
+		key := fmt.Sprintf(sanIndexKey, StorageName(name))
+		if !seen[key] {
+			seen[key] = true
+			kvs = append(kvs, KeyValue{Key: key, Value: stored})
+		}
+	}
+	return kvs
+}
+
+func scanCertBySAN(s Store, name string) (*Cert, error) {
+	names, err := ListCerts(s)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		best         *Cert
+		bestExact    bool
+		bestNotAfter time.Time
+	)
+	for _, stored := range names {
+		cert, err := LoadCert(s, []string{stored})
+		if err != nil {
+			continue
+		}
+		leaf, err := cert.Leaf()
+		if err != nil {
+			continue
+		}
+		exact, ok := covers(leaf, name)
+		if !ok {
+			continue
+		}
+		if best == nil || (exact && !bestExact) || (exact == bestExact && leaf.NotAfter.After(bestNotAfter)) {
+			best, bestExact, bestNotAfter = cert, exact, leaf.NotAfter
+		}
+	}
+	if best == nil {
+		return nil, ErrKeyNotFound
+	}
+	return best, nil
+}
+
+// covers returns whether the certificate covers the name, and whether it
+// lists the name rather than covering it with a wildcard.
+func covers(leaf *x509.Certificate, name string) (exact, ok bool) {
+	if ip := net.ParseIP(name); ip != nil {
+		for _, san := range leaf.IPAddresses {
+			if san.Equal(ip) {
+				return true, true
+			}
+		}
+		return false, false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	sans := leaf.DNSNames
+	if len(sans) == 0 && leaf.Subject.CommonName != "" {
+		sans = []string{leaf.Subject.CommonName}
+	}
+	for _, san := range sans {
+		san = strings.ToLower(san)
+		if san == name {
+			return true, true
+		}
+		// a wildcard only covers a single label
+		if strings.HasPrefix(san, "*.") {
+			if i := strings.Index(name, "."); i > 0 && name[i+1:] == san[2:] {
+				ok = true
+			}
+		}
+	}
+	return false, ok
+}
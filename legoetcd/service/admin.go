@@ -0,0 +1,178 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
+)
+
+// maxRecentEvents is the number of events kept for the admin API.
+const maxRecentEvents = 100
+
+// CertStatus is the state of a certificate managed by the service.
+type CertStatus struct {
+	Domains []string `json:"domains"`
+	// NotAfter is the expiration of the certificate last published.
+	NotAfter time.Time `json:"notAfter"`
+	// Revision is the etcd revision of the certificate last published.
+	Revision int64 `json:"revision"`
+	// PublishedAt is the time the certificate was last published.
+	PublishedAt time.Time `json:"publishedAt"`
+	// LastError is the error of the last failed renewal, cleared once the
+	// certificate is renewed.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Certificates returns the state of the certificates managed by the service.
+func (s *Service) Certificates() []CertStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	var certs []CertStatus
+	for _, spec := range s.certs {
+		if cs, ok := s.certStatus[spec.Domains[0]]; ok {
+			certs = append(certs, *cs)
+		}
+	}
+	return certs
+}
+
+// RecentEvents returns the last events of the certificates of the service,
+// oldest first.
+func (s *Service) RecentEvents() []*notify.Notification {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return append([]*notify.Notification(nil), s.events...)
+}
+
+// publish records the state of the certificate, sends it to the subscribers
+// and down CertChan (this locks up until the calling process can receive,
+// unless DropOldest is set).
+func (s *Service) publish(cert *legoetcd.Cert, reason Reason) {
+	notAfter, _ := cert.Expiration()
+	s.statusMu.Lock()
+	if s.certStatus == nil {
+		s.certStatus = make(map[string]*CertStatus)
+	}
+	cs, ok := s.certStatus[cert.Domains[0]]
+	if !ok {
+		cs = &CertStatus{Domains: cert.Domains}
+		s.certStatus[cert.Domains[0]] = cs
+	}
+	cs.NotAfter = notAfter
+	cs.Revision = cert.Revision
+	cs.PublishedAt = time.Now()
+	s.statusMu.Unlock()
+	ev := CertEvent{Cert: cert, Reason: reason}
+	s.broadcast(ev)
+	switch {
+	case s.CertChan == nil:
+	case s.DropOldest:
+		s.deliverMu.Lock()
+		old, dropped := offer(s.CertChan, ev)
+		s.deliverMu.Unlock()
+		if dropped {
+			log.Printf("the consumer of CertChan fell behind, dropped the %s event of the certificate for %v", old.Reason, old.Cert.Domains)
+		}
+	default:
+		s.CertChan <- ev
+	}
+}
+
+// recordEvent keeps the event for the admin API.
+func (s *Service) recordEvent(n *notify.Notification) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.events = append(s.events, n)
+	if len(s.events) > maxRecentEvents {
+		s.events = s.events[len(s.events)-maxRecentEvents:]
+	}
+	if cs, ok := s.certStatus[n.Domains[0]]; ok {
+		cs.LastError = n.Error
+	}
+}
+
+// AdminHandler returns the handler of the admin API, which lists the
+// certificates with their status on GET /certificates, dumps the recent
+// events on GET /events, checks the certificates on POST /renew, renewing
+// them with force=true, and reloads them from etcd on POST /reload. The renew
+// and reload requests are queued and the handler returns right away. It
+// offers no authentication and must only be reachable by the operators.
+func (s *Service) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/certificates", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, r, struct {
+			Status       Status       `json:"status"`
+			Leader       bool         `json:"leader"`
+			Certificates []CertStatus `json:"certificates"`
+		}{s.Status(), s.IsLeader(), s.Certificates()})
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, r, s.RecentEvents())
+	})
+	mux.HandleFunc("/renew", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		force, _ := strconv.ParseBool(r.FormValue("force"))
+		s.Check(force)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.Reload()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+func writeAdminJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing the admin response: %s", err)
+	}
+}
+
+// serveAdmin serves the admin API on AdminAddr until the service is stopped.
+func (s *Service) serveAdmin(l net.Listener) {
+	go func() {
+		<-s.StopChan
+		l.Close()
+	}()
+	if err := http.Serve(l, s.AdminHandler()); err != nil {
+		select {
+		case <-s.StopChan:
+		default:
+			log.Printf("error serving the admin API: %s", err)
+		}
+	}
+}
+
+// serveDebug serves the debug endpoints on DebugAddr until the service is
+// stopped.
+func (s *Service) serveDebug(l net.Listener) {
+	go func() {
+		<-s.StopChan
+		l.Close()
+	}()
+	if err := http.Serve(l, legoetcd.DebugHandler()); err != nil {
+		select {
+		case <-s.StopChan:
+		default:
+			log.Printf("error serving the debug endpoints: %s", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"log"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// Reason is why a certificate was sent on CertChan.
+type Reason int
+
+const (
+	// Initial is the certificate loaded or obtained when the service starts.
+	Initial Reason = iota
+	// Renewed is a certificate renewed, or replaced after a revocation, by
+	// this service.
+	Renewed
+	// ExternallyUpdated is a certificate changed in etcd by someone else,
+	// another service or the CLI.
+	ExternallyUpdated
+	// Reloaded is a certificate found changed when reloading it from etcd,
+	// after a change the watch missed.
+	Reloaded
+)
+
+func (r Reason) String() string {
+	switch r {
+	case Initial:
+		return "initial"
+	case Renewed:
+		return "renewed"
+	case ExternallyUpdated:
+		return "externally-updated"
+	case Reloaded:
+		return "reloaded"
+	}
+	return "unknown"
+}
+
+// CertEvent is a certificate sent on CertChan along with the reason.
+type CertEvent struct {
+	Cert   *legoetcd.Cert
+	Reason Reason
+}
+
+// markSaved records the revision of the certificate saved by this service, so
+// the watcher reports the change as Renewed.
+func (s *Service) markSaved(cert *legoetcd.Cert) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.saved == nil {
+		s.saved = make(map[string]int64)
+	}
+	s.saved[cert.CertPath()] = cert.Revision
+}
+
+// setSaving records whether this service is saving the certificate at path.
+func (s *Service) setSaving(path string, saving bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.saving == nil {
+		s.saving = make(map[string]bool)
+	}
+	if saving {
+		s.saving[path] = true
+	} else {
+		delete(s.saving, path)
+	}
+}
+
+// saveOwn saves a certificate obtained by this service. It is marked as being
+// saved first, so the watcher, which may see the new revision before the save
+// returns, reports the change as Renewed rather than ExternallyUpdated.
+func (s *Service) saveOwn(store legoetcd.Store, cert *legoetcd.Cert) error {
+	path := cert.CertPath()
+	s.setSaving(path, true)
+	defer s.setSaving(path, false)
+	overwrite := cert.Revision == 0
+	if err := s.save(store, cert); err != nil {
+		return err
+	}
+	if overwrite {
+		// pick up the revision it was saved at
+		if err := cert.Reload(store); err != nil {
+			log.Printf("error reloading the certificate: %s", err)
+		}
+	}
+	s.markSaved(cert)
+	return nil
+}
+
+// changeReason returns why the certificate changed in etcd.
+func (s *Service) changeReason(cert *legoetcd.Cert) Reason {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.saving[cert.CertPath()] {
+		return Renewed
+	}
+	if rev, ok := s.saved[cert.CertPath()]; ok && rev == cert.Revision {
+		return Renewed
+	}
+	return ExternallyUpdated
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+// peekingStore is a Store calling saved with the new revision of the key
+// once it is written, before CompareAndSetAll returns, like a watcher seeing
+// the change in the middle of the save.
+type peekingStore struct {
+	legoetcd.Store
+	saved func(key string, rev int64)
+}
+
+func (s peekingStore) CompareAndSetAll(ctx context.Context, kvs []legoetcd.KeyValue, key string, rev int64) (int64, error) {
+	newRev, err := s.Store.CompareAndSetAll(ctx, kvs, key, rev)
+	if err == nil {
+		s.saved(key, newRev)
+	}
+	return newRev, err
+}
+
+func TestChangeReason(t *testing.T) {
+	domains := []string{"example.com"}
+	ca, err := legoetcdtest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := legoetcdtest.NewStore()
+	if _, err := legoetcdtest.SeedCert(store, domains, 90*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	seeded, err := legoetcd.LoadCert(store, domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{}
+	var during Reason
+	peeking := peekingStore{Store: store, saved: func(key string, rev int64) {
+		during = s.changeReason(&legoetcd.Cert{Domains: domains, Cert: seeded.Cert, Revision: rev})
+	}}
+
+	renewed, err := ca.Issue(domains, 90*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed.Revision = seeded.Revision
+	if err := s.saveOwn(peeking, renewed); err != nil {
+		t.Fatal(err)
+	}
+	if during != Renewed {
+		t.Errorf("want the change seen during the save %s, got %s", Renewed, during)
+	}
+	if reason := s.changeReason(renewed); reason != Renewed {
+		t.Errorf("want the change seen after the save %s, got %s", Renewed, reason)
+	}
+
+	// another instance renews it next
+	theirs, err := legoetcd.LoadCert(store, domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Save(store, false); err != nil {
+		t.Fatal(err)
+	}
+	if reason := s.changeReason(theirs); reason != ExternallyUpdated {
+		t.Errorf("want their change %s, got %s", ExternallyUpdated, reason)
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
+	"golang.org/x/net/context"
+)
+
+// needsHealing returns whether the error reloading a certificate means its
+// data is missing or corrupt in etcd, rather than etcd being unavailable.
+func needsHealing(err error) bool {
+	if legoetcd.IsKeyNotFound(err) {
+		return true
+	}
+	switch err.(type) {
+	case *legoetcd.InconsistentCertError, *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	return false
+}
+
+// healIfNecessary re-obtains the certificate under its lock if err, the error
+// reloading it, means its data was deleted or corrupted in etcd. The data is
+// checked again under the lock, it may have been repaired or still be being
+// written by the instance holding the lock.
+func (s *Service) healIfNecessary(kapi legoetcd.KeysAPI, store legoetcd.Store, mc *managedCert, err error) {
+	if s.DisableAutoHeal || !needsHealing(err) {
+		return
+	}
+	lockPath := CertLockPath(mc.spec.Domains[0])
+	if s.holdsLock(lockPath) {
+		// this service is writing the certificate
+		return
+	}
+	if err := s.AcquireLock(context.Background(), kapi, lockPath, &LockOptions{BreakStale: true}); err != nil {
+		log.Printf("error grabbing the lock of the certificate for %v: %s", mc.spec.Domains, err)
+		return
+	}
+	defer s.Unlock(kapi, lockPath)
+	if _, _, err = mc.reload(store); err == nil || !needsHealing(err) {
+		return
+	}
+	log.Printf("the certificate for %v is missing or corrupt in etcd (%s), obtaining a new one", mc.spec.Domains, err)
+	cert, err := s.obtainCert(mc.acmeClient, mc.spec)
+	if err != nil {
+		log.Printf("error while replacing the certificate for %v: %s", mc.spec.Domains, err)
+		return
+	}
+	// overwrite whatever is left of the broken certificate
+	cert.Revision = 0
+	if err := s.saveOwn(store, cert); err != nil {
+		log.Printf("error saving the certificate: %s", err)
+		return
+	}
+	mc.set(cert)
+	s.certChanged(notify.EventObtained, mc.spec.Domains, cert)
+}
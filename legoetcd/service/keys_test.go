@@ -0,0 +1,222 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+// getterFunc is a legoetcd.KeyGetter answering with the function.
+type getterFunc func(key string) (*client.Response, error)
+
+func (f getterFunc) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	return f(key)
+}
+
+// setterFunc is a legoetcd.KeySetter answering with the function.
+type setterFunc func(key, value string, opts *client.SetOptions) (*client.Response, error)
+
+func (f setterFunc) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	return f(key, value, opts)
+}
+
+func TestReadLock(t *testing.T) {
+	errUnavailable := errors.New("etcd is unavailable")
+	tests := []struct {
+		name      string
+		get       getterFunc
+		wantToken string
+		wantErr   func(err error) bool
+	}{
+		{
+			name: "lock",
+			get: func(key string) (*client.Response, error) {
+				return &client.Response{Node: &client.Node{Key: key, Value: `{"host":"a","pid":1,"token":"t"}`}}, nil
+			},
+			wantToken: "t",
+		},
+		{
+			name: "no lock",
+			get: func(key string) (*client.Response, error) {
+				return nil, client.Error{Code: client.ErrorCodeKeyNotFound}
+			},
+			wantErr: client.IsKeyNotFound,
+		},
+		{
+			name: "corrupt lock",
+			get: func(key string) (*client.Response, error) {
+				return &client.Response{Node: &client.Node{Key: key, Value: "{"}}, nil
+			},
+			wantErr: func(err error) bool { return err != nil },
+		},
+		{
+			name: "etcd unavailable",
+			get: func(key string) (*client.Response, error) {
+				return nil, errUnavailable
+			},
+			wantErr: func(err error) bool { return err == errUnavailable },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ReadLock(tt.get, testLockPath)
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Token != tt.wantToken {
+				t.Errorf("want the token %q, got %q", tt.wantToken, info.Token)
+			}
+		})
+	}
+}
+
+func TestCurrentIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		get  getterFunc
+		want uint64
+	}{
+		{
+			name: "existing key",
+			get: func(key string) (*client.Response, error) {
+				return &client.Response{Node: &client.Node{Key: key, ModifiedIndex: 3}, Index: 42}, nil
+			},
+			want: 42,
+		},
+		{
+			name: "missing key",
+			get: func(key string) (*client.Response, error) {
+				return nil, client.Error{Code: client.ErrorCodeKeyNotFound, Index: 42}
+			},
+			want: 42,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := (&Service{}).currentIndex(tt.get, "/lego/certificates/example.com.cert")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index != tt.want {
+				t.Errorf("want the index %d, got %d", tt.want, index)
+			}
+		})
+	}
+}
+
+func TestRefreshLock(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(kapi *legoetcdtest.KeysAPI) setterFunc
+		// held is whether the lock is still held after the refresh
+		held bool
+		// refreshed is whether the refresh is written to etcd
+		refreshed bool
+	}{
+		{
+			name: "refreshed",
+			set: func(kapi *legoetcdtest.KeysAPI) setterFunc {
+				return func(key, value string, opts *client.SetOptions) (*client.Response, error) {
+					return kapi.Set(context.Background(), key, value, opts)
+				}
+			},
+			held:      true,
+			refreshed: true,
+		},
+		{
+			name: "taken over",
+			set: func(kapi *legoetcdtest.KeysAPI) setterFunc {
+				return func(key, value string, opts *client.SetOptions) (*client.Response, error) {
+					return nil, client.Error{Code: client.ErrorCodeTestFailed}
+				}
+			},
+		},
+		{
+			name: "expired",
+			set: func(kapi *legoetcdtest.KeysAPI) setterFunc {
+				return func(key, value string, opts *client.SetOptions) (*client.Response, error) {
+					return nil, client.Error{Code: client.ErrorCodeKeyNotFound}
+				}
+			},
+		},
+		{
+			name: "etcd unavailable",
+			set: func(kapi *legoetcdtest.KeysAPI) setterFunc {
+				return func(key, value string, opts *client.SetOptions) (*client.Response, error) {
+					return nil, errors.New("etcd is unavailable")
+				}
+			},
+			held: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+			clock := legoetcdtest.NewClock(start)
+			kapi := legoetcdtest.NewKeysAPI()
+			kapi.Clock = clock
+			s := &Service{LockTTL: 30 * time.Second, Clock: clock}
+			contents, err := s.lockContents("token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := kapi.Set(context.Background(), testLockPath, contents, &client.SetOptions{TTL: s.lockTTL()}); err != nil {
+				t.Fatal(err)
+			}
+			held := &heldLock{contents: contents, stop: make(chan struct{})}
+			s.locks = map[string]*heldLock{testLockPath: held}
+			defer close(held.stop)
+			calls := make(chan struct{}, 10)
+			set := tt.set(kapi)
+			go s.refreshLock(setterFunc(func(key, value string, opts *client.SetOptions) (*client.Response, error) {
+				defer func() { calls <- struct{}{} }()
+				return set(key, value, opts)
+			}), testLockPath, held)
+			clock.BlockUntil(1)
+			waitCall := func() {
+				select {
+				case <-calls:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for the refresh")
+				}
+			}
+			// the lock is refreshed every third of the TTL
+			clock.Advance(10 * time.Second)
+			waitCall()
+			if tt.held {
+				// the next refresh shows the previous one was handled
+				clock.Advance(10 * time.Second)
+				waitCall()
+				if !s.holdsLock(testLockPath) {
+					t.Error("want the lock still held")
+				}
+				if tt.refreshed {
+					info, err := ReadLock(kapi, testLockPath)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want := start.Add(20 * time.Second); !info.RefreshedAt.Equal(want) {
+						t.Errorf("want the lock refreshed at %s, got %s", want, info.RefreshedAt)
+					}
+				}
+				return
+			}
+			for deadline := time.Now().Add(5 * time.Second); s.holdsLock(testLockPath); time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("want the lock forgotten")
+				}
+			}
+		})
+	}
+}
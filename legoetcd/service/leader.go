@@ -0,0 +1,50 @@
+package service
+
+import (
+	"log"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// leaderKey is the lock held by the leader of the services.
+const leaderKey = "/lego/leader/lock"
+
+// IsLeader returns true if the service performs the renewals, either because
+// it was elected or because leader election is disabled.
+func (s *Service) IsLeader() bool {
+	if !s.LeaderElection {
+		return true
+	}
+	return s.holdsLock(leaderKey)
+}
+
+// campaign competes for the leadership until the service is stopped, the
+// leader holds the leader lock which is refreshed while it is alive. The other
+// instances try again every third of the lock TTL, taking over the lock once
+// it expires.
+func (s *Service) campaign(kapi legoetcd.KeysAPI) {
+	t := s.clock().NewTicker(s.lockTTL() / 3)
+	defer t.Stop()
+	for {
+		if !s.holdsLock(leaderKey) {
+			switch err := s.Lock(kapi, leaderKey); err {
+			case nil:
+				log.Print("elected leader, this instance renews the certificates")
+			case ErrLockExists:
+			default:
+				log.Printf("error campaigning for the leadership: %s", err)
+			}
+		}
+		select {
+		case <-t.C():
+		case <-s.StopChan:
+			if s.holdsLock(leaderKey) {
+				// let another instance take over right away
+				if err := s.Unlock(kapi, leaderKey); err != nil {
+					log.Printf("error resigning the leadership: %s", err)
+				}
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+// notifyingKeys is a KeysAPI telling the paths it sets, once they were set.
+type notifyingKeys struct {
+	*legoetcdtest.KeysAPI
+	sets chan string
+}
+
+func (k notifyingKeys) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	defer func() { k.sets <- key }()
+	return k.KeysAPI.Set(ctx, key, value, opts)
+}
+
+func TestCampaign(t *testing.T) {
+	clock := legoetcdtest.NewClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	kapi := notifyingKeys{KeysAPI: legoetcdtest.NewKeysAPI(), sets: make(chan string, 10)}
+	kapi.Clock = clock
+	s := &Service{LeaderElection: true, LockTTL: 30 * time.Second, Clock: clock, StopChan: make(chan struct{})}
+
+	// another instance leads, until its lock expires
+	contents, err := json.Marshal(&LockInfo{Host: "elsewhere", PID: 1, Token: "theirs", AcquiredAt: clock.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kapi.KeysAPI.Set(context.Background(), leaderKey, string(contents), &client.SetOptions{TTL: s.lockTTL()}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.campaign(kapi)
+		close(done)
+	}()
+	waitSet := func() {
+		select {
+		case <-kapi.sets:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the campaign")
+		}
+	}
+	waitSet()
+	clock.BlockUntil(1)
+	if s.IsLeader() {
+		t.Fatal("want the other instance to lead")
+	}
+
+	// the campaign tries again every third of the TTL
+	clock.Advance(10 * time.Second)
+	waitSet()
+	if s.IsLeader() {
+		t.Fatal("want the other instance to lead until its lock expires")
+	}
+
+	clock.Advance(20 * time.Second)
+	waitSet()
+	if !s.IsLeader() {
+		t.Fatal("want the instance elected once the lock expired")
+	}
+
+	// the leader resigns when stopped
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the campaign to stop")
+	}
+	if _, ok := kapi.Dump()[leaderKey]; ok {
+		t.Error("want the leader lock released")
+	}
+}
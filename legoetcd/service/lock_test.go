@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+const testLockPath = "/lego/certificates/example.com.lock"
+
+// setLock writes a lock held by another process, grabbed at acquiredAt.
+func setLock(t *testing.T, kapi *legoetcdtest.KeysAPI, path string, acquiredAt time.Time) {
+	contents, err := json.Marshal(&LockInfo{Host: "elsewhere", PID: 1, Token: "theirs", AcquiredAt: acquiredAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kapi.Set(context.Background(), path, string(contents), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI)
+	}{
+		{"grab a free lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			if err := s.Lock(kapi, testLockPath); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Unlock(kapi, testLockPath)
+			if !s.holdsLock(testLockPath) {
+				t.Error("want the lock held")
+			}
+			info, err := ReadLock(kapi, testLockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Token == "" || info.PID == 0 || info.AcquiredAt.IsZero() {
+				t.Errorf("want the holder recorded in the lock, got %+v", info)
+			}
+		}},
+		{"grab a held lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			setLock(t, kapi, testLockPath, time.Now())
+			if err := s.Lock(kapi, testLockPath); err != ErrLockExists {
+				t.Fatalf("want ErrLockExists, got %v", err)
+			}
+			if s.holdsLock(testLockPath) {
+				t.Error("want the lock not held")
+			}
+		}},
+		{"release a lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			if err := s.Lock(kapi, testLockPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Unlock(kapi, testLockPath); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := kapi.Dump()[testLockPath]; ok {
+				t.Error("want the lock removed")
+			}
+			if err := s.Unlock(kapi, testLockPath); err != ErrLockNotHeld {
+				t.Errorf("want ErrLockNotHeld releasing it again, got %v", err)
+			}
+		}},
+		{"release a lock taken over", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			if err := s.Lock(kapi, testLockPath); err != nil {
+				t.Fatal(err)
+			}
+			setLock(t, kapi, testLockPath, time.Now())
+			if err := s.Unlock(kapi, testLockPath); err != ErrLockNotHeld {
+				t.Fatalf("want ErrLockNotHeld, got %v", err)
+			}
+			info, err := ReadLock(kapi, testLockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Token != "theirs" {
+				t.Errorf("want their lock kept, got %+v", info)
+			}
+		}},
+		{"break a stale lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			setLock(t, kapi, testLockPath, time.Now().Add(-2*time.Hour))
+			broken, err := s.breakStaleLock(kapi, testLockPath, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !broken {
+				t.Fatal("want the stale lock broken")
+			}
+			if _, ok := kapi.Dump()[testLockPath]; ok {
+				t.Error("want the stale lock removed")
+			}
+		}},
+		{"keep a live lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			setLock(t, kapi, testLockPath, time.Now())
+			broken, err := s.breakStaleLock(kapi, testLockPath, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if broken {
+				t.Fatal("want the live lock kept")
+			}
+		}},
+		{"acquire a stale lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			setLock(t, kapi, testLockPath, time.Now().Add(-2*time.Hour))
+			if err := s.AcquireLock(context.Background(), kapi, testLockPath, &LockOptions{BreakStale: true, Timeout: time.Second}); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Unlock(kapi, testLockPath)
+			if !s.holdsLock(testLockPath) {
+				t.Error("want the lock held")
+			}
+		}},
+		{"acquire a lock once released", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			setLock(t, kapi, testLockPath, time.Now())
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				kapi.Delete(context.Background(), testLockPath, nil)
+			}()
+			if err := s.AcquireLock(context.Background(), kapi, testLockPath, &LockOptions{Timeout: time.Second}); err != nil {
+				t.Fatal(err)
+			}
+			s.Unlock(kapi, testLockPath)
+		}},
+		{"create an account under a stale lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			lockPath := fmt.Sprintf(accountLockKey, "user@example.com")
+			setLock(t, kapi, lockPath, time.Now().Add(-2*time.Hour))
+			s.LockWaitTimeout = time.Second
+			store := legoetcdtest.NewStore()
+			if err := s.createAccountIfNecessary(kapi, store, "user@example.com", "https://acme.example/directory"); err != nil {
+				t.Fatal(err)
+			}
+			if err := legoetcd.NewAccount("user@example.com").LoadKey(store); err != nil {
+				t.Errorf("want the account key created, got %v", err)
+			}
+			if _, ok := kapi.Dump()[lockPath]; ok {
+				t.Error("want the lock released")
+			}
+		}},
+		{"wait for the deletion of a lock", func(t *testing.T, s *Service, kapi *legoetcdtest.KeysAPI) {
+			setLock(t, kapi, testLockPath, time.Now())
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				kapi.Delete(context.Background(), testLockPath, &client.DeleteOptions{})
+			}()
+			s.LockWaitTimeout = time.Second
+			if err := s.WaitForLockDeletion(kapi, testLockPath); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, &Service{}, legoetcdtest.NewKeysAPI())
+		})
+	}
+}
+
+func TestLockWaitTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		wait func(s *Service, kapi *legoetcdtest.KeysAPI) error
+	}{
+		{"acquire a live lock", func(s *Service, kapi *legoetcdtest.KeysAPI) error {
+			return s.AcquireLock(context.Background(), kapi, testLockPath, &LockOptions{BreakStale: true})
+		}},
+		{"wait for the deletion of a live lock", func(s *Service, kapi *legoetcdtest.KeysAPI) error {
+			return s.WaitForLockDeletion(kapi, testLockPath)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := legoetcdtest.NewClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+			kapi := legoetcdtest.NewKeysAPI()
+			kapi.Clock = clock
+			s := &Service{Clock: clock, LockWaitTimeout: time.Minute}
+			setLock(t, kapi, testLockPath, clock.Now())
+			errc := make(chan error, 1)
+			go func() { errc <- tt.wait(s, kapi) }()
+			// the timeout and the first re-check of the lock
+			clock.BlockUntil(2)
+			clock.Advance(time.Minute)
+			select {
+			case err := <-errc:
+				werr, ok := err.(*LockWaitTimeoutError)
+				if !ok {
+					t.Fatalf("want a *LockWaitTimeoutError, got %v", err)
+				}
+				if werr.Waited != time.Minute {
+					t.Errorf("want the wait timed on the clock at %s, got %s", time.Minute, werr.Waited)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the lock wait to time out")
+			}
+		})
+	}
+}
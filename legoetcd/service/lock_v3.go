@@ -0,0 +1,135 @@
+package service
+
+import (
+	"log"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"golang.org/x/net/context"
+)
+
+// useV3Locks returns true if the locks are held in the etcd v3 keyspace.
+func (s *Service) useV3Locks() bool {
+	return s.EtcdV3Locks && s.etcdV3Client != nil
+}
+
+// lockSession returns the session the v3 locks of the service are attached
+// to, creating it on first use or once the lease of the previous one expired.
+func (s *Service) lockSession() (*concurrency.Session, error) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if s.session != nil {
+		select {
+		case <-s.session.Done():
+		default:
+			return s.session, nil
+		}
+	}
+	session, err := concurrency.NewSession(s.etcdV3Client)
+	if err != nil {
+		return nil, err
+	}
+	s.session = session
+	return session, nil
+}
+
+// closeLockSession revokes the lease of the session, releasing the v3 locks
+// still held, when the service stops.
+func (s *Service) closeLockSession() {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if s.session == nil {
+		return
+	}
+	if err := s.session.Close(); err != nil {
+		log.Printf("error revoking the lease of the locks: %s", err)
+	}
+	s.session = nil
+}
+
+// lockV3 creates the lock attached to the lease of the session of the service,
+// the lease is kept alive by the session and expires shortly after the service
+// dies, releasing the lock.
+func (s *Service) lockV3(path, contents string) error {
+	session, err := s.lockSession()
+	if err != nil {
+		return err
+	}
+	ctx, cancelFunc := legoetcd.DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	resp, err := s.etcdV3Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(path), "=", 0)).
+		Then(clientv3.OpPut(path, contents, clientv3.WithLease(session.Lease()))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrLockExists
+	}
+	s.locksMu.Lock()
+	if s.locks == nil {
+		s.locks = make(map[string]*heldLock)
+	}
+	s.locks[path] = &heldLock{contents: contents, v3: true, stop: make(chan struct{})}
+	s.locksMu.Unlock()
+	log.Printf(legoetcd.DebugPrefix+"grabbed the lock %q with lease %x", path, session.Lease())
+	return nil
+}
+
+// unlockV3 removes the lock only if it still holds our contents.
+func (s *Service) unlockV3(path, contents string) error {
+	ctx, cancelFunc := legoetcd.DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	resp, err := s.etcdV3Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(path), "=", contents)).
+		Then(clientv3.OpDelete(path)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		log.Printf("the lock %q was taken over by someone else, not removing it", path)
+		return ErrLockNotHeld
+	}
+	log.Printf(legoetcd.DebugPrefix+"released the lock %q held with %s", path, contents)
+	return nil
+}
+
+// waitForLockDeletionV3 is the etcd v3 counterpart of waitForLockDeletion.
+func (s *Service) waitForLockDeletionV3(waitCtx context.Context, path string) error {
+	backoff := minLockWaitBackoff
+	for {
+		// does the lock still exist?
+		ctx, cancelFunc := legoetcd.DefaultBudgets.EtcdContext()
+		resp, err := s.etcdV3Client.Get(ctx, path)
+		cancelFunc()
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+		if err := waitCtx.Err(); err != nil {
+			return err
+		}
+		// watch the key for deletion until the next check
+		ctx, cancelFunc = context.WithTimeout(waitCtx, backoff)
+		wch := s.etcdV3Client.Watch(ctx, path, clientv3.WithRev(resp.Header.Revision+1))
+		for wresp := range wch {
+			for _, ev := range wresp.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					cancelFunc()
+					return nil
+				}
+			}
+		}
+		cancelFunc()
+		// back off before checking again
+		backoff *= 2
+		if backoff > maxLockWaitBackoff {
+			backoff = maxLockWaitBackoff
+		}
+	}
+}
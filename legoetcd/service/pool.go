@@ -0,0 +1,40 @@
+package service
+
+import "sync"
+
+// concurrency returns how many certificates are obtained or renewed at once.
+func (s *Service) concurrency() int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+	return 1
+}
+
+// forEachCert calls f for each certificate, up to Concurrency of them at once,
+// and returns once all the calls returned. The certificates solving their
+// challenges with the built-in HTTP or TLS server take turns as they listen on
+// the same address.
+func (s *Service) forEachCert(certs []*managedCert, f func(mc *managedCert)) {
+	jobs := make(chan *managedCert)
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency() && i < len(certs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mc := range jobs {
+				if mc.listens {
+					s.listenMu.Lock()
+				}
+				f(mc)
+				if mc.listens {
+					s.listenMu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, mc := range certs {
+		jobs <- mc
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -1,15 +1,23 @@
 package service
 
 import (
+	"bytes"
+	"crypto"
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
 	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/notify"
 	"github.com/xenolf/lego/acme"
 )
 
@@ -25,241 +33,1111 @@ var (
 	ErrTOSNotAccepted = errors.New("Let's encrypt terms of service was not accepted")
 
 	minimumDurationForRenewal = 45 * 24 * time.Hour
+	defaultRevocationInterval = 6 * time.Hour
+	defaultResyncInterval     = 10 * time.Minute
+	defaultDeploymentTimeout  = 5 * time.Minute
 )
 
+// CertSpec describes a certificate managed by the service.
+type CertSpec struct {
+	// Domains are the domains of the certificate, the first domain is used to
+	// store the certificate in etcd.
+	Domains []string
+	// CSRFile is the certificate signing request to use instead of the domains.
+	CSRFile string
+	// CSR declares the attributes of a certificate signing request generated
+	// by the service, and saved along with the certificate, instead of a
+	// CSRFile. The Domains default to its names.
+	CSR *legoetcd.CSRConfig
+	// Challenge overrides the challenge configuration of the service for this
+	// certificate, leave nil to use the service's challenge.
+	Challenge *legoetcd.ChallengeConfig
+	// SPIFFEID is an optional SPIFFE ID (spiffe://trust-domain/path) encoded
+	// as a URI SAN in the certificate, only supported by internal CAs.
+	SPIFFEID string
+	// PublishDelay overrides the publish delay of the service for this
+	// certificate, leave nil to use the service's delay.
+	PublishDelay *time.Duration
+	// Email selects the account the certificate is issued with, leave empty
+	// to use the service's account.
+	Email string
+	// ACMEServer selects the ACME directory the certificate is issued by,
+	// leave empty to use the service's directory.
+	ACMEServer string
+	// KeyType is the type of the private key of the certificate, leave empty
+	// to use the service's KeyType. It applies when the certificate is
+	// obtained, the renewals keep the type of the key of the certificate.
+	KeyType acme.KeyType
+	// PrivateKey is the PEM-encoded private key the certificate is obtained
+	// and renewed with instead of a generated one, read from a file or from
+	// etcd:///path/to/key.
+	PrivateKey string
+	// VerifyEndpoint, if set, is the endpoint serving the certificate, such
+	// as https://example.com:443, checked to serve the renewed certificate
+	// within the DeploymentTimeout of the service.
+	VerifyEndpoint string
+}
+
 // Service represents a lego-etcd service that is able to manage the
 // certificate for the given domains by generating certificates through Let's
 // encrypt, storing them in etcd and renew them as well. The service is fully
 // managed.
 type Service struct {
 	// CertChan is the channel where the service sends out the certificate at the
-	// retrieval and at the renewal time.
-	CertChan chan *legoetcd.Cert
+	// retrieval and at the renewal time, along with the reason. The service
+	// blocks until the event is received, unless CertChan is nil or
+	// DropOldest is set, see Subscribe for multiple consumers and Latest.
+	CertChan chan CertEvent
+	// DropOldest never blocks the service on CertChan: the oldest event
+	// queued is dropped when CertChan is full, so the renewals never wait for
+	// the consumer. CertChan should then be buffered, such as with
+	// make(chan CertEvent, 16), as an unbuffered CertChan only gets the events
+	// its consumer is waiting for.
+	DropOldest bool
 	// StopChan if closed will stop the service.
 	StopChan chan struct{}
 	// KeyType is the crypto type for the key, Supported: rsa2048, rsa4096,
-	// rsa8192, ec256, ec384.
+	// rsa8192, ec256, ec384. The certificates may override it in their
+	// CertSpec.
 	KeyType acme.KeyType
 	// NoBundle disables bundling of the issuer certificate along with the
 	// domain's certificate.
 	NoBundle bool
+	// MustStaple requests the OCSP Must-Staple extension in the certificates
+	// obtained for domains.
+	MustStaple bool
+	// PreferredChain is the common name of the issuer the bundled chain should
+	// end at, such as "ISRG Root X1".
+	PreferredChain string
+	// ReuseKey renews the certificates with their private key instead of a
+	// new key of the same type.
+	ReuseKey bool
+	// ReuseExistingFor, if positive, reuses a stored certificate covering all
+	// the domains of a missing certificate and valid for at least this long
+	// instead of ordering a duplicate.
+	ReuseExistingFor time.Duration
+	// PKCS12Password, if set, also stores the certificates and their keys as
+	// PKCS#12 bundles protected by this password.
+	PKCS12Password string
+	// SigningKey, if set, signs the stored certificates with this key, see
+	// legoetcd.Cert.SigningKey.
+	SigningKey crypto.PrivateKey
+	// HostPolicy restricts the domains the service may issue certificates
+	// for, nil allows all the domains.
+	HostPolicy *legoetcd.HostPolicy
+	// CAAIdentities, if set, overrides the CAA identities of the CA the CAA
+	// records of the domains are checked against, see
+	// legoetcd.Client.CAAIdentities.
+	CAAIdentities []string
+	// Retry, if set, overrides how the orders and the renewals failing with
+	// a transient error are retried, legoetcd.DefaultRetryPolicy by default.
+	Retry *legoetcd.RetryPolicy
+	// Authorizer, if set, is invoked before the service orders any
+	// certificate.
+	Authorizer legoetcd.Authorizer
+	// RenewHook is a command run with sh after a certificate was obtained,
+	// renewed or replaced after a revocation by this service, see
+	// legoetcd.RunHook for the environment describing the certificate.
+	RenewHook string
+	// Notifiers are sent the certificates obtained, renewed or replaced after
+	// a revocation, and the renewal failures.
+	Notifiers []notify.Notifier
+	// RateLimit refuses the issuances which would exceed the rate limits of
+	// Let's Encrypt, counting the issuances of all the services and the
+	// clients sharing the etcd cluster.
+	RateLimit bool
+	// Challenge is the challenge configuration of the certificates that do not
+	// override it.
+	Challenge legoetcd.ChallengeConfig
+	// PublishDelay is the time to wait between renewing a certificate and
+	// saving it to etcd, allowing the CT logs and the OCSP responders to catch
+	// up before the consumers start serving it.
+	PublishDelay time.Duration
+	// LockTTL is the time after which a lock expires if its holder did not
+	// remove it, it defaults to one hour. The locks are refreshed while held,
+	// so it only bounds how long the lock of a dead holder lingers.
+	LockTTL time.Duration
+	// LockMetadata is stored in the locks grabbed by the service, for instance
+	// to identify the cluster or the zone of the holder while debugging.
+	LockMetadata map[string]string
+	// LockWaitTimeout is the maximum time to wait for a lock held by another
+	// instance to be deleted, it defaults to LockTTL plus 30 minutes.
+	LockWaitTimeout time.Duration
+	// RevocationCheckInterval is how often the revocation status of the
+	// certificates is checked, a revoked certificate is replaced right away.
+	// It defaults to six hours.
+	RevocationCheckInterval time.Duration
+	// RenewalSpread spreads the renewals of the certificates over this period
+	// before they are due, at a point derived from their domains, to avoid
+	// renewing a large inventory at once. It is ignored for the certificates
+	// whose CA suggests a renewal window.
+	RenewalSpread time.Duration
+	// ResyncInterval is how often the certificates are reloaded from etcd, in
+	// case the watch missed a change. It defaults to ten minutes.
+	ResyncInterval time.Duration
+	// Clock drives the renewal checks and the timers of the service, it
+	// defaults to legoetcd.DefaultClock.
+	Clock legoetcd.Clock
+	// HistoryRetention is the number of previous certificates kept in etcd
+	// when a certificate is replaced, to allow rolling back. It defaults to
+	// legoetcd.DefaultHistoryRetention, a negative value disables the
+	// history.
+	HistoryRetention int
+	// EventRetention is the number of events kept in the event log of each
+	// certificate in etcd, see legoetcd.EventLog. It defaults to
+	// legoetcd.DefaultEventRetention, a negative value disables the event
+	// log.
+	EventRetention int
+	// Audit records who performed each write to etcd in the event log of the
+	// certificates, see legoetcd.Auditor, unless legoetcd.Audit is already
+	// set.
+	Audit bool
+	// AuditFile, if set, is the local file the audit records are also
+	// appended to.
+	AuditFile string
+	// LogFormat, text or json, makes the service configure the standard
+	// logger to write the messages of LogLevel and above in this format, see
+	// legoetcd.ConfigureLogging. The logger is left alone if it is empty.
+	LogFormat string
+	// LogLevel is the minimum level of the messages logged if LogFormat is
+	// set, it defaults to legoetcd.LogInfo.
+	LogLevel legoetcd.LogLevel
+	// EtcdV3Config enables writing the accounts and the certificates to the
+	// etcd v3 keyspace as well, while the consumers migrate. The v2 keyspace
+	// remains the source of truth and is still used for locking and watching.
+	EtcdV3Config *clientv3.Config
+	// LeaderElection elects one of the services sharing the etcd cluster to
+	// renew and replace all the certificates, the others only watch them.
+	// The certificates missing at startup are still obtained by whichever
+	// service grabs their lock.
+	LeaderElection bool
+	// SystemdNotify notifies systemd once the certificates were sent on
+	// CertChan for a unit with Type=notify, and pings its watchdog if
+	// WatchdogSec is set as long as the watches of the certificates are
+	// alive.
+	SystemdNotify bool
+	// AdminAddr, if set, is the address the admin API is served on, see
+	// AdminHandler. It should be a localhost address as the API offers no
+	// authentication.
+	AdminAddr string
+	// DebugAddr, if set, is the address the pprof profiles and the expvar
+	// counters are served on, see legoetcd.DebugHandler. Like AdminAddr, it
+	// should be a localhost address.
+	DebugAddr string
+	// HandleSignals checks the certificates on SIGHUP, as Check does, and
+	// stops the service gracefully on SIGTERM and SIGINT.
+	HandleSignals bool
+	// ForceRenewOnHUP renews all the certificates on SIGHUP instead of only
+	// those due for a renewal.
+	ForceRenewOnHUP bool
+	// EtcdV3Locks grabs the locks in the etcd v3 keyspace, attached to the
+	// lease of a session kept alive by the service, instead of the v2 locks.
+	// The locks of a dead instance are released as soon as its lease expires
+	// instead of lingering until their TTL. It requires EtcdV3Config.
+	EtcdV3Locks bool
+	// DeploymentTimeout is how long the VerifyEndpoint of a renewed
+	// certificate may take to serve it before an EventDeploymentFailed is
+	// sent. It defaults to five minutes.
+	DeploymentTimeout time.Duration
+	// DisableAutoHeal only logs when a certificate is found deleted or corrupt
+	// in etcd, instead of obtaining a new one under its lock.
+	DisableAutoHeal bool
+	// Concurrency is how many certificates are obtained, renewed or replaced
+	// at once, so a large inventory does not hit the CA and the DNS provider
+	// all at the same time. It defaults to one, the certificates being handled
+	// one after the other.
+	Concurrency int
 
 	acceptTOS   bool
 	acmeServer  string
-	csrFile     string
-	dns         string
-	domains     []string
+	certs       []CertSpec
 	email       string
 	etcdConfig  client.Config
 	generatePEM bool
-	webroot     string
+
+	etcdV3Client *clientv3.Client
+	eventLog     *legoetcd.EventLog
+
+	// session is the session of the v3 locks, closed when Run returns.
+	sessionMu sync.Mutex
+	session   *concurrency.Session
+
+	locksMu sync.Mutex
+	locks   map[string]*heldLock
+
+	statusMu   sync.Mutex
+	status     Status
+	certStatus map[string]*CertStatus
+	saved      map[string]int64
+	saving     map[string]bool
+	events     []*notify.Notification
+
+	beatsMu sync.Mutex
+	beats   map[string]time.Time
+
+	deliverMu sync.Mutex
+
+	subsMu    sync.Mutex
+	subs      map[*subscriber]struct{}
+	published map[string]*legoetcd.Cert
+
+	checksOnce sync.Once
+	checks     chan checkRequest
+	stopOnce   sync.Once
+
+	// listenMu is held while solving a challenge with the built-in HTTP or
+	// TLS server.
+	listenMu sync.Mutex
+}
+
+// managedCert is a certificate managed by the running service.
+type managedCert struct {
+	spec       CertSpec
+	acmeClient *legoetcd.Client
+
+	// mu guards cert and pending. The certificate is never modified once
+	// set, the reloads and the renewals replace it, so it may be read without
+	// the lock once returned by current.
+	mu   sync.Mutex
+	cert *legoetcd.Cert
+	// pending is the renewed certificate waiting for the PublishDelay
+	// before being saved, under the lock of the renewal.
+	pending *legoetcd.Cert
+
+	// renewWindow is the last renewal window suggested by the CA, and renewAt
+	// the time picked in it.
+	renewWindow *legoetcd.RenewalWindow
+	renewAt     time.Time
+	// listens is whether the challenge is solved with the built-in HTTP or
+	// TLS server.
+	listens bool
+	// retryTimer checks the certificate again once the rate limit it hit
+	// lifts.
+	retryTimer legoetcd.Timer
+}
+
+// current returns the certificate, see mu.
+func (mc *managedCert) current() *legoetcd.Cert {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.cert
+}
+
+// set replaces the certificate.
+func (mc *managedCert) set(cert *legoetcd.Cert) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.cert = cert
+}
+
+// reload replaces the certificate with a copy reloaded from etcd, and returns
+// it along with the certificate it replaced.
+func (mc *managedCert) reload(store legoetcd.Store) (cert, previous *legoetcd.Cert, err error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	reloaded := *mc.cert
+	if err := reloaded.Reload(store); err != nil {
+		return nil, mc.cert, err
+	}
+	previous, mc.cert = mc.cert, &reloaded
+	return mc.cert, previous, nil
+}
+
+// setPending records the renewed certificate waiting to be saved, nil once
+// it was.
+func (mc *managedCert) setPending(cert *legoetcd.Cert) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.pending = cert
 }
 
 // New returns a new service, the default keyType is RSA2048 but you may change
 // by setting the KeyType on the returned service. By default, the service will
 // generate a bundled certificate (containing the issuer certificate and your
-// certificate). To disable bundling, set `NoBundle` to true.
+// certificate). To disable bundling, set `NoBundle` to true. Additional
+// certificates may be managed by the same service with AddCert, and all of
+// them if domains and csrFile are empty.
 func New(etcdConfig client.Config, acmeServer, email string, domains []string, csrFile string, acceptTOS, generatePEM bool, dns, webroot string) *Service {
-	return &Service{
-		CertChan: make(chan *legoetcd.Cert),
+	s := &Service{
+		CertChan: make(chan CertEvent),
 		StopChan: make(chan struct{}),
 		KeyType:  acme.RSA2048,
+		Challenge: legoetcd.ChallengeConfig{
+			DNS:     dns,
+			WebRoot: webroot,
+		},
 
 		acceptTOS:   acceptTOS,
 		acmeServer:  acmeServer,
-		csrFile:     csrFile,
-		dns:         dns,
-		domains:     domains,
 		email:       email,
 		etcdConfig:  etcdConfig,
 		generatePEM: generatePEM,
-		webroot:     webroot,
 	}
+	if len(domains) > 0 || csrFile != "" {
+		s.certs = []CertSpec{{Domains: domains, CSRFile: csrFile}}
+	}
+	return s
+}
+
+// AddCert adds a certificate to be managed by the service, it must be called
+// before Run.
+func (s *Service) AddCert(spec CertSpec) {
+	if len(spec.Domains) == 0 && spec.CSR != nil {
+		spec.Domains = spec.CSR.Names()
+	}
+	s.certs = append(s.certs, spec)
 }
 
 // Run starts the certificate loop
 func (s *Service) Run() error {
+	if s.LogFormat != "" {
+		if err := legoetcd.ConfigureLogging(s.LogLevel, s.LogFormat); err != nil {
+			return err
+		}
+	}
+	if len(s.certs) == 0 {
+		return errors.New("no certificate to manage, see AddCert")
+	}
+	s.statusMu.Lock()
+	s.status = Status{Since: s.clock().Now()}
+	s.statusMu.Unlock()
 	// create an etcd client
 	etcdClient, err := client.New(s.etcdConfig)
-	// create a new keys API
+	if err != nil {
+		return fmt.Errorf("error creating a new etcd client: %s", err)
+	}
 	kapi := client.NewKeysAPI(etcdClient)
-	// initialize the account
-	if err := s.createAccountIfNecessary(etcdClient); err != nil {
+	store, err := s.newStore(kapi)
+	if err != nil {
 		return err
 	}
-	// create a new ACME client
-	// TODO: httpAddr and tlsAddr support
-	acmeClient, err := legoetcd.New(etcdClient, s.acmeServer, s.email, s.KeyType, s.dns, s.webroot, "", "")
-	if err != nil {
-		return fmt.Errorf("error creating a new ACME server: %s", err)
+	if s.EtcdV3Locks && s.etcdV3Client == nil {
+		return errors.New("EtcdV3Locks requires EtcdV3Config")
 	}
-	// register the account and accept tos
-	log.Printf("registering the account with Let's Encrypt: %s", s.email)
-	if err := acmeClient.RegisterAccount(etcdClient, s.acceptTOS); err != nil {
-		if err == legoetcd.ErrMustAcceptTOS {
-			return ErrTOSNotAccepted
-		}
-		return fmt.Errorf("error registering the account: %s", err)
+	defer s.closeLockSession()
+	if s.EventRetention >= 0 {
+		s.eventLog = &legoetcd.EventLog{Store: store, Retention: s.EventRetention}
 	}
-	// watch the certificate on etcd, and send the certificate down the channel.
-	// initialize the certificate
-	cert, err := s.generateCertificateIfNecessary(etcdClient, acmeClient)
-	if err != nil {
-		return err
+	if s.Audit && legoetcd.Audit == nil {
+		legoetcd.Audit = legoetcd.NewAuditor("service", s.AuditFile)
+		legoetcd.Audit.Retention = s.EventRetention
 	}
-	go func() {
-		w := kapi.Watcher(cert.CertPath(), nil)
-		for {
-			done := make(chan struct{})
-			ctx, cancelFunc := context.WithCancel(context.Background())
-			go func(done chan struct{}) {
-				// block until either a stop which cancels the context or a stop event
-				// which is sent after the current next unblocks.
-				select {
-				case <-s.StopChan:
-					cancelFunc()
-				case <-done:
-				}
-			}(done)
-			resp, err := w.Next(ctx)
-			close(done)
-			cancelFunc()
-			if err != nil {
-				log.Printf("received an error fetching the next change to the certificate %q: %s", cert.CertPath(), err)
+	// initialize the certificates, each with an ACME client configured with
+	// its own account and challenge.
+	var certs []*managedCert
+	accounts := make(map[string]bool)
+	for _, spec := range s.certs {
+		email, acmeServer := s.email, s.acmeServer
+		if spec.Email != "" {
+			email = spec.Email
+		}
+		if spec.ACMEServer != "" {
+			acmeServer = spec.ACMEServer
+		}
+		// initialize the account
+		if !accounts[email+" "+acmeServer] {
+			if err := s.createAccountIfNecessary(kapi, store, email, acmeServer); err != nil {
+				return err
 			}
-			if resp.Action != "get" && resp.Action != "delete" {
-				// sleep for one second to allow whoever updating to finish up with the
-				// key as well.
-				if err := cert.Reload(etcdClient); err != nil {
-					log.Printf("error reloading the certificate: %s", err)
-				} else {
-					s.CertChan <- cert
-				}
+			accounts[email+" "+acmeServer] = true
+		}
+		challenge := s.Challenge
+		if spec.Challenge != nil {
+			challenge = *spec.Challenge
+		}
+		keyType := s.KeyType
+		if spec.KeyType != "" {
+			keyType = spec.KeyType
+		}
+		acmeClient, err := s.newACMEClient(store, challenge, email, acmeServer, keyType)
+		if err != nil {
+			return err
+		}
+		if spec.PrivateKey != "" {
+			if acmeClient.PrivateKey, err = legoetcd.LoadPrivateKey(store, spec.PrivateKey); err != nil {
+				return fmt.Errorf("error loading the private key of the certificate for %v: %s", spec.Domains, err)
 			}
 		}
-	}()
-	// send the cert down the channel (this locks up until the calling process can receive).
-	s.CertChan <- cert
+		certs = append(certs, &managedCert{
+			spec:       spec,
+			acmeClient: acmeClient,
+			listens:    challenge.DNS == "" && challenge.WebRoot == "",
+		})
+	}
+	// load the certificates, obtaining the missing ones
+	var (
+		errMu    sync.Mutex
+		firstErr error
+	)
+	s.forEachCert(certs, func(mc *managedCert) {
+		cert, err := s.generateCertificateIfNecessary(kapi, store, mc.acmeClient, mc.spec)
+		if err != nil {
+			errMu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMu.Unlock()
+			return
+		}
+		mc.set(cert)
+	})
+	if firstErr != nil {
+		return firstErr
+	}
+	if s.AdminAddr != "" {
+		l, err := net.Listen("tcp", s.AdminAddr)
+		if err != nil {
+			return fmt.Errorf("error listening for the admin API: %s", err)
+		}
+		go s.serveAdmin(l)
+	}
+	if s.DebugAddr != "" {
+		l, err := net.Listen("tcp", s.DebugAddr)
+		if err != nil {
+			return fmt.Errorf("error listening for the debug endpoints: %s", err)
+		}
+		go s.serveDebug(l)
+	}
+	if s.HandleSignals {
+		go s.handleSignals()
+	}
+	if s.LeaderElection {
+		go s.campaign(kapi)
+	}
+	// watch the certificates on etcd, and send them down the channel.
+	for _, mc := range certs {
+		go s.watchCert(kapi, store, mc)
+	}
+	// send the certs down the channel (this locks up until the calling process can receive).
+	for _, mc := range certs {
+		s.publish(mc.current(), Initial)
+	}
+	if s.SystemdNotify {
+		if err := sdNotify("READY=1"); err != nil {
+			log.Printf("error notifying systemd: %s", err)
+		}
+		if interval := sdWatchdogInterval(); interval > 0 {
+			go s.watchdog(interval)
+		}
+	}
 	// start the update loop
-	t := time.NewTicker(12 * time.Hour)
+	t := s.clock().NewTicker(legoetcd.DefaultBudgets.RenewalCheck)
+	defer t.Stop()
+	r := s.clock().NewTicker(s.revocationCheckInterval())
+	defer r.Stop()
 	for {
 		select {
-		case <-t.C:
-			// do we need to renew the certificate?
-			exp, err := cert.ExpiresIn()
-			if err != nil {
-				log.Printf("was not able to query the certificate expiration date: %s", err)
-				goto nextChange
+		case <-t.C():
+			if !s.IsLeader() {
+				continue
 			}
-			if exp > minimumDurationForRenewal {
-				// we must renew the certificate, grab a lock
-				lockPath := fmt.Sprintf(certLockKey, s.domains[0])
-				if err := s.Lock(etcdClient, lockPath); err != nil {
-					if err == ErrLockExists {
-						// someone else grabbed the lock, wait for it to be unlocked
-						if err := s.WaitForLockDeletion(etcdClient, lockPath); err != nil {
-							log.Printf("error while waiting for the lock to be unlocked: %s", err)
-							goto nextChange
-						}
-					}
-				} else {
-					// lock was grabbed, renew the certificate
-					if err := cert.Renew(acmeClient, !s.NoBundle); err != nil {
-						log.Printf("error while renewing the certificate: %s", err)
-						goto nextChange
-					}
-					// save the certificate
-					if err := cert.Save(etcdClient, s.generatePEM); err != nil {
-						log.Printf("error saving the certificate: %s", err)
-						goto nextChange
+			s.forEachCert(certs, func(mc *managedCert) {
+				s.renewIfNecessary(kapi, store, mc, false)
+			})
+		case req := <-s.checkChan():
+			s.forEachCert(certs, func(mc *managedCert) {
+				if req.reload {
+					if err := s.resyncCert(store, mc); err != nil {
+						s.healIfNecessary(kapi, store, mc, err)
 					}
+					return
 				}
+				s.renewIfNecessary(kapi, store, mc, req.force)
+			})
+		case <-r.C():
+			if !s.IsLeader() {
+				continue
 			}
+			s.forEachCert(certs, func(mc *managedCert) {
+				s.reissueIfRevoked(kapi, store, mc)
+			})
 		case <-s.StopChan:
+			if s.SystemdNotify {
+				sdNotify("STOPPING=1")
+			}
 			return nil
 		}
-	nextChange:
-		// this is the end of for, so the loop will start again.
 	}
 }
 
-func (s *Service) generateCertificateIfNecessary(etcdClient client.Client, acmeClient *legoetcd.Client) (*legoetcd.Cert, error) {
-	// try loading the certificate
-	log.Printf("loading the certificates for %v from etcd", s.domains)
-	cert, err := legoetcd.LoadCert(etcdClient, s.domains)
-	if err == nil {
-		return cert, nil
+// AccountLockPath returns the path of the lock guarding the creation and the
+// changes of the account of email.
+func AccountLockPath(email string) string {
+	return fmt.Sprintf(accountLockKey, email)
+}
+
+// CertLockPath returns the path of the lock guarding the issuance and the
+// renewal of the certificate stored under domain.
+func CertLockPath(domain string) string {
+	return fmt.Sprintf(certLockKey, legoetcd.StorageName(domain))
+}
+
+func (s *Service) revocationCheckInterval() time.Duration {
+	if s.RevocationCheckInterval > 0 {
+		return s.RevocationCheckInterval
 	}
-	// we do not have a certificate, create a lock and create it - or wait for
-	// another process to do so.
-	log.Print("certificates were not found in etcd, fetching new ones")
-	lockPath := fmt.Sprintf(certLockKey, s.domains[0])
-	// try to grab a lock
-	if err := s.Lock(etcdClient, lockPath); err != nil {
-		if err == ErrLockExists {
-			// someone else grabbed the key, wait for it to be unlocked
-			if err := s.WaitForLockDeletion(etcdClient, lockPath); err != nil {
-				return nil, err
+	return defaultRevocationInterval
+}
+
+func (s *Service) clock() legoetcd.Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+	return legoetcd.DefaultClock
+}
+
+func (s *Service) resyncInterval() time.Duration {
+	if s.ResyncInterval > 0 {
+		return s.ResyncInterval
+	}
+	return defaultResyncInterval
+}
+
+func (s *Service) historyRetention() int {
+	if s.HistoryRetention == 0 {
+		return legoetcd.DefaultHistoryRetention
+	}
+	return s.HistoryRetention
+}
+
+func (s *Service) newStore(kapi legoetcd.KeysAPI) (legoetcd.Store, error) {
+	store := legoetcd.NewV2StoreKeys(kapi)
+	if s.EtcdV3Config == nil {
+		return store, nil
+	}
+	etcdV3Client, err := clientv3.New(*s.EtcdV3Config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating a new etcd v3 client: %s", err)
+	}
+	s.etcdV3Client = etcdV3Client
+	return legoetcd.NewDualStore(store, legoetcd.NewV3Store(etcdV3Client)), nil
+}
+
+func (s *Service) newACMEClient(store legoetcd.Store, challenge legoetcd.ChallengeConfig, email, acmeServer string, keyType acme.KeyType) (*legoetcd.Client, error) {
+	// create a new ACME client
+	// TODO: httpAddr and tlsAddr support
+	acmeClient, err := legoetcd.New(store, acmeServer, email, keyType, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("error creating a new ACME server: %s", err)
+	}
+	acmeClient.MustStaple = s.MustStaple
+	acmeClient.PreferredChain = s.PreferredChain
+	acmeClient.ReuseKey = s.ReuseKey
+	acmeClient.ReuseExistingFor = s.ReuseExistingFor
+	acmeClient.HostPolicy = s.HostPolicy
+	if len(s.CAAIdentities) > 0 {
+		acmeClient.CAAIdentities = s.CAAIdentities
+	}
+	if s.Retry != nil {
+		acmeClient.Retry = *s.Retry
+	}
+	acmeClient.Clock = s.Clock
+	acmeClient.Authorizer = s.Authorizer
+	if s.RateLimit {
+		acmeClient.RateLimiter = legoetcd.NewRateLimiter(store)
+	}
+	acmeClient.Requester = legoetcd.DefaultRequester()
+	acmeClient.Source = "service"
+	// register the account and accept tos
+	log.Printf("registering the account %s with %s", email, acmeServer)
+	if err := acmeClient.RegisterAccount(store, s.acceptTOS); err != nil {
+		if err == legoetcd.ErrMustAcceptTOS {
+			return nil, ErrTOSNotAccepted
+		}
+		return nil, fmt.Errorf("error registering the account: %s", err)
+	}
+	return acmeClient, nil
+}
+
+// watchCert publishes the changes to the certificate on CertChan. The watch
+// resumes from the last index seen after an error, backing off while etcd is
+// unavailable, and starts over from the current index, after re-reading the
+// certificate, if etcd already cleared that index from its history. The
+// certificate is also reloaded every ResyncInterval in case a change is missed.
+// A certificate found deleted or corrupt is obtained again, see
+// DisableAutoHeal.
+func (s *Service) watchCert(kapi legoetcd.KeysAPI, store legoetcd.Store, mc *managedCert) {
+	path := mc.current().CertPath()
+	// resume right after the revision the certificate was loaded at
+	index := uint64(mc.current().Revision)
+	w := kapi.Watcher(path, &client.WatcherOptions{AfterIndex: index})
+	backoff := minWatchBackoff
+	resync := s.clock().NewTicker(s.resyncInterval())
+	defer resync.Stop()
+	for {
+		s.beat(path)
+		done, exited := make(chan struct{}), make(chan struct{})
+		resyncDue := false
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		go func(done chan struct{}) {
+			defer close(exited)
+			// block until either a stop or a resync which cancel the context, or
+			// the end of the current next.
+			select {
+			case <-s.StopChan:
+				cancelFunc()
+			case <-resync.C():
+				resyncDue = true
+				cancelFunc()
+			case <-done:
 			}
+		}(done)
+		resp, err := w.Next(ctx)
+		cancelFunc()
+		close(done)
+		<-exited
+		select {
+		case <-s.StopChan:
+			return
+		default:
 		}
-	} else {
-		// lock was grabbed, create the new account.
-		defer s.Unlock(etcdClient, lockPath)
-		// create a new certificate for domains or csr.
-		var failures map[string]error
-		cert, failures = acmeClient.NewCert(s.domains, s.csrFile, !s.NoBundle)
-		if len(failures) > 0 {
-			for k, v := range failures {
-				log.Printf("[%s] Could not obtain certificates\n\t%s", k, v.Error())
+		if err != nil && resyncDue {
+			// the safety net, the watcher keeps its index
+			if err := s.resyncCert(store, mc); err != nil {
+				s.healIfNecessary(kapi, store, mc, err)
 			}
-			return nil, ErrGeneratingCert
+			continue
 		}
-		// save the certificate
-		if err := cert.Save(etcdClient, s.generatePEM); err != nil {
-			return nil, fmt.Errorf("error saving the certificate: %s", err)
+		if cerr, ok := err.(client.Error); ok && cerr.Code == client.ErrorCodeEventIndexCleared {
+			// the changes since index are lost, start over from now
+			log.Printf("the history of %q was cleared since index %d, re-reading it", path, index)
+			if index, err = s.currentIndex(kapi, path); err == nil {
+				serviceMetrics.Add("watch_restarts", 1)
+				w = kapi.Watcher(path, &client.WatcherOptions{AfterIndex: index})
+				if err := s.resyncCert(store, mc); err != nil {
+					s.healIfNecessary(kapi, store, mc, err)
+				}
+				continue
+			}
+		}
+		if err != nil {
+			s.setDegraded(fmt.Errorf("error fetching the next change to the certificate %q: %s", path, err))
+			// wait for etcd to come back instead of spinning on the error
+			select {
+			case <-s.clock().After(backoff):
+			case <-s.StopChan:
+				return
+			}
+			backoff *= 2
+			if backoff > maxWatchBackoff {
+				backoff = maxWatchBackoff
+			}
+			// resume from the last change we have seen
+			serviceMetrics.Add("watch_restarts", 1)
+			w = kapi.Watcher(path, &client.WatcherOptions{AfterIndex: index})
+			continue
+		}
+		backoff = minWatchBackoff
+		s.setHealthy()
+		if resp.Node != nil {
+			index = resp.Node.ModifiedIndex
+		}
+		switch resp.Action {
+		case "get":
+		case "delete", "expire":
+			s.healIfNecessary(kapi, store, mc, legoetcd.ErrKeyNotFound)
+		default:
+			if cert, _, err := mc.reload(store); err != nil {
+				log.Printf("error reloading the certificate: %s", err)
+				s.healIfNecessary(kapi, store, mc, err)
+			} else {
+				s.publish(cert, s.changeReason(cert))
+			}
+		}
+	}
+}
+
+// currentIndex returns the current index of etcd, read along with the key.
+func (s *Service) currentIndex(kapi legoetcd.KeyGetter, path string) (uint64, error) {
+	ctx, cancelFunc := legoetcd.DefaultBudgets.EtcdContext()
+	defer cancelFunc()
+	resp, err := kapi.Get(ctx, path, nil)
+	if err != nil {
+		if cerr, ok := err.(client.Error); ok && cerr.Code == client.ErrorCodeKeyNotFound {
+			return cerr.Index, nil
+		}
+		return 0, err
+	}
+	return resp.Index, nil
+}
+
+// save saves the certificate with the PEM and the PKCS#12 bundle the service
+// is configured to store.
+func (s *Service) save(store legoetcd.Store, cert *legoetcd.Cert) error {
+	cert.PKCS12Password = s.PKCS12Password
+	cert.SigningKey = s.SigningKey
+	return cert.Save(store, s.generatePEM)
+}
+
+// resyncCert reloads the certificate from etcd, and publishes it if it has
+// changed while the service was not watching it. It returns the error
+// reloading the certificate.
+func (s *Service) resyncCert(store legoetcd.Store, mc *managedCert) error {
+	cert, previous, err := mc.reload(store)
+	if err != nil {
+		s.setDegraded(err)
+		return err
+	}
+	s.setHealthy()
+	if !bytes.Equal(previous.Cert.Certificate, cert.Cert.Certificate) {
+		s.publish(cert, Reloaded)
+	}
+	return nil
+}
+
+// renewalDue returns whether the certificate must be renewed, within the
+// window suggested by the CA if it supports ARI or when it expires in less than
+// 45 days otherwise, or at its point of the RenewalSpread before that.
+func (s *Service) renewalDue(mc *managedCert) (bool, error) {
+	cert := mc.current()
+	window, err := mc.acmeClient.RenewalInfo(cert)
+	if err == nil {
+		if mc.renewWindow == nil || !mc.renewWindow.Start.Equal(window.Start) || !mc.renewWindow.End.Equal(window.End) {
+			// pick a random time in the window, spreading the renewals of the
+			// instances and of the certificates.
+			mc.renewWindow = window
+			mc.renewAt = window.Start.Add(time.Duration(rand.Int63n(int64(window.End.Sub(window.Start)) + 1)))
+			log.Printf("the CA suggests renewing the certificate for %v between %s and %s, renewing at %s", mc.spec.Domains, window.Start, window.End, mc.renewAt)
+		}
+		return !s.clock().Now().Before(mc.renewAt), nil
+	}
+	if err != legoetcd.ErrNoRenewalInfo {
+		log.Printf("was not able to query the renewal information of the certificate for %v, using its expiration date: %s", mc.spec.Domains, err)
+	}
+	if s.RenewalSpread > 0 {
+		renewAt, err := legoetcd.SmearedRenewalTime(cert, s.RenewalSpread, minimumDurationForRenewal)
+		if err != nil {
+			return false, err
+		}
+		return !s.clock().Now().Before(renewAt), nil
+	}
+	exp, err := cert.ExpiresInAt(s.clock().Now())
+	if err != nil {
+		return false, err
+	}
+	return exp < minimumDurationForRenewal, nil
+}
+
+func (s *Service) renewIfNecessary(kapi legoetcd.KeysAPI, store legoetcd.Store, mc *managedCert, force bool) {
+	// is a renewed certificate already waiting to be published?
+	mc.mu.Lock()
+	pending := mc.pending != nil
+	mc.mu.Unlock()
+	if pending {
+		return
+	}
+	// do we need to renew the certificate?
+	due := force
+	if !due {
+		var err error
+		if due, err = s.renewalDue(mc); err != nil {
+			log.Printf("was not able to query the certificate expiration date: %s", err)
+			return
+		}
+	}
+	if !due {
+		return
+	}
+	// we must renew the certificate, grab the lock - waiting for another
+	// process renewing it, or breaking its lock if it died.
+	lockPath := CertLockPath(mc.spec.Domains[0])
+	if err := s.AcquireLock(context.Background(), kapi, lockPath, &LockOptions{BreakStale: true}); err != nil {
+		log.Printf("error grabbing the lock of the certificate for %v: %s", mc.spec.Domains, err)
+		return
+	}
+	// the process we waited for may have renewed it
+	if _, _, err := mc.reload(store); err != nil {
+		s.Unlock(kapi, lockPath)
+		log.Printf("error reloading the certificate: %s", err)
+		return
+	}
+	if !force {
+		if due, err := s.renewalDue(mc); err != nil || !due {
+			s.Unlock(kapi, lockPath)
+			return
+		}
+	}
+	// lock was grabbed, renew a copy of the certificate as the watcher keeps
+	// reloading it in the meantime
+	serviceMetrics.Add("renewals", 1)
+	renewed := *mc.current()
+	if err := renewed.Renew(mc.acmeClient, !s.NoBundle); err != nil {
+		s.Unlock(kapi, lockPath)
+		serviceMetrics.Add("renewal_failures", 1)
+		log.Printf("error while renewing the certificate: %s", err)
+		s.notify(notify.EventRenewalFailed, mc.spec.Domains, nil, err)
+		s.scheduleRetry(mc, err)
+		return
+	}
+	delay := s.PublishDelay
+	if mc.spec.PublishDelay != nil {
+		delay = *mc.spec.PublishDelay
+	}
+	if delay <= 0 {
+		s.publishRenewed(kapi, store, mc, &renewed, lockPath)
+		return
+	}
+	// wait before publishing the certificate, without holding up the other
+	// certificates, the lock is held until it is saved.
+	log.Printf("waiting %s before publishing the certificate for %v", delay, mc.spec.Domains)
+	mc.setPending(&renewed)
+	after := s.clock().After(delay)
+	go func() {
+		select {
+		case <-after:
+			s.publishRenewed(kapi, store, mc, &renewed, lockPath)
+		case <-s.StopChan:
+			log.Printf("service stopped, the renewed certificate for %v was not published", mc.spec.Domains)
+			mc.setPending(nil)
+			s.Unlock(kapi, lockPath)
+		}
+	}()
+}
+
+// publishRenewed archives the previous certificate, saves the renewed one in
+// its place and releases the lock of the renewal.
+func (s *Service) publishRenewed(kapi legoetcd.KeysAPI, store legoetcd.Store, mc *managedCert, cert *legoetcd.Cert, lockPath string) {
+	defer s.Unlock(kapi, lockPath)
+	defer mc.setPending(nil)
+	// archive the previous certificate and save the new one
+	if err := cert.Archive(store, s.historyRetention()); err != nil {
+		log.Printf("error archiving the previous certificate for %v: %s", mc.spec.Domains, err)
+	}
+	if err := s.saveOwn(store, cert); err != nil {
+		if err == legoetcd.ErrConflict {
+			log.Printf("the certificate for %v was replaced by someone else while renewing it, keeping theirs", mc.spec.Domains)
+			s.resyncCert(store, mc)
+			return
+		}
+		log.Printf("error saving the certificate: %s", err)
+		s.notify(notify.EventRenewalFailed, mc.spec.Domains, nil, err)
+		return
+	}
+	mc.set(cert)
+	s.certChanged(notify.EventRenewed, mc.spec.Domains, cert)
+	if mc.spec.VerifyEndpoint != "" {
+		go s.verifyDeployment(mc.spec, cert)
+	}
+}
+
+// scheduleRetry checks the certificates again when the rate limit the renewal
+// hit lifts, rather than on the next tick.
+func (s *Service) scheduleRetry(mc *managedCert, err error) {
+	retryAfter, ok := legoetcd.RetryAfter(err)
+	if !ok {
+		return
+	}
+	if mc.retryTimer != nil {
+		mc.retryTimer.Stop()
+	}
+	log.Printf("the renewal of the certificate for %v is rate limited, retrying at %s", mc.spec.Domains, retryAfter)
+	mc.retryTimer = s.clock().AfterFunc(retryAfter.Sub(s.clock().Now()), func() { s.Check(false) })
+}
+
+// verifyDeployment notifies an EventDeploymentFailed if the endpoint of the
+// spec does not serve the renewed certificate in time.
+func (s *Service) verifyDeployment(spec CertSpec, cert *legoetcd.Cert) {
+	timeout := s.DeploymentTimeout
+	if timeout <= 0 {
+		timeout = defaultDeploymentTimeout
+	}
+	if err := cert.VerifyDeployment(spec.VerifyEndpoint, timeout); err != nil {
+		log.Printf("the renewed certificate for %v is not deployed: %s", spec.Domains, err)
+		s.notify(notify.EventDeploymentFailed, spec.Domains, cert, err)
+	}
+}
+
+func (s *Service) reissueIfRevoked(kapi legoetcd.KeysAPI, store legoetcd.Store, mc *managedCert) {
+	// was the certificate revoked?
+	revoked, err := mc.current().Revoked()
+	if err != nil {
+		log.Printf("was not able to check the revocation status of the certificate for %v: %s", mc.spec.Domains, err)
+		return
+	}
+	if !revoked {
+		return
+	}
+	log.Printf("the certificate for %v was revoked, obtaining a new one", mc.spec.Domains)
+	lockPath := CertLockPath(mc.spec.Domains[0])
+	if s.holdsLock(lockPath) {
+		// this service is replacing the certificate already
+		return
+	}
+	if err := s.AcquireLock(context.Background(), kapi, lockPath, &LockOptions{BreakStale: true}); err != nil {
+		log.Printf("error grabbing the lock of the certificate for %v: %s", mc.spec.Domains, err)
+		return
+	}
+	defer s.Unlock(kapi, lockPath)
+	// another instance may have replaced it while we were checking
+	current, _, err := mc.reload(store)
+	if err != nil {
+		log.Printf("error reloading the certificate: %s", err)
+		return
+	}
+	if revoked, err := current.Revoked(); err == nil && !revoked {
+		return
+	}
+	// obtain a new certificate, with a new key as the old one may be the
+	// reason of the revocation.
+	cert, err := s.obtainCert(mc.acmeClient, mc.spec)
+	if err != nil {
+		log.Printf("error while replacing the revoked certificate: %s", err)
+		return
+	}
+	// compare against the revision we checked, not to replace a certificate
+	// saved by someone else in the meantime.
+	cert.Revision = current.Revision
+	// archive the revoked certificate for the record
+	if err := cert.Archive(store, s.historyRetention()); err != nil {
+		log.Printf("error archiving the revoked certificate for %v: %s", mc.spec.Domains, err)
+	}
+	// save the certificate, the watcher publishes it on CertChan.
+	if err := s.saveOwn(store, cert); err != nil {
+		log.Printf("error saving the certificate: %s", err)
+		return
+	}
+	mc.set(cert)
+	s.certChanged(notify.EventRevoked, mc.spec.Domains, cert)
+}
+
+// certChanged runs the RenewHook and notifies the Notifiers after the
+// certificate was saved.
+func (s *Service) certChanged(event notify.Event, domains []string, cert *legoetcd.Cert) {
+	if s.RenewHook != "" {
+		if err := legoetcd.RunHook(s.RenewHook, string(event), cert); err != nil {
+			log.Print(err)
+		}
+	}
+	s.notify(event, domains, cert, nil)
+}
+
+// notify records the event for the admin API and in the event log, and sends
+// it to the Notifiers.
+func (s *Service) notify(event notify.Event, domains []string, cert *legoetcd.Cert, err error) {
+	var notAfter time.Time
+	if cert != nil {
+		notAfter, _ = cert.Expiration()
+	}
+	n := notify.NewNotification(event, domains, notAfter, err)
+	s.recordEvent(n)
+	if s.eventLog != nil {
+		if err := s.eventLog.Notify(n); err != nil {
+			log.Printf("error recording the %s event for %v: %s", event, domains, err)
 		}
 	}
+	notify.NotifyAll(s.Notifiers, n)
+}
+
+func (s *Service) generateCertificateIfNecessary(kapi legoetcd.KeysAPI, store legoetcd.Store, acmeClient *legoetcd.Client, spec CertSpec) (*legoetcd.Cert, error) {
+	// try loading the certificate
+	log.Printf("loading the certificates for %v from etcd", spec.Domains)
+	cert, err := legoetcd.LoadCert(store, spec.Domains)
+	if err == nil {
+		return cert, nil
+	}
+	if !s.mustObtain(err) {
+		return nil, fmt.Errorf("error loading the certificate for %v: %s", spec.Domains, err)
+	}
+	// we do not have a certificate, grab the lock and create it - waiting for
+	// another process doing so, or breaking its lock if it died.
+	log.Print("certificates were not found in etcd, fetching new ones")
+	lockPath := CertLockPath(spec.Domains[0])
+	if err := s.AcquireLock(context.Background(), kapi, lockPath, &LockOptions{BreakStale: true}); err != nil {
+		return nil, err
+	}
+	defer s.Unlock(kapi, lockPath)
+	// the process we waited for may have created it
+	if cert, err := legoetcd.LoadCert(store, spec.Domains); err == nil {
+		return cert, nil
+	} else if !s.mustObtain(err) {
+		return nil, fmt.Errorf("error loading the certificate for %v: %s", spec.Domains, err)
+	}
+	// create a new certificate for domains or csr.
+	cert, err = s.obtainCert(acmeClient, spec)
+	if err != nil {
+		return nil, err
+	}
+	// save the certificate
+	if err := s.save(store, cert); err != nil {
+		return nil, fmt.Errorf("error saving the certificate: %s", err)
+	}
+	s.certChanged(notify.EventObtained, spec.Domains, cert)
 	// finally make sure we can load the cert and return it
-	if err := cert.Reload(etcdClient); err != nil {
+	if err := cert.Reload(store); err != nil {
 		return nil, fmt.Errorf("was expecting the certificate to be saved: %s", err)
 	}
 	return cert, nil
 }
 
-func (s *Service) createAccountIfNecessary(etcdClient client.Client) error {
+// mustObtain returns whether the error loading a certificate means a new one
+// must be obtained: it was never obtained, or its data is corrupt and is healed
+// unless DisableAutoHeal is set. Any other error, etcd being unavailable for
+// instance, does not tell whether the certificate exists.
+func (s *Service) mustObtain(err error) bool {
+	return legoetcd.IsKeyNotFound(err) || (!s.DisableAutoHeal && needsHealing(err))
+}
+
+// obtainCert obtains a new certificate for the spec.
+func (s *Service) obtainCert(acmeClient *legoetcd.Client, spec CertSpec) (*legoetcd.Cert, error) {
+	var (
+		cert     *legoetcd.Cert
+		failures map[string]error
+	)
+	switch {
+	case spec.SPIFFEID != "":
+		cert, failures = acmeClient.NewSPIFFECert(spec.Domains, spec.SPIFFEID, !s.NoBundle)
+	case spec.CSR != nil:
+		cert, failures = acmeClient.NewCertFromConfig(spec.CSR, !s.NoBundle)
+	default:
+		cert, failures = acmeClient.NewCert(spec.Domains, spec.CSRFile, !s.NoBundle)
+	}
+	if len(failures) > 0 {
+		for k, v := range failures {
+			log.Printf("[%s] Could not obtain certificates\n\t%s", k, v.Error())
+		}
+		return nil, ErrGeneratingCert
+	}
+	return cert, nil
+}
+
+func (s *Service) createAccountIfNecessary(kapi legoetcd.KeysAPI, store legoetcd.Store, email, acmeServer string) error {
 	// do we have an account?
-	acc := legoetcd.NewAccount(s.email)
-	log.Printf("loading the account from etcd: %s", s.email)
-	err := acc.Load(etcdClient)
+	acc := legoetcd.NewDirectoryAccount(email, acmeServer)
+	log.Printf("loading the account from etcd: %s", email)
+	err := acc.Load(store)
 	if err == nil {
 		// ok we have an account, short-circuit out of this func
 		return nil
 	}
 	// we got an error, is it a not-found error (means account does not exist)?
-	if client.IsKeyNotFound(err) {
+	if legoetcd.IsKeyNotFound(err) {
+		// the key is shared by the directories, the account may only miss its
+		// registration with this one which is done by RegisterAccount.
+		if err := acc.LoadKey(store); err == nil {
+			return nil
+		}
 		log.Print("account not found in etcd, creating one")
-		// we do not have an account, create a lock and create it - or wait for
-		// another process to do so.
-		lockPath := fmt.Sprintf(accountLockKey, s.email)
-		if err := s.Lock(etcdClient, lockPath); err != nil {
-			if err == ErrLockExists {
-				// someone else grabbed the key, wait for it to be unlocked
-				if err := s.WaitForLockDeletion(etcdClient, lockPath); err != nil {
-					return err
-				}
+		// we do not have an account, grab the lock and create it - waiting
+		// for another process doing so, or breaking its lock if it died.
+		lockPath := fmt.Sprintf(accountLockKey, email)
+		if err := s.AcquireLock(context.Background(), kapi, lockPath, &LockOptions{BreakStale: true}); err != nil {
+			return err
+		}
+		defer s.Unlock(kapi, lockPath)
+		// the process we waited for may have created it
+		if err := acc.LoadKey(store); err != nil {
+			if !legoetcd.IsKeyNotFound(err) {
+				return err
 			}
-		} else {
-			// lock was grabbed, create the new account.
-			defer s.Unlock(etcdClient, lockPath)
 			if err := acc.GenerateKey(); err != nil {
 				return err
 			}
-			if err := acc.Save(etcdClient); err != nil {
+			if err := acc.Save(store); err != nil {
 				return err
 			}
 		}
 		// finally make sure we can load the account (we just need the key actually).
-		if err := acc.LoadKey(etcdClient); err != nil {
+		if err := acc.LoadKey(store); err != nil {
 			return fmt.Errorf("was expecting the account to have a key: %s", err)
 		}
 
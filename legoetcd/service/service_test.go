@@ -0,0 +1,230 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+func TestScheduleRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		// retryAfters are the rate limits hit by the renewals, in order
+		retryAfters []time.Duration
+		// want is when the certificates are checked again
+		want time.Duration
+	}{
+		{"rate limited", []time.Duration{time.Hour}, time.Hour},
+		{"rate limited again", []time.Duration{time.Hour, 2 * time.Hour}, 2 * time.Hour},
+		{"rate limited again sooner", []time.Duration{2 * time.Hour, time.Hour}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := legoetcdtest.NewClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+			s := &Service{Clock: clock}
+			mc := &managedCert{spec: CertSpec{Domains: []string{"example.com"}}}
+			for _, retryAfter := range tt.retryAfters {
+				s.scheduleRetry(mc, &legoetcd.RateLimitError{Limit: "certificates per domain", RetryAfter: clock.Now().Add(retryAfter)})
+			}
+			clock.Advance(tt.want - time.Second)
+			select {
+			case <-s.checkChan():
+				t.Fatal("want no check before the rate limit lifts")
+			case <-time.After(10 * time.Millisecond):
+			}
+			clock.Advance(time.Second)
+			select {
+			case <-s.checkChan():
+			case <-time.After(5 * time.Second):
+				t.Fatal("want a check once the rate limit lifted")
+			}
+		})
+	}
+}
+
+func TestScheduleRetryNotRateLimited(t *testing.T) {
+	clock := legoetcdtest.NewClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := &Service{Clock: clock}
+	mc := &managedCert{spec: CertSpec{Domains: []string{"example.com"}}}
+	s.scheduleRetry(mc, errors.New("the DNS provider is unavailable"))
+	if mc.retryTimer != nil {
+		t.Error("want no retry scheduled")
+	}
+}
+
+func TestStatusSince(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := legoetcdtest.NewClock(start)
+	s := &Service{Clock: clock}
+
+	clock.Advance(time.Minute)
+	s.setDegraded(errors.New("etcd is unavailable"))
+	if status := s.Status(); !status.Degraded || !status.Since.Equal(start.Add(time.Minute)) {
+		t.Fatalf("want degraded since %s, got %+v", start.Add(time.Minute), status)
+	}
+	// only the first failure changes the state
+	clock.Advance(time.Minute)
+	s.setDegraded(errors.New("etcd is still unavailable"))
+	if status := s.Status(); !status.Since.Equal(start.Add(time.Minute)) {
+		t.Fatalf("want degraded since %s, got %+v", start.Add(time.Minute), status)
+	}
+
+	clock.Advance(time.Minute)
+	s.setHealthy()
+	if status := s.Status(); status.Degraded || !status.Since.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("want healthy since %s, got %+v", start.Add(3*time.Minute), status)
+	}
+}
+
+// unavailableStore is a Store failing to read the keys, like an unavailable
+// etcd.
+type unavailableStore struct {
+	legoetcd.Store
+}
+
+var errUnavailable = errors.New("etcd is unavailable")
+
+func (unavailableStore) Get(ctx context.Context, key string) (string, error) {
+	return "", errUnavailable
+}
+
+func (unavailableStore) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	return "", 0, errUnavailable
+}
+
+func TestGenerateCertificateIfNecessary(t *testing.T) {
+	spec := CertSpec{Domains: []string{"example.com"}}
+	kapi := legoetcdtest.NewKeysAPI()
+	store := legoetcdtest.NewStore()
+	s := &Service{}
+
+	// no new certificate is obtained, the ACME client is nil, while the
+	// existing one cannot be read
+	if _, err := s.generateCertificateIfNecessary(kapi, unavailableStore{store}, nil, spec); err == nil {
+		t.Error("want the error loading the certificate")
+	}
+	if _, err := ReadLock(kapi, CertLockPath(spec.Domains[0])); !legoetcd.IsKeyNotFound(err) {
+		t.Errorf("want the lock of the certificate not grabbed, got %v", err)
+	}
+
+	seeded, err := legoetcdtest.SeedCert(store, spec.Domains, 90*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := s.generateCertificateIfNecessary(kapi, store, nil, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Cert.Certificate) != string(seeded.Cert.Certificate) {
+		t.Error("want the existing certificate loaded")
+	}
+}
+
+func TestRenewalDue(t *testing.T) {
+	ca, err := legoetcdtest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.Issue([]string{"example.com"}, 90*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := legoetcdtest.NewClock(time.Now())
+	s := &Service{Clock: clock}
+	// the CA does not suggest a renewal window, the expiration date is used
+	mc := &managedCert{spec: CertSpec{Domains: []string{"example.com"}}, cert: cert, acmeClient: &legoetcd.Client{}}
+	for _, step := range []struct {
+		advance time.Duration
+		want    bool
+	}{
+		{0, false},
+		{44 * 24 * time.Hour, false},
+		{2 * 24 * time.Hour, true},
+	} {
+		clock.Advance(step.advance)
+		due, err := s.renewalDue(mc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if due != step.want {
+			t.Errorf("at %s: want the renewal due %t, got %t", clock.Now(), step.want, due)
+		}
+	}
+}
+
+func TestPublishRenewed(t *testing.T) {
+	ca, err := legoetcdtest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(store legoetcd.Store) *legoetcd.Cert {
+		cert, err := ca.Issue([]string{"example.com"}, 90*24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if store == nil {
+			return cert
+		}
+		if err := cert.Save(store, false); err != nil {
+			t.Fatal(err)
+		}
+		// load it back at the revision it was saved at
+		if cert, err = legoetcd.LoadCert(store, cert.Domains); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	for _, replaced := range []bool{false, true} {
+		kapi := legoetcdtest.NewKeysAPI()
+		store := legoetcdtest.NewStore()
+		s := &Service{}
+		mc := &managedCert{spec: CertSpec{Domains: []string{"example.com"}}, cert: issue(store)}
+		lockPath := CertLockPath("example.com")
+		if err := s.Lock(kapi, lockPath); err != nil {
+			t.Fatal(err)
+		}
+		// renew a copy of the certificate, while someone else may replace it
+		renewed := *mc.current()
+		fresh := issue(nil)
+		renewed.Cert.Certificate, renewed.Cert.PrivateKey = fresh.Cert.Certificate, fresh.Cert.PrivateKey
+		want := &renewed
+		if replaced {
+			if want, err = legoetcd.LoadCert(store, mc.spec.Domains); err != nil {
+				t.Fatal(err)
+			}
+			theirs := issue(nil)
+			want.Cert.Certificate, want.Cert.PrivateKey = theirs.Cert.Certificate, theirs.Cert.PrivateKey
+			if err := want.Save(store, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mc.setPending(&renewed)
+
+		s.publishRenewed(kapi, store, mc, &renewed, lockPath)
+		saved, err := legoetcd.LoadCert(store, mc.spec.Domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(saved.Cert.Certificate, want.Cert.Certificate) {
+			t.Errorf("replaced %t: want the certificate saved in etcd kept, got another one", replaced)
+		}
+		if current := mc.current(); !bytes.Equal(current.Cert.Certificate, want.Cert.Certificate) {
+			t.Errorf("replaced %t: want the certificate saved in etcd served, got another one", replaced)
+		}
+		if mc.pending != nil {
+			t.Errorf("replaced %t: want no certificate pending", replaced)
+		}
+		if s.holdsLock(lockPath) {
+			t.Errorf("replaced %t: want the lock released", replaced)
+		}
+		if _, err := ReadLock(kapi, lockPath); !legoetcd.IsKeyNotFound(err) {
+			t.Errorf("replaced %t: want the lock removed from etcd, got %v", replaced, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// checkRequest asks the loop of the service to check the certificates right
+// away.
+type checkRequest struct {
+	force bool
+	// reload re-reads the certificates from etcd instead.
+	reload bool
+}
+
+// Check asks the running service to check the expiration of its certificates
+// right away instead of waiting for the next tick, renewing all of them if
+// force is true. The check runs even if another service is the leader. It
+// does not block, a check already pending absorbs the request.
+func (s *Service) Check(force bool) {
+	select {
+	case s.checkChan() <- checkRequest{force: force}:
+	default:
+		log.Print("a check of the certificates is already pending")
+	}
+}
+
+// Reload asks the running service to re-read its certificates from etcd right
+// away, publishing those which changed. It does not block.
+func (s *Service) Reload() {
+	select {
+	case s.checkChan() <- checkRequest{reload: true}:
+	default:
+		log.Print("a check of the certificates is already pending")
+	}
+}
+
+// Stop stops the service gracefully, the renewal in progress if any completes
+// and its lock is released before Run returns. It is safe to call Stop more
+// than once.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() { close(s.StopChan) })
+}
+
+func (s *Service) checkChan() chan checkRequest {
+	s.checksOnce.Do(func() { s.checks = make(chan checkRequest, 1) })
+	return s.checks
+}
+
+// handleSignals checks the certificates on SIGHUP and stops the service on
+// SIGTERM and SIGINT.
+func (s *Service) handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(c)
+	for {
+		select {
+		case sig := <-c:
+			if sig == syscall.SIGHUP {
+				log.Printf("received %s, checking the certificates", sig)
+				s.Check(s.ForceRenewOnHUP)
+				continue
+			}
+			log.Printf("received %s, stopping", sig)
+			s.Stop()
+			return
+		case <-s.StopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// minWatchBackoff and maxWatchBackoff bound the time between two attempts
+	// to watch a certificate while etcd is unavailable.
+	minWatchBackoff = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+var (
+	// serviceMetrics exposes the health of the services through expvar.
+	serviceMetrics = expvar.NewMap("legoetcd_service")
+	degradedGauge  = new(expvar.Int)
+)
+
+func init() {
+	serviceMetrics.Set("degraded", degradedGauge)
+}
+
+// Status is the health of the service.
+type Status struct {
+	// Degraded is true while etcd is unavailable, the service keeps serving
+	// the last certificates it received.
+	Degraded bool `json:"degraded"`
+	// Since is the time the service entered its current state.
+	Since time.Time `json:"since"`
+	// LastError is the last error returned by etcd while degraded.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Status returns the health of the service.
+func (s *Service) Status() Status {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.status
+}
+
+// HealthHandler returns the handler of the health checks of the orchestrators,
+// answering the Status as JSON with 200 OK, or with 503 Service Unavailable
+// while the service is degraded. Unlike the AdminHandler, it may be exposed
+// to the probes.
+func (s *Service) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := s.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if status.Degraded {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("error writing the health response: %s", err)
+		}
+	})
+}
+
+// setDegraded records an etcd failure, only the first failure is logged.
+func (s *Service) setDegraded(err error) {
+	serviceMetrics.Add("etcd_errors", 1)
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.LastError = err.Error()
+	if s.status.Degraded {
+		return
+	}
+	log.Printf("etcd is unavailable, serving the last known certificates: %s", err)
+	s.status.Degraded = true
+	s.status.Since = s.clock().Now()
+	degradedGauge.Add(1)
+}
+
+// setHealthy records that etcd is available again.
+func (s *Service) setHealthy() {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if !s.status.Degraded {
+		return
+	}
+	log.Printf("etcd is available again after %s", s.clock().Now().Sub(s.status.Since))
+	s.status = Status{Since: s.clock().Now()}
+	degradedGauge.Add(-1)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"log"
+	"sync"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+)
+
+// subscriberBuffer is the number of events queued for a subscriber, the
+// oldest events of a subscriber falling further behind are dropped.
+const subscriberBuffer = 16
+
+type subscriber struct {
+	mu     sync.Mutex
+	events chan CertEvent
+	closed bool
+}
+
+// Subscribe returns a channel receiving the certificate events of the service,
+// starting with an Initial event for each certificate already published, and
+// a function to unsubscribe which closes the channel. The events are buffered
+// for each subscriber so a slow subscriber never blocks the service nor the
+// other subscribers, its oldest events are dropped if it falls too far behind.
+// The service keeps sending on CertChan as well, set it to nil when only
+// consuming the events with Subscribe.
+func (s *Service) Subscribe() (<-chan CertEvent, func()) {
+	sub := &subscriber{events: make(chan CertEvent, subscriberBuffer)}
+	s.subsMu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[*subscriber]struct{})
+	}
+	s.subs[sub] = struct{}{}
+	for _, spec := range s.certs {
+		if cert, ok := s.published[spec.Domains[0]]; ok {
+			sub.send(CertEvent{Cert: cert, Reason: Initial})
+		}
+	}
+	s.subsMu.Unlock()
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			s.subsMu.Lock()
+			delete(s.subs, sub)
+			s.subsMu.Unlock()
+			sub.close()
+		})
+	}
+}
+
+// broadcast sends the event to the subscribers.
+func (s *Service) broadcast(ev CertEvent) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.published == nil {
+		s.published = make(map[string]*legoetcd.Cert)
+	}
+	s.published[ev.Cert.Domains[0]] = ev.Cert
+	for sub := range s.subs {
+		sub.send(ev)
+	}
+}
+
+// Latest returns the last certificate published for the domain it is stored
+// under, or nil if none was published yet. It never blocks on the consumers.
+func (s *Service) Latest(domain string) *legoetcd.Cert {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	return s.published[domain]
+}
+
+// send queues the event, dropping the oldest one if the buffer is full.
+func (sub *subscriber) send(ev CertEvent) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	if old, dropped := offer(sub.events, ev); dropped {
+		log.Printf("a subscriber fell behind, dropped the %s event of the certificate for %v", old.Reason, old.Cert.Domains)
+	}
+}
+
+// offer sends the event without blocking, dropping the oldest event queued if
+// ch is full, or the event itself if ch is unbuffered and nobody is receiving.
+// It returns the dropped event. The callers must not offer concurrently on the
+// same channel.
+func offer(ch chan CertEvent, ev CertEvent) (CertEvent, bool) {
+	select {
+	case ch <- ev:
+		return CertEvent{}, false
+	default:
+	}
+	if cap(ch) == 0 {
+		return ev, true
+	}
+	var (
+		old     CertEvent
+		dropped bool
+	)
+	select {
+	case old = <-ch:
+		dropped = true
+	default:
+		// the consumer made room in the meantime
+	}
+	// only the consumer touches ch in the meantime, there is room now
+	ch <- ev
+	return old, dropped
+}
+
+func (sub *subscriber) close() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.closed = true
+	close(sub.events)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends the state to systemd if the service was started with
+// Type=notify, it does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the interval of the systemd watchdog, or zero if
+// it is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// beat records that the watcher of the certificate is alive.
+func (s *Service) beat(path string) {
+	s.beatsMu.Lock()
+	defer s.beatsMu.Unlock()
+	if s.beats == nil {
+		s.beats = make(map[string]time.Time)
+	}
+	s.beats[path] = time.Now()
+}
+
+// watchersAlive returns true if all the watchers went through their loop
+// recently, they wake up at least every ResyncInterval.
+func (s *Service) watchersAlive() bool {
+	s.beatsMu.Lock()
+	defer s.beatsMu.Unlock()
+	for path, beat := range s.beats {
+		if time.Since(beat) > 2*s.resyncInterval() {
+			log.Printf("the watcher of %q is stuck since %s", path, beat)
+			return false
+		}
+	}
+	return true
+}
+
+// watchdog pings the systemd watchdog as long as the watchers are alive, so
+// systemd restarts the service if one of them wedges.
+func (s *Service) watchdog(interval time.Duration) {
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if !s.watchersAlive() {
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("error notifying the systemd watchdog: %s", err)
+			}
+		case <-s.StopChan:
+			return
+		}
+	}
+}
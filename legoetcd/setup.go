@@ -3,20 +3,12 @@ package legoetcd
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/coreos/etcd/client"
 	"github.com/xenolf/lego/acme"
-	"github.com/xenolf/lego/providers/dns/cloudflare"
-	"github.com/xenolf/lego/providers/dns/digitalocean"
-	"github.com/xenolf/lego/providers/dns/dnsimple"
-	"github.com/xenolf/lego/providers/dns/dyn"
-	"github.com/xenolf/lego/providers/dns/gandi"
-	"github.com/xenolf/lego/providers/dns/googlecloud"
-	"github.com/xenolf/lego/providers/dns/namecheap"
-	"github.com/xenolf/lego/providers/dns/rfc2136"
-	"github.com/xenolf/lego/providers/dns/route53"
-	"github.com/xenolf/lego/providers/dns/vultr"
 	"github.com/xenolf/lego/providers/http/webroot"
 )
 
@@ -26,12 +18,166 @@ var (
 	ErrAddressInvalid = errors.New("the address should be host:port")
 )
 
-func (c *Client) setupAccount(ec client.Client, email string) error {
+// ChallengeConfig describes how the ACME challenges are solved for a
+// certificate.
+type ChallengeConfig struct {
+	// DNS is the name of the DNS provider used to solve DNS challenges.
+	DNS string
+	// WebRoot is the folder where the HTTP challenges are written to, in
+	// .well-known/acme-challenge.
+	WebRoot string
+	// HTTPEtcd presents the HTTP challenges in etcd, for the
+	// HTTPChallengeHandler mounted by the frontends of the domains, instead
+	// of listening on HTTPAddr.
+	HTTPEtcd bool
+	// HTTPAddr is the interface:port to listen on for HTTP challenges.
+	HTTPAddr string
+	// TLSAddr is the interface:port to listen on for TLS challenges.
+	TLSAddr string
+	// DNSTimeout is the maximum duration to wait for the DNS record to
+	// propagate, zero uses the ChallengeWait budget or the provider's default.
+	DNSTimeout time.Duration
+	// DNSPollInterval is the interval between two DNS propagation checks, zero
+	// uses the provider's default.
+	DNSPollInterval time.Duration
+	// DNSDisablePreCheck skips checking the propagation of the DNS record
+	// before notifying the ACME server.
+	DNSDisablePreCheck bool
+	// DNSAlias is the domain the DNS records are created on, for domains whose
+	// _acme-challenge record is a CNAME to _acme-challenge.<DNSAlias>.
+	DNSAlias string
+	// DNSFollowCNAME finds the domain the DNS records are created on by
+	// following the CNAME of the _acme-challenge record of each domain.
+	DNSFollowCNAME bool
+}
+
+// timeoutProvider overrides the DNS propagation timeout and polling interval
+// of a challenge provider.
+type timeoutProvider struct {
+	acme.ChallengeProvider
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// Timeout implements acme.ChallengeProviderTimeout
+func (p *timeoutProvider) Timeout() (timeout, interval time.Duration) {
+	return p.timeout, p.interval
+}
+
+// providerTimeout returns the timeout and interval of the provider, or lego's
+// defaults if the provider does not have its own.
+func providerTimeout(provider acme.ChallengeProvider) (timeout, interval time.Duration) {
+	if p, ok := provider.(acme.ChallengeProviderTimeout); ok {
+		return p.Timeout()
+	}
+	return 60 * time.Second, 2 * time.Second
+}
+
+func withTimeout(provider acme.ChallengeProvider, timeout, interval time.Duration) acme.ChallengeProvider {
+	if timeout == 0 && interval == 0 {
+		return provider
+	}
+	t, i := providerTimeout(provider)
+	if timeout != 0 {
+		t = timeout
+	}
+	if interval != 0 {
+		i = interval
+	}
+	return &timeoutProvider{ChallengeProvider: provider, timeout: t, interval: i}
+}
+
+// ConfigureDNSResolvers sets the recursive nameservers (host:port, the port
+// defaults to 53) used by the DNS challenges to find the zones and check the
+// propagation of the records. They are global to lego, so they apply to all
+// the clients: like the budgets, it must be called before creating them.
+func ConfigureDNSResolvers(resolvers []string) {
+	if len(resolvers) == 0 {
+		return
+	}
+	var nameservers []string
+	for _, resolver := range resolvers {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(resolver, "53")
+		}
+		nameservers = append(nameservers, resolver)
+	}
+	acme.RecursiveNameservers = nameservers
+}
+
+var (
+	// installPreCheck replaces the PreCheckDNS of lego, global to the
+	// process, with preCheckDNS the first time a client disables it.
+	installPreCheck sync.Once
+	legoPreCheckDNS func(fqdn, value string) (bool, error)
+
+	skippedPreChecksMu sync.Mutex
+	// skippedPreChecks counts the clients presenting each record, keyed by
+	// its FQDN and value, which disabled the pre-check.
+	skippedPreChecks = make(map[string]int)
+)
+
+// noPreCheckProvider skips the DNS propagation check of the records it
+// presents, leaving the records of the other clients checked.
+type noPreCheckProvider struct {
+	acme.ChallengeProvider
+}
+
+// Present implements acme.ChallengeProvider
+func (p *noPreCheckProvider) Present(domain, token, keyAuth string) error {
+	installPreCheck.Do(func() {
+		legoPreCheckDNS = acme.PreCheckDNS
+		acme.PreCheckDNS = preCheckDNS
+	})
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	skipPreCheck(fqdn, value, 1)
+	if err := p.ChallengeProvider.Present(domain, token, keyAuth); err != nil {
+		// lego does not clean up a record it failed to present
+		skipPreCheck(fqdn, value, -1)
+		return err
+	}
+	return nil
+}
+
+// CleanUp implements acme.ChallengeProvider
+func (p *noPreCheckProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	defer skipPreCheck(fqdn, value, -1)
+	return p.ChallengeProvider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout implements acme.ChallengeProviderTimeout
+func (p *noPreCheckProvider) Timeout() (timeout, interval time.Duration) {
+	return providerTimeout(p.ChallengeProvider)
+}
+
+func skipPreCheck(fqdn, value string, delta int) {
+	skippedPreChecksMu.Lock()
+	defer skippedPreChecksMu.Unlock()
+	key := fqdn + " " + value
+	if skippedPreChecks[key] += delta; skippedPreChecks[key] <= 0 {
+		delete(skippedPreChecks, key)
+	}
+}
+
+// preCheckDNS reports the records presented by a noPreCheckProvider as
+// propagated, and checks the others with the PreCheckDNS of lego.
+func preCheckDNS(fqdn, value string) (bool, error) {
+	skippedPreChecksMu.Lock()
+	skipped := skippedPreChecks[fqdn+" "+value] > 0
+	skippedPreChecksMu.Unlock()
+	if skipped {
+		return true, nil
+	}
+	return legoPreCheckDNS(fqdn, value)
+}
+
+func (c *Client) setupAccount(s Store, email string) error {
 	// create a new account
-	c.Account = NewAccount(email)
+	c.Account = NewDirectoryAccount(email, c.directoryURL)
 	// try loading from etcd
-	if err := c.Account.LoadKey(ec); err != nil {
-		if client.IsKeyNotFound(err) {
+	if err := c.Account.LoadKey(s); err != nil {
+		if IsKeyNotFound(err) {
 			// The account never existed, create one
 			c.Account.GenerateKey()
 		} else {
@@ -41,72 +187,72 @@ func (c *Client) setupAccount(ec client.Client, email string) error {
 	return nil
 }
 
-func (c *Client) setupChallenge(dns, webRoot, httpAddr, tlsAddr string) error {
-	if webRoot != "" {
-		provider, err := webroot.NewHTTPProvider(webRoot)
+func (c *Client) setupChallenge(cc ChallengeConfig) error {
+	c.provider = "builtin"
+	if cc.WebRoot != "" {
+		provider, err := webroot.NewHTTPProvider(cc.WebRoot)
 		if err != nil {
 			return err
 		}
 
-		c.Client.SetChallengeProvider(acme.HTTP01, provider)
+		c.provider = "webroot"
+		c.Client.SetChallengeProvider(acme.HTTP01, &timedProvider{ChallengeProvider: provider, timer: &c.challengeTimer})
 
 		// --webroot=foo indicates that the user specifically want to do a HTTP challenge
 		// infer that the user also wants to exclude all other challenges
 		c.Client.ExcludeChallenges([]acme.Challenge{acme.DNS01, acme.TLSSNI01})
 	}
 
+	if cc.HTTPEtcd {
+		c.provider = "etcd"
+		c.Client.SetChallengeProvider(acme.HTTP01, &timedProvider{ChallengeProvider: &etcdHTTPProvider{store: c.store}, timer: &c.challengeTimer})
+		c.Client.ExcludeChallenges([]acme.Challenge{acme.DNS01, acme.TLSSNI01})
+	}
+
 	// setup HTTP port
-	if httpAddr != "" {
-		if strings.Index(httpAddr, ":") == -1 {
+	if cc.HTTPAddr != "" {
+		if strings.Index(cc.HTTPAddr, ":") == -1 {
 			return ErrAddressInvalid
 		}
 
-		c.Client.SetHTTPAddress(httpAddr)
+		c.Client.SetHTTPAddress(cc.HTTPAddr)
 	}
 
 	// setup TLS port
-	if tlsAddr != "" {
-		if strings.Index(tlsAddr, "") == -1 {
+	if cc.TLSAddr != "" {
+		if strings.Index(cc.TLSAddr, ":") == -1 {
 			return ErrAddressInvalid
 		}
 
-		c.Client.SetTLSAddress(tlsAddr)
+		c.Client.SetTLSAddress(cc.TLSAddr)
 	}
 
-	if dns != "" {
+	if cc.DNS != "" {
 		// setup the challenge provider
-		var (
-			err      error
-			provider acme.ChallengeProvider
-		)
-		switch dns {
-		case "cloudflare":
-			provider, err = cloudflare.NewDNSProvider()
-		case "digitalocean":
-			provider, err = digitalocean.NewDNSProvider()
-		case "dnsimple":
-			provider, err = dnsimple.NewDNSProvider()
-		case "dyn":
-			provider, err = dyn.NewDNSProvider()
-		case "gandi":
-			provider, err = gandi.NewDNSProvider()
-		case "gcloud":
-			provider, err = googlecloud.NewDNSProvider()
-		case "manual":
-			provider, err = acme.NewDNSProviderManual()
-		case "namecheap":
-			provider, err = namecheap.NewDNSProvider()
-		case "route53":
-			provider, err = route53.NewDNSProvider()
-		case "rfc2136":
-			provider, err = rfc2136.NewDNSProvider()
-		case "vultr":
-			provider, err = vultr.NewDNSProvider()
-		}
+		provider, err := newDNSProvider(cc.DNS)
 		if err != nil {
-			return fmt.Errorf("error setting up the DNS provider: %s", err)
+			return fmt.Errorf("error setting up the DNS provider %q: %s", cc.DNS, err)
+		}
+		// follow the delegation of the _acme-challenge records
+		if cc.DNSAlias != "" || cc.DNSFollowCNAME {
+			provider = &aliasProvider{ChallengeProvider: provider, alias: cc.DNSAlias}
+		}
+		// the pre-check is global to lego, only skip it for our records
+		if cc.DNSDisablePreCheck {
+			provider = &noPreCheckProvider{ChallengeProvider: provider}
 		}
-		c.Client.SetChallengeProvider(acme.DNS01, provider)
+
+		timeout := cc.DNSTimeout
+		if timeout == 0 {
+			timeout = DefaultBudgets.ChallengeWait
+		}
+
+		c.provider = cc.DNS
+		c.dnsChallenge = true
+		c.Client.SetChallengeProvider(acme.DNS01, &timedProvider{
+			ChallengeProvider: withTimeout(provider, timeout, cc.DNSPollInterval),
+			timer:             &c.challengeTimer,
+		})
 
 		// --dns=foo indicates that the user specifically want to do a DNS challenge
 		// infer that the user also wants to exclude all other challenges
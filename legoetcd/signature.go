@@ -0,0 +1,97 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// signatureKey holds the signature of the checksums of the certificate, which
+// cover the certificate, its key and its metadata.
+const signatureKey = "/lego/certificates/%s.sig"
+
+// ErrInvalidSignature is returned by VerifySignature when the certificate is
+// not signed, or not signed by the expected key.
+var ErrInvalidSignature = errors.New("the certificate is not signed by the expected key")
+
+// sign signs the SHA-256 digest of the value with an RSA (PKCS #1 v1.5) or an
+// EC key, and returns the base64-encoded signature.
+func sign(key crypto.PrivateKey, value string) (string, error) {
+	digest := sha256.Sum256([]byte(value))
+	var (
+		sig []byte
+		err error
+	)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		sig, err = k.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return "", ErrUnknowKeyType
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// verify verifies the base64-encoded signature of the value made by sign.
+func verify(pub crypto.PublicKey, value, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	digest := sha256.Sum256([]byte(value))
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil || !ecdsa.Verify(k, digest[:], rs.R, rs.S) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnknowKeyType
+	}
+	return nil
+}
+
+// VerifySignature verifies that the certificate loaded from etcd was saved by
+// the holder of the private key of pub, see Cert.SigningKey, so the consumers
+// with only read access can detect a certificate substituted by someone with
+// write access to etcd. It returns ErrInvalidSignature otherwise.
+func (c *Cert) VerifySignature(s Store, pub crypto.PublicKey) error {
+	stored := StorageName(c.Domains[0])
+	sums, err := c.get(s, fmt.Sprintf(sumsKey, stored))
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return ErrInvalidSignature
+		}
+		return err
+	}
+	signature, err := c.get(s, fmt.Sprintf(signatureKey, stored))
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return ErrInvalidSignature
+		}
+		return err
+	}
+	if err := verify(pub, sums, signature); err != nil {
+		return err
+	}
+	// the signed checksums must be those of what was loaded
+	if err := c.checkSums(sums); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
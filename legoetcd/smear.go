@@ -0,0 +1,31 @@
+package legoetcd
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// SmearedRenewalTime returns when the certificate should be renewed so the
+// renewals of an inventory are spread instead of happening in one pass. The
+// certificate is renewed at a point of the spread window ending renewBefore
+// its expiration, picked from a hash of its domains so every instance agrees
+// on it. The window never starts before the certificate is valid.
+func SmearedRenewalTime(cert *Cert, spread, renewBefore time.Duration) (time.Time, error) {
+	leaf, err := cert.Leaf()
+	if err != nil {
+		return time.Time{}, err
+	}
+	end := leaf.NotAfter.Add(-renewBefore)
+	start := end.Add(-spread)
+	if start.Before(leaf.NotBefore) {
+		start = leaf.NotBefore
+	}
+	if !end.After(start) {
+		return end, nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(cert.Domains, ",")))
+	offset := time.Duration(h.Sum64() % uint64(end.Sub(start)))
+	return start.Add(offset), nil
+}
@@ -0,0 +1,72 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// etcdSourcePrefix prefixes the sources read from an etcd key.
+const etcdSourcePrefix = "etcd://"
+
+// readSource reads the source, which is either a file, the standard input
+// written as - or an etcd key written as etcd:///path/to/key.
+func readSource(s Store, source string) ([]byte, error) {
+	if source == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	if strings.HasPrefix(source, etcdSourcePrefix) {
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		defer cancelFunc()
+		value, err := s.Get(ctx, strings.TrimPrefix(source, etcdSourcePrefix))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(value), nil
+	}
+	return ioutil.ReadFile(source)
+}
+
+// LoadPrivateKey reads the PEM-encoded RSA or EC private key from a file, or
+// from an etcd key written as etcd:///path/to/key, to obtain the certificates
+// with a key generated by another PKI process.
+func LoadPrivateKey(s Store, source string) (crypto.PrivateKey, error) {
+	data, err := readSource(s, source)
+	if err != nil {
+		return nil, err
+	}
+	return parsePEMPrivateKey(data)
+}
+
+// LoadPublicKey reads the PEM-encoded public key, or the public key of a
+// certificate or of a private key, from a file or from an etcd key written as
+// etcd:///path/to/key, to verify the signature of the certificates.
+func LoadPublicKey(s Store, source string) (crypto.PublicKey, error) {
+	data, err := readSource(s, source)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrUnknowKeyType
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		key, err := parsePEMPrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return publicKey(key), nil
+	}
+}
@@ -0,0 +1,180 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// ErrInvalidSPIFFEID is returned when a SPIFFE ID is not of the form
+// spiffe://trust-domain/path.
+var ErrInvalidSPIFFEID = errors.New("invalid SPIFFE ID, expected spiffe://trust-domain/path")
+
+// SVID is an X.509 SPIFFE Verifiable Identity Document built from a stored
+// certificate.
+type SVID struct {
+	// ID is the SPIFFE ID carried in the URI SAN of the certificate.
+	ID *url.URL
+	// Certificates is the certificate chain, the leaf being the first one.
+	Certificates []*x509.Certificate
+	// PrivateKey is the private key of the leaf certificate.
+	PrivateKey crypto.PrivateKey
+	// Bundle holds the issuer certificates of the chain.
+	Bundle []*x509.Certificate
+}
+
+// ParseSPIFFEID parses and validates a SPIFFE ID.
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidSPIFFEID
+	}
+	if u.Scheme != "spiffe" || u.Host == "" || u.Port() != "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, ErrInvalidSPIFFEID
+	}
+	if strings.ToLower(u.Host) != u.Host {
+		return nil, ErrInvalidSPIFFEID
+	}
+	return u, nil
+}
+
+// NewSPIFFECert obtains a new certificate for the domains carrying the SPIFFE
+// ID as a URI SAN. The CA must allow URI SANs, which is usually only the case
+// of an internal ACME CA.
+func (c *Client) NewSPIFFECert(domains []string, spiffeID string, bundle bool) (*Cert, map[string]error) {
+	domains, err := NormalizeDomains(domains)
+	if err != nil {
+		return nil, map[string]error{"domains": err}
+	}
+	if err := c.checkIssuance(domains, false); err != nil {
+		return nil, map[string]error{"policy": err}
+	}
+	privateKey := c.PrivateKey
+	if privateKey == nil {
+		var err error
+		if privateKey, err = generatePrivateKey(c.keyType); err != nil {
+			return nil, map[string]error{"key": err}
+		}
+	}
+	return c.newSPIFFECert(domains, spiffeID, privateKey, bundle)
+}
+
+func (c *Client) newSPIFFECert(domains []string, spiffeID string, privateKey crypto.PrivateKey, bundle bool) (*Cert, map[string]error) {
+	id, err := ParseSPIFFEID(spiffeID)
+	if err != nil {
+		return nil, map[string]error{"spiffe": err}
+	}
+	// create the CSR carrying the SPIFFE ID
+	tmpl := &x509.CertificateRequest{
+		DNSNames: domains,
+		URIs:     []*url.URL{id},
+	}
+	if len(domains) > 0 {
+		tmpl.Subject = pkix.Name{CommonName: domains[0]}
+	}
+	if c.MustStaple {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, mustStapleExtension)
+	}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, tmpl, privateKey)
+	if err != nil {
+		return nil, map[string]error{"csr": err}
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, map[string]error{"csr": err}
+	}
+	// obtain a certificate for this CSR
+	var (
+		cert     acme.CertificateResource
+		failures map[string]error
+	)
+	timing, err := c.timeIssuance(func() {
+		cert, failures = c.obtainForCSR(*csr, bundle)
+	})
+	if err != nil {
+		return nil, map[string]error{"deadline": err}
+	}
+	if len(failures) > 0 {
+		return nil, failures
+	}
+	recordIssuance(timing)
+	c.preferChain(&cert)
+	// the CSR flow does not know about our private key, store it along.
+	cert.PrivateKey, err = pemEncodePrivateKey(privateKey)
+	if err != nil {
+		return nil, map[string]error{"key": err}
+	}
+
+	return &Cert{
+		Domains: domains,
+		CSR:     csr,
+		Cert:    cert,
+		Timing:  timing,
+	}, nil
+}
+
+// SPIFFEID returns the SPIFFE ID of the certificate, or nil if the
+// certificate does not carry one.
+func (c *Cert) SPIFFEID() (*url.URL, error) {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range certs[0].URIs {
+		if u.Scheme == "spiffe" {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+// SVID returns the certificate as an SVID. It returns ErrInvalidSPIFFEID if
+// the certificate does not carry a SPIFFE ID.
+func (c *Cert) SVID() (*SVID, error) {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.SPIFFEID()
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, ErrInvalidSPIFFEID
+	}
+	privateKey, err := parsePEMPrivateKey(c.Cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SVID{
+		ID:           id,
+		Certificates: certs,
+		PrivateKey:   privateKey,
+		Bundle:       certs[1:],
+	}, nil
+}
+
+func (c *Cert) renewSPIFFE(ac *Client, spiffeID string, bundle bool) error {
+	if err := ac.checkIssuance(c.Domains, true); err != nil {
+		return err
+	}
+	privateKey, err := ac.renewalKey(c.Cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	cert, failures := ac.newSPIFFECert(c.Domains, spiffeID, privateKey, bundle)
+	for k, v := range failures {
+		return fmt.Errorf("[%s] %s", k, v)
+	}
+	c.CSR = cert.CSR
+	c.Cert = cert.Cert
+	c.Timing = cert.Timing
+	return nil
+}
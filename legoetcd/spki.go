@@ -0,0 +1,106 @@
+package legoetcd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// nextKeyKey holds the private key the certificate is renewed with next, so
+// its pin can be published ahead of the renewal.
+const nextKeyKey = "/lego/certificates/%s.next.key"
+
+// SPKIHash returns the base64 SHA-256 hash of the subject public key info of
+// the public key, the pin used by HPKP and by the DANE TLSA records with the
+// SPKI selector.
+func SPKIHash(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// SPKIHash returns the SPKI pin of the certificate.
+func (c *Cert) SPKIHash() (string, error) {
+	leaf, err := c.Leaf()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// NextSPKIHash returns the SPKI pin of the key the certificate is renewed
+// with next, or an empty string if it was not prepared.
+func (c *Cert) NextSPKIHash() (string, error) {
+	if c.NextKey == nil {
+		return "", nil
+	}
+	key, err := parsePEMPrivateKey(c.NextKey)
+	if err != nil {
+		return "", err
+	}
+	return SPKIHash(publicKey(key))
+}
+
+// NextKeyPath returns the path where the next private key of this certificate is store on etcd.
+func (c *Cert) NextKeyPath() string { return fmt.Sprintf(nextKeyKey, StorageName(c.Domains[0])) }
+
+// PrepareNextKey generates the key the certificate is renewed with next,
+// unless it was already prepared, so its pin can be published before the
+// renewal. An empty key type generates a key of the type of the current one.
+// The key is stored by Save.
+func (c *Cert) PrepareNextKey(keyType acme.KeyType) error {
+	if c.NextKey != nil {
+		return nil
+	}
+	if keyType == "" {
+		key, err := parsePEMPrivateKey(c.Cert.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if keyType, err = keyTypeOf(key); err != nil {
+			return err
+		}
+	}
+	key, err := generatePrivateKey(keyType)
+	if err != nil {
+		return err
+	}
+	c.NextKey, err = pemEncodePrivateKey(key)
+	return err
+}
+
+// loadNextKey loads the next private key of the certificate, if it was
+// prepared.
+func (c *Cert) loadNextKey(s Store) error {
+	value, err := c.get(s, c.NextKeyPath())
+	if err != nil {
+		if IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	c.NextKey = []byte(value)
+	return nil
+}
+
+// publicKey returns the public key of an RSA or EC private key.
+func publicKey(key crypto.PrivateKey) crypto.PublicKey {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,369 @@
+package legoetcd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+)
+
+var (
+	// ErrKeyNotFound is returned by a Store when the key does not exist.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrConflict is returned when a key was modified by someone else since
+	// it was read.
+	ErrConflict = errors.New("the key was modified by someone else since it was read")
+)
+
+// Store is the etcd keyspace the accounts and the certificates are stored in.
+type Store interface {
+	// Get returns the value of the key or ErrKeyNotFound.
+	Get(ctx context.Context, key string) (string, error)
+	// GetRevision returns the value of the key and the revision it was last
+	// modified at, or ErrKeyNotFound.
+	GetRevision(ctx context.Context, key string) (string, int64, error)
+	// Set sets the value of the key, creating it if necessary.
+	Set(ctx context.Context, key, value string) error
+	// Delete removes the key.
+	Delete(ctx context.Context, key string) error
+	// Keys returns all the keys under the prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	// SetAll sets the values of the keys in a single transaction if the
+	// keyspace supports it, or one after the other in order otherwise.
+	SetAll(ctx context.Context, kvs []KeyValue) error
+	// CompareAndSetAll sets the values of the keys like SetAll, only if key,
+	// which must be one of them, was last modified at rev. A zero rev
+	// requires the key not to exist. It returns ErrConflict if the key was
+	// modified, or the new revision of the key.
+	CompareAndSetAll(ctx context.Context, kvs []KeyValue, key string, rev int64) (int64, error)
+}
+
+// KeyValue is a key and its value, written by SetAll.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// IsKeyNotFound returns true if the error is a key not found error of either
+// the Store or the etcd v2 client.
+func IsKeyNotFound(err error) bool {
+	return err == ErrKeyNotFound || client.IsKeyNotFound(err)
+}
+
+// v2Store stores the keys in the etcd v2 keyspace.
+type v2Store struct {
+	kapi KeysAPI
+}
+
+// NewV2Store returns a Store backed by the etcd v2 keyspace.
+func NewV2Store(c client.Client) Store {
+	return NewV2StoreKeys(client.NewKeysAPI(c))
+}
+
+// NewV2StoreKeys returns a Store backed by the etcd v2 keyspace reached
+// through the keys API.
+func NewV2StoreKeys(kapi KeysAPI) Store {
+	return instrument(&v2Store{kapi: kapi})
+}
+
+func (s *v2Store) Get(ctx context.Context, key string) (string, error) {
+	resp, err := s.kapi.Get(ctx, key, nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return "", ErrKeyNotFound
+		}
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
+
+func (s *v2Store) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	resp, err := s.kapi.Get(ctx, key, nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return "", 0, ErrKeyNotFound
+		}
+		return "", 0, err
+	}
+	return resp.Node.Value, int64(resp.Node.ModifiedIndex), nil
+}
+
+func (s *v2Store) Set(ctx context.Context, key, value string) error {
+	_, err := s.kapi.Set(ctx, key, value, &client.SetOptions{PrevExist: client.PrevIgnore})
+	return err
+}
+
+func (s *v2Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.kapi.Delete(ctx, key, nil); err != nil && !client.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// SetAll sets the keys in order, the etcd v2 keyspace has no transactions so
+// the callers write the key the consumers watch last.
+func (s *v2Store) SetAll(ctx context.Context, kvs []KeyValue) error {
+	for _, kv := range kvs {
+		if err := s.Set(ctx, kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompareAndSetAll checks the revision of the key before writing anything,
+// writes the other keys in order and the key last with a compare-and-swap, so
+// the consumers watching the key read the other keys already written. Without
+// transactions, a writer racing between the check and the swap may still
+// overwrite the other keys, the callers hold a lock to prevent that.
+func (s *v2Store) CompareAndSetAll(ctx context.Context, kvs []KeyValue, key string, rev int64) (int64, error) {
+	var (
+		value string
+		found bool
+	)
+	for _, kv := range kvs {
+		if kv.Key == key {
+			value, found = kv.Value, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("the key %s to compare is not written", key)
+	}
+	_, current, err := s.GetRevision(ctx, key)
+	if err != nil && err != ErrKeyNotFound {
+		return 0, err
+	}
+	if current != rev {
+		return 0, ErrConflict
+	}
+	for _, kv := range kvs {
+		if kv.Key == key {
+			continue
+		}
+		if err := s.Set(ctx, kv.Key, kv.Value); err != nil {
+			return 0, err
+		}
+	}
+	opts := &client.SetOptions{PrevExist: client.PrevNoExist}
+	if rev != 0 {
+		opts = &client.SetOptions{PrevIndex: uint64(rev)}
+	}
+	resp, err := s.kapi.Set(ctx, key, value, opts)
+	if err != nil {
+		if cerr, ok := err.(client.Error); ok && (cerr.Code == client.ErrorCodeTestFailed || cerr.Code == client.ErrorCodeNodeExist || cerr.Code == client.ErrorCodeKeyNotFound) {
+			return 0, ErrConflict
+		}
+		return 0, err
+	}
+	return int64(resp.Node.ModifiedIndex), nil
+}
+
+func (s *v2Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := s.kapi.Get(ctx, prefix, &client.GetOptions{Recursive: true})
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []string
+	var walk func(node *client.Node)
+	walk = func(node *client.Node) {
+		if !node.Dir {
+			keys = append(keys, node.Key)
+		}
+		for _, child := range node.Nodes {
+			walk(child)
+		}
+	}
+	walk(resp.Node)
+	return keys, nil
+}
+
+// v3Store stores the keys in the etcd v3 keyspace.
+type v3Store struct {
+	c *clientv3.Client
+}
+
+// NewV3Store returns a Store backed by the etcd v3 keyspace.
+func NewV3Store(c *clientv3.Client) Store {
+	return instrument(&v3Store{c: c})
+}
+
+func (s *v3Store) Get(ctx context.Context, key string) (string, error) {
+	resp, err := s.c.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", ErrKeyNotFound
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (s *v3Store) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	resp, err := s.c.Get(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", 0, ErrKeyNotFound
+	}
+	return string(resp.Kvs[0].Value), resp.Kvs[0].ModRevision, nil
+}
+
+func (s *v3Store) Set(ctx context.Context, key, value string) error {
+	_, err := s.c.Put(ctx, key, value)
+	return err
+}
+
+func (s *v3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.c.Delete(ctx, key)
+	return err
+}
+
+func (s *v3Store) SetAll(ctx context.Context, kvs []KeyValue) error {
+	ops := make([]clientv3.Op, 0, len(kvs))
+	for _, kv := range kvs {
+		ops = append(ops, clientv3.OpPut(kv.Key, kv.Value))
+	}
+	_, err := s.c.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+func (s *v3Store) CompareAndSetAll(ctx context.Context, kvs []KeyValue, key string, rev int64) (int64, error) {
+	ops := make([]clientv3.Op, 0, len(kvs))
+	for _, kv := range kvs {
+		ops = append(ops, clientv3.OpPut(kv.Key, kv.Value))
+	}
+	// the revision of a missing key compares equal to zero
+	resp, err := s.c.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).Then(ops...).Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, ErrConflict
+	}
+	return resp.Header.Revision, nil
+}
+
+func (s *v3Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := s.c.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+// dualStore writes the keys to both the legacy and the new keyspace while the
+// consumers migrate, the legacy keyspace remains the source of truth.
+type dualStore struct {
+	legacy Store
+	next   Store
+}
+
+// NewDualStore returns a Store reading from the legacy store and writing to
+// both stores.
+func NewDualStore(legacy, next Store) Store {
+	return &dualStore{legacy: legacy, next: next}
+}
+
+func (s *dualStore) Get(ctx context.Context, key string) (string, error) {
+	return s.legacy.Get(ctx, key)
+}
+
+func (s *dualStore) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	return s.legacy.GetRevision(ctx, key)
+}
+
+func (s *dualStore) Set(ctx context.Context, key, value string) error {
+	if err := s.legacy.Set(ctx, key, value); err != nil {
+		return err
+	}
+	return s.next.Set(ctx, key, value)
+}
+
+func (s *dualStore) Delete(ctx context.Context, key string) error {
+	if err := s.legacy.Delete(ctx, key); err != nil {
+		return err
+	}
+	return s.next.Delete(ctx, key)
+}
+
+func (s *dualStore) SetAll(ctx context.Context, kvs []KeyValue) error {
+	if err := s.legacy.SetAll(ctx, kvs); err != nil {
+		return err
+	}
+	return s.next.SetAll(ctx, kvs)
+}
+
+// CompareAndSetAll compares the revision of the legacy keyspace, the source of
+// truth, and mirrors the keys to the new keyspace.
+func (s *dualStore) CompareAndSetAll(ctx context.Context, kvs []KeyValue, key string, rev int64) (int64, error) {
+	newRev, err := s.legacy.CompareAndSetAll(ctx, kvs, key, rev)
+	if err != nil {
+		return 0, err
+	}
+	return newRev, s.next.SetAll(ctx, kvs)
+}
+
+func (s *dualStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return s.legacy.Keys(ctx, prefix)
+}
+
+// lockSuffixes are the suffixes of the lock keys, which are not copied.
+var lockSuffixes = []string{"/lock", ".lock"}
+
+// CopyStore copies the accounts and the certificates from one store to
+// another, for instance from the etcd v2 to the v3 keyspace. The locks are
+// not copied. It returns the number of keys copied.
+func CopyStore(from, to Store) (int, error) {
+	ctx, cancelFunc := DefaultBudgets.EtcdContext()
+	keys, err := from.Keys(ctx, "/lego/")
+	cancelFunc()
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, key := range keys {
+		if isLockKey(key) {
+			continue
+		}
+		ctx, cancelFunc := DefaultBudgets.EtcdContext()
+		value, err := from.Get(ctx, key)
+		cancelFunc()
+		if err != nil {
+			if IsKeyNotFound(err) {
+				// removed since it was listed
+				continue
+			}
+			return copied, fmt.Errorf("error reading %s: %s", key, err)
+		}
+		ctx, cancelFunc = DefaultBudgets.EtcdContext()
+		err = to.Set(ctx, key, value)
+		cancelFunc()
+		if err != nil {
+			return copied, fmt.Errorf("error writing %s: %s", key, err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+// isLockKey returns true if the key is a lock rather than data.
+func isLockKey(key string) bool {
+	for _, suffix := range lockSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
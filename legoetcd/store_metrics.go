@@ -0,0 +1,80 @@
+package legoetcd
+
+import (
+	"expvar"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// etcdMetrics exposes the number, the errors and the latency of the etcd
+// requests by operation through expvar.
+var etcdMetrics = expvar.NewMap("legoetcd_etcd")
+
+// instrumentedStore records the etcd requests of a store in etcdMetrics.
+type instrumentedStore struct {
+	Store
+}
+
+// instrument returns the store recording its requests in etcdMetrics.
+func instrument(s Store) Store {
+	return &instrumentedStore{Store: s}
+}
+
+// observe records a request started at start.
+func observe(op string, start time.Time, err error) {
+	etcdMetrics.Add(op+".count", 1)
+	etcdMetrics.AddFloat(op+".seconds", time.Since(start).Seconds())
+	if err != nil && !IsKeyNotFound(err) && err != ErrConflict {
+		etcdMetrics.Add(op+".errors", 1)
+	}
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	value, err := s.Store.Get(ctx, key)
+	observe("get", start, err)
+	return value, err
+}
+
+func (s *instrumentedStore) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	start := time.Now()
+	value, rev, err := s.Store.GetRevision(ctx, key)
+	observe("get", start, err)
+	return value, rev, err
+}
+
+func (s *instrumentedStore) Set(ctx context.Context, key, value string) error {
+	start := time.Now()
+	err := s.Store.Set(ctx, key, value)
+	observe("set", start, err)
+	return err
+}
+
+func (s *instrumentedStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.Store.Delete(ctx, key)
+	observe("delete", start, err)
+	return err
+}
+
+func (s *instrumentedStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := s.Store.Keys(ctx, prefix)
+	observe("keys", start, err)
+	return keys, err
+}
+
+func (s *instrumentedStore) SetAll(ctx context.Context, kvs []KeyValue) error {
+	start := time.Now()
+	err := s.Store.SetAll(ctx, kvs)
+	observe("set_all", start, err)
+	return err
+}
+
+func (s *instrumentedStore) CompareAndSetAll(ctx context.Context, kvs []KeyValue, key string, rev int64) (int64, error) {
+	start := time.Now()
+	newRev, err := s.Store.CompareAndSetAll(ctx, kvs, key, rev)
+	observe("compare_and_set_all", start, err)
+	return newRev, err
+}
@@ -0,0 +1,195 @@
+package legoetcd_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+// stores returns a new store of each kind, backed by the in-memory keyspaces.
+func stores() map[string]legoetcd.Store {
+	return map[string]legoetcd.Store{
+		"v2":     legoetcd.NewV2StoreKeys(legoetcdtest.NewKeysAPI()),
+		"memory": legoetcdtest.NewStore(),
+		"dual":   legoetcd.NewDualStore(legoetcd.NewV2StoreKeys(legoetcdtest.NewKeysAPI()), legoetcdtest.NewStore()),
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s legoetcd.Store)
+	}{
+		{"get missing", func(t *testing.T, s legoetcd.Store) {
+			if _, err := s.Get(ctx, "/lego/missing"); !legoetcd.IsKeyNotFound(err) {
+				t.Fatalf("want a key not found error, got %v", err)
+			}
+		}},
+		{"set and get", func(t *testing.T, s legoetcd.Store) {
+			if err := s.Set(ctx, "/lego/a", "1"); err != nil {
+				t.Fatal(err)
+			}
+			value, rev, err := s.GetRevision(ctx, "/lego/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != "1" || rev == 0 {
+				t.Fatalf("want 1 at a non-zero revision, got %q at %d", value, rev)
+			}
+		}},
+		{"delete", func(t *testing.T, s legoetcd.Store) {
+			if err := s.Set(ctx, "/lego/a", "1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete(ctx, "/lego/a"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get(ctx, "/lego/a"); !legoetcd.IsKeyNotFound(err) {
+				t.Fatalf("want a key not found error, got %v", err)
+			}
+		}},
+		{"keys", func(t *testing.T, s legoetcd.Store) {
+			if err := s.SetAll(ctx, []legoetcd.KeyValue{
+				{Key: "/lego/certificates/b.cert", Value: "b"},
+				{Key: "/lego/certificates/a.cert", Value: "a"},
+				{Key: "/lego/accounts/x/key", Value: "x"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := s.Keys(ctx, "/lego/certificates/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"/lego/certificates/a.cert", "/lego/certificates/b.cert"}
+			if !reflect.DeepEqual(keys, want) {
+				t.Fatalf("want %v, got %v", want, keys)
+			}
+		}},
+		{"keys of a missing prefix", func(t *testing.T, s legoetcd.Store) {
+			keys, err := s.Keys(ctx, "/lego/certificates/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Fatalf("want no keys, got %v", keys)
+			}
+		}},
+		{"create with compare-and-set", func(t *testing.T, s legoetcd.Store) {
+			kvs := []legoetcd.KeyValue{{Key: "/lego/a.json", Value: "meta"}, {Key: "/lego/a.cert", Value: "cert"}}
+			rev, err := s.CompareAndSetAll(ctx, kvs, "/lego/a.cert", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, current, _ := s.GetRevision(ctx, "/lego/a.cert"); current != rev {
+				t.Fatalf("want the revision %d, got %d", rev, current)
+			}
+			if _, err := s.CompareAndSetAll(ctx, kvs, "/lego/a.cert", 0); err != legoetcd.ErrConflict {
+				t.Fatalf("want ErrConflict creating it again, got %v", err)
+			}
+		}},
+		{"update with compare-and-set", func(t *testing.T, s legoetcd.Store) {
+			if err := s.Set(ctx, "/lego/a.cert", "old"); err != nil {
+				t.Fatal(err)
+			}
+			_, rev, err := s.GetRevision(ctx, "/lego/a.cert")
+			if err != nil {
+				t.Fatal(err)
+			}
+			kvs := []legoetcd.KeyValue{{Key: "/lego/a.json", Value: "meta"}, {Key: "/lego/a.cert", Value: "new"}}
+			newRev, err := s.CompareAndSetAll(ctx, kvs, "/lego/a.cert", rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if newRev <= rev {
+				t.Fatalf("want a revision after %d, got %d", rev, newRev)
+			}
+			if value, _ := s.Get(ctx, "/lego/a.cert"); value != "new" {
+				t.Fatalf("want new, got %q", value)
+			}
+		}},
+		{"conflicting compare-and-set writes nothing", func(t *testing.T, s legoetcd.Store) {
+			if err := s.SetAll(ctx, []legoetcd.KeyValue{{Key: "/lego/a.json", Value: "theirs"}, {Key: "/lego/a.cert", Value: "theirs"}}); err != nil {
+				t.Fatal(err)
+			}
+			_, rev, err := s.GetRevision(ctx, "/lego/a.cert")
+			if err != nil {
+				t.Fatal(err)
+			}
+			kvs := []legoetcd.KeyValue{{Key: "/lego/a.json", Value: "ours"}, {Key: "/lego/a.cert", Value: "ours"}}
+			if _, err := s.CompareAndSetAll(ctx, kvs, "/lego/a.cert", rev-1); err != legoetcd.ErrConflict {
+				t.Fatalf("want ErrConflict, got %v", err)
+			}
+			for _, key := range []string{"/lego/a.json", "/lego/a.cert"} {
+				if value, _ := s.Get(ctx, key); value != "theirs" {
+					t.Errorf("want %s untouched, got %q", key, value)
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		for kind, s := range stores() {
+			s := s
+			t.Run(kind+"/"+tt.name, func(t *testing.T) { tt.run(t, s) })
+		}
+	}
+}
+
+// recordingKeys is a KeysAPI recording the keys set, in order.
+type recordingKeys struct {
+	*legoetcdtest.KeysAPI
+	sets []string
+}
+
+func (k *recordingKeys) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	k.sets = append(k.sets, key)
+	return k.KeysAPI.Set(ctx, key, value, opts)
+}
+
+func TestV2StoreCompareAndSetAllOrder(t *testing.T) {
+	ctx := context.Background()
+	kvs := []legoetcd.KeyValue{
+		{Key: "/lego/a.cert", Value: "cert"},
+		{Key: "/lego/a.key", Value: "key"},
+		{Key: "/lego/a.json", Value: "meta"},
+	}
+	tests := []struct {
+		name string
+		// seed creates the key before the compare-and-set
+		seed bool
+	}{
+		{"create", false},
+		{"update", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kapi := &recordingKeys{KeysAPI: legoetcdtest.NewKeysAPI()}
+			s := legoetcd.NewV2StoreKeys(kapi)
+			var rev int64
+			if tt.seed {
+				if err := s.Set(ctx, "/lego/a.cert", "old"); err != nil {
+					t.Fatal(err)
+				}
+				_, current, err := s.GetRevision(ctx, "/lego/a.cert")
+				if err != nil {
+					t.Fatal(err)
+				}
+				rev = current
+				kapi.sets = nil
+			}
+			if _, err := s.CompareAndSetAll(ctx, kvs, "/lego/a.cert", rev); err != nil {
+				t.Fatal(err)
+			}
+			// the consumers watch the key, it must be written last
+			want := []string{"/lego/a.key", "/lego/a.json", "/lego/a.cert"}
+			if !reflect.DeepEqual(kapi.sets, want) {
+				t.Fatalf("want the keys written in the order %v, got %v", want, kapi.sets)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Package swarm rotates the Docker Swarm secrets holding a certificate, for
+// the services which cannot reload their certificate from disk.
+package swarm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultHost is the socket of the local Docker engine.
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Client talks to the Docker engine API of a Swarm manager.
+type Client struct {
+	host       string
+	httpClient *http.Client
+}
+
+// New returns a client of the Docker engine listening on host, either
+// unix:///path/to/socket or tcp://host:port.
+func New(host string) (*Client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{httpClient: &http.Client{Timeout: time.Minute}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		c.host = "http://docker"
+		c.httpClient.Transport = &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		}
+	case "tcp", "http":
+		c.host = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q", host)
+	}
+	return c, nil
+}
+
+// RotateSecret creates a new version of the secret, named after the prefix and
+// the hash of data, and updates the services using a previous version so they
+// mount the new one at the same path. The previous versions are removed once
+// no service uses them anymore. Nothing is done if the current version already holds
+// data. It returns the name of the current version.
+func (c *Client) RotateSecret(prefix string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	name := prefix + "-" + hex.EncodeToString(sum[:])[:12]
+	secrets, err := c.secrets(prefix)
+	if err != nil {
+		return "", err
+	}
+	id, ok := secrets[name]
+	if !ok {
+		var created struct{ ID string }
+		err := c.do("POST", "/secrets/create", map[string]interface{}{
+			"Name":   name,
+			"Data":   base64.StdEncoding.EncodeToString(data),
+			"Labels": map[string]string{"lego-etcd.secret": prefix},
+		}, &created)
+		if err != nil {
+			return "", fmt.Errorf("error creating the secret %s: %s", name, err)
+		}
+		id = created.ID
+	}
+	if err := c.updateServices(prefix, name, id); err != nil {
+		return "", err
+	}
+	// remove the previous versions, docker refuses to remove the ones still
+	// used by a service being updated which are removed by the next rotation
+	for old, oldID := range secrets {
+		if old != name {
+			c.do("DELETE", "/secrets/"+oldID, nil, nil)
+		}
+	}
+	return name, nil
+}
+
+// secrets returns the IDs of the versions of the secret, by name.
+func (c *Client) secrets(prefix string) (map[string]string, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {"lego-etcd.secret=" + prefix}})
+	var list []struct {
+		ID   string
+		Spec struct{ Name string }
+	}
+	if err := c.do("GET", "/secrets?filters="+url.QueryEscape(string(filters)), nil, &list); err != nil {
+		return nil, fmt.Errorf("error listing the secrets: %s", err)
+	}
+	secrets := make(map[string]string)
+	for _, s := range list {
+		secrets[s.Spec.Name] = s.ID
+	}
+	return secrets, nil
+}
+
+// updateServices points the services mounting a version of the secret to the
+// current one.
+func (c *Client) updateServices(prefix, name, id string) error {
+	var services []struct {
+		ID      string
+		Version struct{ Index uint64 }
+		Spec    map[string]interface{}
+	}
+	if err := c.do("GET", "/services", nil, &services); err != nil {
+		return fmt.Errorf("error listing the services: %s", err)
+	}
+	for _, service := range services {
+		taskTemplate, _ := service.Spec["TaskTemplate"].(map[string]interface{})
+		containerSpec, _ := taskTemplate["ContainerSpec"].(map[string]interface{})
+		secrets, _ := containerSpec["Secrets"].([]interface{})
+		updated := false
+		for _, s := range secrets {
+			secret, _ := s.(map[string]interface{})
+			secretName, _ := secret["SecretName"].(string)
+			if secretName == name || !strings.HasPrefix(secretName, prefix+"-") {
+				continue
+			}
+			secret["SecretName"] = name
+			secret["SecretID"] = id
+			updated = true
+		}
+		if !updated {
+			continue
+		}
+		path := fmt.Sprintf("/services/%s/update?version=%d", service.ID, service.Version.Index)
+		if err := c.do("POST", path, service.Spec, nil); err != nil {
+			return fmt.Errorf("error updating the service %s: %s", service.ID, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		jsonBytes, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(jsonBytes)
+	}
+	req, err := http.NewRequest(method, c.host+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("docker answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
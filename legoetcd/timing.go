@@ -0,0 +1,203 @@
+package legoetcd
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// issuanceMetrics exposes the issuance timings, keyed by provider, through
+// expvar.
+var issuanceMetrics = expvar.NewMap("legoetcd_issuance")
+
+// IssuanceTiming records how long an issuance took.
+type IssuanceTiming struct {
+	// Provider is the name of the provider that solved the challenges.
+	Provider string `json:"provider"`
+	// Challenge is the time spent solving the challenges, from presenting the
+	// token to its clean up; it includes waiting for the DNS propagation and
+	// the validation by the ACME server. It is only measured for the DNS and
+	// the webroot providers.
+	Challenge time.Duration `json:"challenge"`
+	// Request is the time spent outside of the challenges, creating the
+	// authorizations and requesting the certificate.
+	Request time.Duration `json:"request"`
+	// Order is the time spent creating the authorizations of the domains.
+	// ACME v1, which lego speaks, has no orders, the authorizations stand for
+	// the creation of one. It is part of Request.
+	Order time.Duration `json:"order"`
+	// Finalize is the time spent requesting the certificate once the
+	// challenges are validated, the ACME v1 counterpart of the finalization
+	// of an order. It is part of Request.
+	Finalize time.Duration `json:"finalize"`
+	// Total is the total time of the issuance.
+	Total time.Duration `json:"total"`
+	// IssuedAt is the time the certificate was issued at.
+	IssuedAt time.Time `json:"issuedAt"`
+}
+
+// challengeTimer accumulates the time the challenges were presented for.
+type challengeTimer struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+	elapsed time.Duration
+}
+
+func (t *challengeTimer) start(domain, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started == nil {
+		t.started = make(map[string]time.Time)
+	}
+	t.started[domain+token] = time.Now()
+}
+
+func (t *challengeTimer) stop(domain, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start, ok := t.started[domain+token]; ok {
+		t.elapsed += time.Since(start)
+		delete(t.started, domain+token)
+	}
+}
+
+// reset returns the accumulated time and resets the timer.
+func (t *challengeTimer) reset() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elapsed := t.elapsed
+	t.elapsed = 0
+	t.started = nil
+	return elapsed
+}
+
+// phaseTimer accumulates the time spent in the requests of the phases of an
+// issuance, timed by the authzRecorder.
+type phaseTimer struct {
+	mu       sync.Mutex
+	order    time.Duration
+	finalize time.Duration
+}
+
+var (
+	// phaseMu protects phaseTimers.
+	phaseMu sync.Mutex
+	// phaseTimers are the timers of the issuances in progress by account key,
+	// see publicKeyID.
+	phaseTimers = make(map[string][]*phaseTimer)
+)
+
+// recordPhase adds the time the request took to the timers of the issuances
+// of its account.
+func recordPhase(r acmeRequest, elapsed time.Duration) {
+	if r.accountKey == "" {
+		return
+	}
+	phaseMu.Lock()
+	defer phaseMu.Unlock()
+	for _, t := range phaseTimers[r.accountKey] {
+		t.mu.Lock()
+		switch r.resource {
+		case "new-authz":
+			t.order += elapsed
+		case "new-cert":
+			t.finalize += elapsed
+		}
+		t.mu.Unlock()
+	}
+}
+
+// startPhases starts timing the phases of the issuances of the account and
+// returns the timer.
+func startPhases(accountKey string) *phaseTimer {
+	t := &phaseTimer{}
+	if accountKey == "" {
+		return t
+	}
+	installAuthzRecorder()
+	phaseMu.Lock()
+	defer phaseMu.Unlock()
+	phaseTimers[accountKey] = append(phaseTimers[accountKey], t)
+	return t
+}
+
+// stop stops timing the phases and returns the time spent in each.
+func (t *phaseTimer) stop(accountKey string) (order, finalize time.Duration) {
+	phaseMu.Lock()
+	timers := phaseTimers[accountKey]
+	for i, timer := range timers {
+		if timer == t {
+			timers = append(timers[:i:i], timers[i+1:]...)
+			break
+		}
+	}
+	if len(timers) == 0 {
+		delete(phaseTimers, accountKey)
+	} else {
+		phaseTimers[accountKey] = timers
+	}
+	phaseMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.order, t.finalize
+}
+
+// timedProvider records the time a challenge is presented for.
+type timedProvider struct {
+	acme.ChallengeProvider
+	timer *challengeTimer
+}
+
+// Present implements acme.ChallengeProvider
+func (p *timedProvider) Present(domain, token, keyAuth string) error {
+	p.timer.start(domain, token)
+	return p.ChallengeProvider.Present(domain, token, keyAuth)
+}
+
+// CleanUp implements acme.ChallengeProvider
+func (p *timedProvider) CleanUp(domain, token, keyAuth string) error {
+	p.timer.stop(domain, token)
+	return p.ChallengeProvider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout implements acme.ChallengeProviderTimeout
+func (p *timedProvider) Timeout() (timeout, interval time.Duration) {
+	return providerTimeout(p.ChallengeProvider)
+}
+
+// timeIssuance runs f within the Issuance budget and returns the timing of the
+// issuance it performed.
+func (c *Client) timeIssuance(f func()) (*IssuanceTiming, error) {
+	c.challengeTimer.reset()
+	accountKey := c.accountKeyID()
+	phases := startPhases(accountKey)
+	start := time.Now()
+	err := withIssuanceDeadline(f)
+	order, finalize := phases.stop(accountKey)
+	if err != nil {
+		return nil, err
+	}
+	total := time.Since(start)
+	challenge := c.challengeTimer.reset()
+	return &IssuanceTiming{
+		Provider:  c.provider,
+		Challenge: challenge,
+		Request:   total - challenge,
+		Order:     order,
+		Finalize:  finalize,
+		Total:     total,
+		IssuedAt:  time.Now(),
+	}, nil
+}
+
+// recordIssuance publishes the timing of a successful issuance to the metrics.
+func recordIssuance(timing *IssuanceTiming) {
+	issuanceMetrics.Add(timing.Provider+".count", 1)
+	issuanceMetrics.AddFloat(timing.Provider+".challenge_seconds", timing.Challenge.Seconds())
+	issuanceMetrics.AddFloat(timing.Provider+".request_seconds", timing.Request.Seconds())
+	issuanceMetrics.AddFloat(timing.Provider+".order_seconds", timing.Order.Seconds())
+	issuanceMetrics.AddFloat(timing.Provider+".finalize_seconds", timing.Finalize.Seconds())
+	issuanceMetrics.AddFloat(timing.Provider+".total_seconds", timing.Total.Seconds())
+}
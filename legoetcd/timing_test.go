@@ -0,0 +1,63 @@
+package legoetcd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+func TestTimeIssuancePhases(t *testing.T) {
+	// the CA takes its time creating the authorizations and the certificate
+	delays := map[string]time.Duration{"/new-authz": 20 * time.Millisecond, "/new-cert": 40 * time.Millisecond}
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delays[r.URL.Path])
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ca.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(key *ecdsa.PrivateKey, resource string) {
+		body := signedBody(t, key, map[string]interface{}{"resource": resource})
+		resp, err := acme.HTTPClient.Post(ca.URL+"/"+resource, "application/jose+json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	c := &Client{Account: &Account{key: key}, provider: "http"}
+	timing, err := c.timeIssuance(func() {
+		post(key, "new-authz")
+		post(key, "new-authz")
+		// the requests of other accounts are not part of the issuance
+		post(other, "new-cert")
+		post(key, "new-cert")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing.Order < 40*time.Millisecond || timing.Order >= timing.Total {
+		t.Errorf("want the order timed from the authorizations, got %s of %s", timing.Order, timing.Total)
+	}
+	if timing.Finalize < 40*time.Millisecond || timing.Finalize >= 80*time.Millisecond {
+		t.Errorf("want the finalization timed from the certificate of the account, got %s", timing.Finalize)
+	}
+	phaseMu.Lock()
+	defer phaseMu.Unlock()
+	if len(phaseTimers) != 0 {
+		t.Errorf("want the phases of the issuance no longer timed, got %v", phaseTimers)
+	}
+}
@@ -0,0 +1,91 @@
+package legoetcd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+)
+
+// InconsistentCertError is returned when the certificate, its key and its
+// chain do not belong together, by Save which writes nothing to etcd and by
+// LoadCert and Reload for corrupt or mismatched data.
+type InconsistentCertError struct {
+	// Domain is the domain the certificate is stored under.
+	Domain string
+	// Reason describes the inconsistency.
+	Reason string
+}
+
+func (e *InconsistentCertError) Error() string {
+	return fmt.Sprintf("inconsistent certificate for %s: %s", e.Domain, e.Reason)
+}
+
+// Validate verifies the certificate like LoadCert, see verify, and that it
+// covers all its domains. It returns an *InconsistentCertError otherwise.
+func (c *Cert) Validate() error {
+	certs, err := c.verify()
+	if err != nil {
+		return err
+	}
+	for _, domain := range c.Domains {
+		if _, ok := covers(certs[0], domain); !ok {
+			return c.inconsistent("the certificate does not cover %s", domain)
+		}
+	}
+	return nil
+}
+
+// verify parses the certificate and its chain, completed with the issuers
+// stored along, and verifies that the private key, if any, matches the public
+// key of the certificate and that each certificate of the chain is signed by
+// the next one. It returns an *InconsistentCertError otherwise.
+func (c *Cert) verify() ([]*x509.Certificate, error) {
+	certs, err := parsePEMBundle(c.Cert.Certificate)
+	if err != nil {
+		return nil, c.inconsistent("%s", err)
+	}
+	leaf := certs[0]
+	if c.Cert.PrivateKey != nil {
+		key, err := parsePEMPrivateKey(c.Cert.PrivateKey)
+		if err != nil {
+			return nil, c.inconsistent("invalid private key: %s", err)
+		}
+		pub, err := x509.MarshalPKIXPublicKey(publicKey(key))
+		if err != nil {
+			return nil, c.inconsistent("invalid private key: %s", err)
+		}
+		if !bytes.Equal(pub, leaf.RawSubjectPublicKeyInfo) {
+			return nil, c.inconsistent("the private key does not match the certificate")
+		}
+	}
+	// the issuers stored along complete an unbundled chain
+	if len(certs) == 1 && len(c.Cert.IssuerCertificate) > 0 {
+		issuers, err := parsePEMBundle(c.Cert.IssuerCertificate)
+		if err != nil {
+			return nil, c.inconsistent("invalid issuer certificate: %s", err)
+		}
+		certs = append(certs, issuers...)
+	}
+	for i := 0; i < len(certs)-1; i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return nil, c.inconsistent("%q is not signed by %q: %s", certs[i].Subject.CommonName, certs[i+1].Subject.CommonName, err)
+		}
+	}
+	return certs, nil
+}
+
+// parseLoaded verifies the certificate loaded from etcd and populates
+// LeafCert and ChainCerts.
+func (c *Cert) parseLoaded() error {
+	certs, err := c.verify()
+	if err != nil {
+		return err
+	}
+	c.LeafCert = certs[0]
+	c.ChainCerts = certs[1:]
+	return nil
+}
+
+func (c *Cert) inconsistent(format string, args ...interface{}) error {
+	return &InconsistentCertError{Domain: c.Cert.Domain, Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,6 @@
+package legoetcd
+
+// Version is the version of lego-etcd, recorded in the metadata of the
+// certificates it writes. It is set at build time with
+// -ldflags "-X github.com/kalbasit/lego-etcd/legoetcd.Version=v1.2.3".
+var Version = "dev"
@@ -0,0 +1,174 @@
+package legoetcd
+
+import (
+	"crypto"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// DefaultWatchPollInterval is how often a Watcher reloads the certificate by
+// default.
+const DefaultWatchPollInterval = time.Minute
+
+// Watcher reads and watches a certificate in etcd, for the processes consuming
+// the certificates which must never be able to issue them: it needs no ACME
+// client, no account and grabs no lock, only read access to the certificates.
+type Watcher struct {
+	// PollInterval is how often the certificate is reloaded in case a change
+	// was missed, or how often it is polled without an etcd v2 client. It
+	// defaults to DefaultWatchPollInterval.
+	PollInterval time.Duration
+	// VerifyKey, if set, is the public key the certificate must be signed
+	// with, see Cert.VerifySignature. A certificate which is not is logged
+	// and not published.
+	VerifyKey crypto.PublicKey
+
+	store   Store
+	kapi    KeyWatcher
+	domains []string
+
+	mu       sync.Mutex
+	current  *Cert
+	updates  chan *Cert
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWatcher returns a watcher of the certificate of domains in the store.
+// The certificate is watched in the etcd v2 keyspace with kapi, if it is not
+// nil, and polled otherwise, which is the only way to notice the changes in
+// the v3 keyspace. Start must be called to start watching.
+func NewWatcher(s Store, kapi KeyWatcher, domains []string) *Watcher {
+	return &Watcher{
+		store:   s,
+		kapi:    kapi,
+		domains: domains,
+		updates: make(chan *Cert, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start starts watching the certificate until Stop is called.
+func (w *Watcher) Start() {
+	go w.watch()
+}
+
+// Stop stops watching the certificate.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+// Current returns the last certificate loaded, or nil if it was not loaded
+// yet.
+func (w *Watcher) Current() *Cert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Updates returns the channel receiving the certificate when it is first
+// loaded, then every time it changes. Only the latest certificate is kept for
+// a consumer falling behind.
+func (w *Watcher) Updates() <-chan *Cert {
+	return w.updates
+}
+
+// update publishes a copy of the certificate, the watcher keeps reloading
+// its own.
+func (w *Watcher) update(cert *Cert) {
+	c := *cert
+	w.mu.Lock()
+	w.current = &c
+	w.mu.Unlock()
+	select {
+	case <-w.updates:
+	default:
+	}
+	w.updates <- &c
+}
+
+// verify verifies the signature of the certificate, if required.
+func (w *Watcher) verify(cert *Cert) error {
+	if w.VerifyKey == nil {
+		return nil
+	}
+	return cert.VerifySignature(w.store, w.VerifyKey)
+}
+
+func (w *Watcher) pollInterval() time.Duration {
+	if w.PollInterval > 0 {
+		return w.PollInterval
+	}
+	return DefaultWatchPollInterval
+}
+
+func (w *Watcher) watch() {
+	cert := &Cert{Domains: w.domains}
+	pollInterval := w.pollInterval()
+	var (
+		index        uint64
+		lastRevision int64
+	)
+	for {
+		if err := cert.Reload(w.store); err != nil {
+			if !IsKeyNotFound(err) {
+				log.Printf("error loading the certificate for %v: %s", w.domains, err)
+			}
+		} else if cert.Revision != lastRevision {
+			lastRevision = cert.Revision
+			if err := w.verify(cert); err != nil {
+				log.Printf("not publishing the certificate for %v: %s", w.domains, err)
+			} else {
+				w.update(cert)
+			}
+		}
+		if w.kapi == nil {
+			select {
+			case <-time.After(pollInterval):
+				continue
+			case <-w.stop:
+				return
+			}
+		}
+		// wait for the next change or the next poll
+		if index == 0 || index < uint64(lastRevision) {
+			index = uint64(lastRevision)
+		}
+		ctx, cancelFunc := context.WithTimeout(context.Background(), pollInterval)
+		go func() {
+			select {
+			case <-w.stop:
+				cancelFunc()
+			case <-ctx.Done():
+			}
+		}()
+		resp, err := w.kapi.Watcher(cert.CertPath(), &client.WatcherOptions{AfterIndex: index}).Next(ctx)
+		cancelFunc()
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		switch {
+		case err == nil:
+			index = resp.Node.ModifiedIndex
+		case err == context.DeadlineExceeded:
+		default:
+			if cerr, ok := err.(client.Error); ok && cerr.Code == client.ErrorCodeEventIndexCleared {
+				// start over from the current index, the reload catches up
+				index = cerr.Index
+				continue
+			}
+			log.Printf("error watching the certificate for %v: %s", w.domains, err)
+			select {
+			case <-time.After(time.Second):
+			case <-w.stop:
+				return
+			}
+		}
+	}
+}
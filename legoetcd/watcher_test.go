@@ -0,0 +1,105 @@
+package legoetcd_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/kalbasit/lego-etcd/legoetcd"
+	"github.com/kalbasit/lego-etcd/legoetcd/legoetcdtest"
+)
+
+// clearedKeys is a legoetcd.KeyWatcher whose first watch fails like etcd when
+// the index it resumes from was cleared from the history.
+type clearedKeys struct {
+	kapi *legoetcdtest.KeysAPI
+
+	mu      sync.Mutex
+	cleared bool
+}
+
+func (k *clearedKeys) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.cleared {
+		k.cleared = true
+		return clearedWatcher{index: k.kapi.Index()}
+	}
+	return k.kapi.Watcher(key, opts)
+}
+
+type clearedWatcher struct {
+	index uint64
+}
+
+func (w clearedWatcher) Next(ctx context.Context) (*client.Response, error) {
+	return nil, client.Error{Code: client.ErrorCodeEventIndexCleared, Message: "The event in requested index is outdated and cleared", Index: w.index}
+}
+
+func TestWatcher(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch func(kapi *legoetcdtest.KeysAPI) legoetcd.KeyWatcher
+	}{
+		{"watch", func(kapi *legoetcdtest.KeysAPI) legoetcd.KeyWatcher { return kapi }},
+		{"watch from a cleared index", func(kapi *legoetcdtest.KeysAPI) legoetcd.KeyWatcher { return &clearedKeys{kapi: kapi} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kapi := legoetcdtest.NewKeysAPI()
+			s := legoetcd.NewV2StoreKeys(kapi)
+			domains := []string{"example.com"}
+			ca, err := legoetcdtest.NewCA()
+			if err != nil {
+				t.Fatal(err)
+			}
+			seeded, err := ca.Issue(domains, 90*24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := seeded.Save(s, false); err != nil {
+				t.Fatal(err)
+			}
+
+			w := legoetcd.NewWatcher(s, tt.watch(kapi), domains)
+			// the changes must come from the watch, not from the polling
+			w.PollInterval = time.Hour
+			w.Start()
+			defer w.Stop()
+			first := nextUpdate(t, w)
+			if !bytes.Equal(first.Cert.Certificate, seeded.Cert.Certificate) {
+				t.Fatal("want the seeded certificate first")
+			}
+
+			renewed, err := ca.Issue(domains, 90*24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			renewed.Revision = first.Revision
+			if err := renewed.Save(s, false); err != nil {
+				t.Fatal(err)
+			}
+			second := nextUpdate(t, w)
+			if !bytes.Equal(second.Cert.Certificate, renewed.Cert.Certificate) {
+				t.Fatal("want the renewed certificate next")
+			}
+			if w.Current().Revision != second.Revision {
+				t.Errorf("want the current certificate at revision %d, got %d", second.Revision, w.Current().Revision)
+			}
+		})
+	}
+}
+
+func nextUpdate(t *testing.T, w *legoetcd.Watcher) *legoetcd.Cert {
+	select {
+	case cert := <-w.Updates():
+		return cert
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the certificate")
+	}
+	return nil
+}